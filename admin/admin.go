@@ -0,0 +1,151 @@
+//Package admin provides an HTTP API to inspect and control a running processor.
+//
+//	GET  /workers                      registered workers with their status and counters
+//	POST /workers/{id}/pause           stops passing new messages to a worker
+//	POST /workers/{id}/resume          resumes a paused worker
+//	POST /workers/{id}/concurrency     sets the pool size of a worker, e.g. size=4
+//	GET  /inflight                     messages being processed
+//	POST /drain                        stops reading messages, the processor stops once the messages being processed finish
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ottogiron/metricsworker/processor"
+)
+
+//Handler serves the admin API of a processor
+type Handler struct {
+	processor processor.Processor
+	mux       *http.ServeMux
+}
+
+//NewHandler returns a new instance of an admin API handler
+func NewHandler(p processor.Processor) *Handler {
+	h := &Handler{processor: p, mux: http.NewServeMux()}
+	h.mux.HandleFunc("/workers", h.workers)
+	h.mux.HandleFunc("/workers/", h.worker)
+	h.mux.HandleFunc("/inflight", h.inFlight)
+	h.mux.HandleFunc("/drain", h.drain)
+	return h
+}
+
+//ServeHTTP serves the admin API
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) workers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, h.processor.Workers())
+}
+
+//worker handles the actions on a worker: /workers/{id}/{action}
+func (h *Handler) worker(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/workers/"), "/")
+	if len(parts) != 2 || parts[0] == "" {
+		http.NotFound(w, r)
+		return
+	}
+	id, action := parts[0], parts[1]
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.registered(id) {
+		http.Error(w, fmt.Sprintf("Unknown worker %s", id), http.StatusNotFound)
+		return
+	}
+	var err error
+	switch action {
+	case "pause":
+		err = h.processor.Pause(id)
+	case "resume":
+		err = h.processor.Resume(id)
+	case "concurrency":
+		var size int
+		size, err = strconv.Atoi(r.FormValue("size"))
+		if err != nil || size < 1 {
+			http.Error(w, fmt.Sprintf("Invalid size %s", r.FormValue("size")), http.StatusBadRequest)
+			return
+		}
+		err = h.processor.Resize(id, size)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) inFlight(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, h.processor.InFlight())
+}
+
+func (h *Handler) drain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	h.processor.Drain()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *Handler) registered(id string) bool {
+	for _, status := range h.processor.Workers() {
+		if status.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode response %s", err), http.StatusInternalServerError)
+	}
+}
+
+//Server serves the admin API on an address
+type Server struct {
+	server   *http.Server
+	listener net.Listener
+}
+
+//Listen starts serving the admin API of a processor on address
+func Listen(address string, p processor.Processor) (*Server, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to listen on %s %s", address, err)
+	}
+	s := &Server{server: &http.Server{Handler: NewHandler(p)}, listener: listener}
+	go s.server.Serve(listener)
+	return s, nil
+}
+
+//Addr returns the address the server is listening on
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+//Close stops the server
+func (s *Server) Close() error {
+	return s.server.Close()
+}
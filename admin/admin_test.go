@@ -0,0 +1,110 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ottogiron/metricsworker/processor"
+)
+
+//fakeProcessor records the admin actions
+type fakeProcessor struct {
+	processor.Processor
+	workers  []processor.WorkerStatus
+	inFlight []processor.InFlightMessage
+	actions  []string
+	err      error
+}
+
+func (p *fakeProcessor) Workers() []processor.WorkerStatus { return p.workers }
+
+func (p *fakeProcessor) InFlight() []processor.InFlightMessage { return p.inFlight }
+
+func (p *fakeProcessor) Pause(id string) error {
+	p.actions = append(p.actions, "pause "+id)
+	return p.err
+}
+
+func (p *fakeProcessor) Resume(id string) error {
+	p.actions = append(p.actions, "resume "+id)
+	return p.err
+}
+
+func (p *fakeProcessor) Resize(id string, size int) error {
+	p.actions = append(p.actions, "resize "+id)
+	return p.err
+}
+
+func (p *fakeProcessor) Drain() {
+	p.actions = append(p.actions, "drain")
+}
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		target      string
+		err         error
+		wantStatus  int
+		wantActions []string
+	}{
+		{"Pause", http.MethodPost, "/workers/distincName/pause", nil, http.StatusNoContent, []string{"pause distincName"}},
+		{"Resume", http.MethodPost, "/workers/distincName/resume", nil, http.StatusNoContent, []string{"resume distincName"}},
+		{"Concurrency", http.MethodPost, "/workers/distincName/concurrency?size=4", nil, http.StatusNoContent, []string{"resize distincName"}},
+		{"Invalid concurrency", http.MethodPost, "/workers/distincName/concurrency?size=0", nil, http.StatusBadRequest, nil},
+		{"Unknown worker", http.MethodPost, "/workers/unknown/pause", nil, http.StatusNotFound, nil},
+		{"Unknown action", http.MethodPost, "/workers/distincName/restart", nil, http.StatusNotFound, nil},
+		{"Worker action not posted", http.MethodGet, "/workers/distincName/pause", nil, http.StatusMethodNotAllowed, nil},
+		{"Action failed", http.MethodPost, "/workers/distincName/pause", errors.New("failure"), http.StatusInternalServerError, []string{"pause distincName"}},
+		{"Drain", http.MethodPost, "/drain", nil, http.StatusAccepted, []string{"drain"}},
+		{"Drain not posted", http.MethodGet, "/drain", nil, http.StatusMethodNotAllowed, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &fakeProcessor{workers: []processor.WorkerStatus{{ID: "distincName"}}, err: tt.err}
+			rec := httptest.NewRecorder()
+			NewHandler(p).ServeHTTP(rec, httptest.NewRequest(tt.method, tt.target, nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("Handler status = %d want %d %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if !reflect.DeepEqual(p.actions, tt.wantActions) {
+				t.Errorf("Handler actions = %v want %v", p.actions, tt.wantActions)
+			}
+		})
+	}
+}
+
+func TestHandler_Workers(t *testing.T) {
+	p := &fakeProcessor{
+		workers:  []processor.WorkerStatus{{ID: "distincName", Paused: true, PoolSize: 2, Executed: 10, Failed: 1, Breaker: "closed"}},
+		inFlight: []processor.InFlightMessage{{ID: 1, MessageID: "1", Metric: "kite_call", Username: "kodingbot"}},
+	}
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"/workers", `[{"id":"distincName","paused":true,"pool_size":2,"queued":0,"running":0,"executed":10,"failed":1,"breaker":"closed"}]`},
+		{"/inflight", `[{"id":1,"message_id":"1","metric":"kite_call","username":"kodingbot","received_at":"0001-01-01T00:00:00Z"}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			NewHandler(p).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+				t.Fatalf("Handler status = %d content type = %s", rec.Code, rec.Header().Get("Content-Type"))
+			}
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.want {
+				t.Errorf("Handler body = %s want %s", got, tt.want)
+			}
+			var v interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &v); err != nil {
+				t.Errorf("Handler body is not valid JSON %s", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/ottogiron/metricsworker/logging"
+	"github.com/ottogiron/metricsworker/processor"
+	"github.com/ottogiron/metricsworker/transform"
+)
+
+//config settings read from the --config file which are reloaded on SIGHUP. Settings missing from the file keep their flag values
+type config struct {
+	LogLevel         string              `json:"log_level"`
+	RateLimit        *float64            `json:"rate_limit"`
+	RateLimitBurst   *int                `json:"rate_limit_burst"`
+	MetricRateLimits map[string]float64  `json:"metric_rate_limits"`
+	WorkerRateLimits map[string]float64  `json:"worker_rate_limits"`
+	Routes           map[string][]string `json:"routes"`
+	Workers          []string            `json:"workers"`
+	Transform        *transform.Config   `json:"transform"`
+}
+
+//settings validated configuration applied to the running processor
+type settings struct {
+	logLevel  logging.Level
+	workers   []string
+	processor processor.Settings
+}
+
+//loadSettings returns the settings of the flags overridden by the config file at path, if any
+func loadSettings(path string) (settings, error) {
+	c, err := flagConfig()
+	if err != nil {
+		return settings{}, err
+	}
+	if path != "" {
+		file, err := readConfig(path)
+		if err != nil {
+			return settings{}, err
+		}
+		c = c.merge(file)
+	}
+	return c.settings()
+}
+
+//flagConfig returns the config set by the flags
+func flagConfig() (config, error) {
+	metricRateLimits, err := parseRates(metricRateLimitsFlag)
+	if err != nil {
+		return config{}, fmt.Errorf("Invalid metric rate limits %s", err)
+	}
+	workerRateLimits, err := parseRates(workerRateLimitsFlag)
+	if err != nil {
+		return config{}, fmt.Errorf("Invalid worker rate limits %s", err)
+	}
+	rate, burst := rateLimitFlag, rateLimitBurstFlag
+	workers := append([]string(nil), workerIDs...)
+	if archiveDirFlag != "" {
+		workers = append(workers, "archive")
+	}
+	return config{
+		LogLevel:         logLevelFlag,
+		RateLimit:        &rate,
+		RateLimitBurst:   &burst,
+		MetricRateLimits: metricRateLimits,
+		WorkerRateLimits: workerRateLimits,
+		Workers:          workers,
+	}, nil
+}
+
+func readConfig(path string) (config, error) {
+	var c config
+	f, err := os.Open(path)
+	if err != nil {
+		return c, fmt.Errorf("Failed to open config %s", err)
+	}
+	defer f.Close()
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&c)
+	if err != nil {
+		return c, fmt.Errorf("Failed to decode config %s %s", path, err)
+	}
+	return c, nil
+}
+
+//merge returns the config overridden by the settings present in other
+func (c config) merge(other config) config {
+	if other.LogLevel != "" {
+		c.LogLevel = other.LogLevel
+	}
+	if other.RateLimit != nil {
+		c.RateLimit = other.RateLimit
+	}
+	if other.RateLimitBurst != nil {
+		c.RateLimitBurst = other.RateLimitBurst
+	}
+	if other.MetricRateLimits != nil {
+		c.MetricRateLimits = other.MetricRateLimits
+	}
+	if other.WorkerRateLimits != nil {
+		c.WorkerRateLimits = other.WorkerRateLimits
+	}
+	if other.Routes != nil {
+		c.Routes = other.Routes
+	}
+	if other.Workers != nil {
+		c.Workers = other.Workers
+	}
+	if other.Transform != nil {
+		c.Transform = other.Transform
+	}
+	return c
+}
+
+//settings validates the config and returns its settings
+func (c config) settings() (settings, error) {
+	var s settings
+	level, err := logging.ParseLevel(c.LogLevel)
+	if err != nil {
+		return s, err
+	}
+	s.logLevel = level
+	enabled := make(map[string]bool)
+	for _, id := range c.Workers {
+		if _, ok := workerFactories[id]; !ok {
+			return s, fmt.Errorf("Unknown worker %s", id)
+		}
+		if id == "archive" && archiveDirFlag == "" {
+			return s, fmt.Errorf("The archive worker requires --archive-dir")
+		}
+		if enabled[id] {
+			return s, fmt.Errorf("Worker %s is enabled twice", id)
+		}
+		enabled[id] = true
+		s.workers = append(s.workers, id)
+	}
+	if *c.RateLimit < 0 || *c.RateLimitBurst < 0 {
+		return s, fmt.Errorf("Rate limit and burst should be positive numbers")
+	}
+	for name, rate := range c.MetricRateLimits {
+		if rate < 0 {
+			return s, fmt.Errorf("%s rate should be a positive number", name)
+		}
+	}
+	if *c.RateLimit > 0 || len(c.MetricRateLimits) > 0 {
+		config := rateLimitConfig(*c.RateLimit, *c.RateLimitBurst, c.MetricRateLimits)
+		s.processor.RateLimit = &config
+	}
+	for id, rate := range c.WorkerRateLimits {
+		if !enabled[id] {
+			return s, fmt.Errorf("Rate limit of worker %s which is not enabled", id)
+		}
+		if rate < 0 {
+			return s, fmt.Errorf("%s rate should be a positive number", id)
+		}
+		if s.processor.WorkerRateLimits == nil {
+			s.processor.WorkerRateLimits = make(map[string]processor.RateLimitConfig)
+		}
+		s.processor.WorkerRateLimits[id] = rateLimitConfig(rate, *c.RateLimitBurst, c.MetricRateLimits)
+	}
+	for metric, ids := range c.Routes {
+		for _, id := range ids {
+			if !enabled[id] {
+				return s, fmt.Errorf("Route of %s to worker %s which is not enabled", metric, id)
+			}
+		}
+	}
+	s.processor.Routes = c.Routes
+	if c.Transform != nil {
+		pipeline, err := c.Transform.Pipeline()
+		if err != nil {
+			return s, fmt.Errorf("Invalid transform %s", err)
+		}
+		s.processor.Transformer = pipeline
+	}
+	return s, nil
+}
+
+//reloader applies the settings to the processor
+type reloader struct {
+	path   string
+	proc   processor.Processor
+	level  *logging.LevelVar
+	logger logging.Logger
+	//registrationOptions returns the options a worker is registered with
+	registrationOptions func(id string) []processor.RegistrationOption
+
+	mu sync.Mutex
+	//enabled workers
+	workers map[string]bool
+}
+
+//apply registers the enabled workers paused and updates the processor settings, resuming them with the routes and rate
+//limits they are configured with at once. Then it unregisters the disabled workers and sets the log level.
+//If a worker fails to register or the processor rejects the settings, the workers just registered are unregistered
+//and the current settings kept
+func (r *reloader) apply(s settings) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var added []string
+	rollback := func() {
+		for _, id := range added {
+			r.proc.Unregister(id)
+		}
+	}
+	for _, id := range s.workers {
+		if r.workers[id] {
+			continue
+		}
+		w, err := workerFactories[id]()
+		if err == nil {
+			options := append(r.registrationOptions(id), processor.SetPaused())
+			err = r.proc.Register(id, w, options...)
+		}
+		if err != nil {
+			rollback()
+			return fmt.Errorf("Failed to register worker %s %s", id, err)
+		}
+		added = append(added, id)
+	}
+	update := s.processor
+	update.Resume = added
+	err := r.proc.Update(update)
+	if err != nil {
+		rollback()
+		return fmt.Errorf("Failed to update the processor %s", err)
+	}
+	enabled := make(map[string]bool, len(s.workers))
+	for _, id := range s.workers {
+		enabled[id] = true
+	}
+	for id := range r.workers {
+		if enabled[id] {
+			continue
+		}
+		err := r.proc.Unregister(id)
+		if err != nil {
+			r.logger.Error("Failed to unregister worker", logging.Fields{"worker_id": id, "error": err})
+		}
+	}
+	r.workers = enabled
+	r.level.Set(s.logLevel)
+	return nil
+}
+
+//reload loads the config file and applies it. Invalid configs are logged and the current settings kept
+func (r *reloader) reload() {
+	s, err := loadSettings(r.path)
+	if err == nil {
+		err = r.apply(s)
+	}
+	if err != nil {
+		r.logger.Error("Failed to reload config", logging.Fields{"path": r.path, "error": err})
+		return
+	}
+	r.logger.Info("Reloaded config", logging.Fields{"path": r.path, "workers": strings.Join(s.workers, ","), "log_level": s.logLevel.String()})
+}
+
+//watch reloads the config on every SIGHUP until the returned function is called
+func (r *reloader) watch() func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			r.reload()
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(signals)
+	}
+}
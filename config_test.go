@@ -0,0 +1,267 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ottogiron/metricsworker/logging"
+	"github.com/ottogiron/metricsworker/processor"
+	"github.com/ottogiron/metricsworker/worker"
+)
+
+//fakeWorkerFactories replaces the worker factories with workers without backends until the returned function is called
+func fakeWorkerFactories() func() {
+	factories := workerFactories
+	workerFactories = make(map[string]func() (worker.Worker, error), len(factories))
+	for id := range factories {
+		workerFactories[id] = func() (worker.Worker, error) {
+			return worker.Func(func(task interface{}) error { return nil }), nil
+		}
+	}
+	return func() {
+		workerFactories = factories
+	}
+}
+
+//registeredWorkers returns the ids of the workers registered in the processor, paused ones are suffixed with (paused)
+func registeredWorkers(proc processor.Processor) []string {
+	var ids []string
+	for _, status := range proc.Workers() {
+		id := status.ID
+		if status.Paused {
+			id += " (paused)"
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func newTestReloader(path string) *reloader {
+	return &reloader{
+		path:   path,
+		proc:   processor.New(nil),
+		level:  logging.NewLevelVar(logging.LevelInfo),
+		logger: logging.Nop(),
+		registrationOptions: func(id string) []processor.RegistrationOption {
+			return nil
+		},
+	}
+}
+
+func Test_config_merge(t *testing.T) {
+	rate, burst, fileRate := 1.0, 1, 2.0
+	flags := config{
+		LogLevel:         "info",
+		RateLimit:        &rate,
+		RateLimitBurst:   &burst,
+		MetricRateLimits: map[string]float64{"kite_call": 10},
+		Workers:          []string{"distincName", "hourlyLog"},
+	}
+	tests := []struct {
+		name string
+		file config
+		want config
+	}{
+		{"Empty file keeps the flags", config{}, flags},
+		{
+			"File overrides the flags",
+			config{LogLevel: "debug", RateLimit: &fileRate, Workers: []string{"hourlyLog"}, Routes: map[string][]string{"kite_call": {"hourlyLog"}}},
+			config{
+				LogLevel:         "debug",
+				RateLimit:        &fileRate,
+				RateLimitBurst:   &burst,
+				MetricRateLimits: map[string]float64{"kite_call": 10},
+				Routes:           map[string][]string{"kite_call": {"hourlyLog"}},
+				Workers:          []string{"hourlyLog"},
+			},
+		},
+		{
+			"Empty lists disable the flag values",
+			config{MetricRateLimits: map[string]float64{}, Workers: []string{}},
+			config{LogLevel: "info", RateLimit: &rate, RateLimitBurst: &burst, MetricRateLimits: map[string]float64{}, Workers: []string{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := flags.merge(tt.file); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("config.merge() = %+v want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_config_settings(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      func(c *config)
+		wantWorkers []string
+		wantErr     bool
+	}{
+		{"Valid config", func(c *config) {}, []string{"distincName", "hourlyLog"}, false},
+		{"Routes and worker rate limits", func(c *config) {
+			c.Routes = map[string][]string{"kite_call": {"hourlyLog"}}
+			c.WorkerRateLimits = map[string]float64{"hourlyLog": 10}
+		}, []string{"distincName", "hourlyLog"}, false},
+		{"Invalid log level", func(c *config) { c.LogLevel = "verbose" }, nil, true},
+		{"Unknown worker", func(c *config) { c.Workers = []string{"unknown"} }, nil, true},
+		{"Worker enabled twice", func(c *config) { c.Workers = []string{"hourlyLog", "hourlyLog"} }, nil, true},
+		{"Archive without directory", func(c *config) { c.Workers = []string{"archive"} }, nil, true},
+		{"Negative rate limit", func(c *config) { rate := -1.0; c.RateLimit = &rate }, nil, true},
+		{"Negative metric rate limit", func(c *config) { c.MetricRateLimits = map[string]float64{"kite_call": -1} }, nil, true},
+		{"Rate limit of a disabled worker", func(c *config) { c.WorkerRateLimits = map[string]float64{"accountName": 10} }, nil, true},
+		{"Route to a disabled worker", func(c *config) { c.Routes = map[string][]string{"kite_call": {"accountName"}} }, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rate, burst := 0.0, 1
+			c := config{LogLevel: "info", RateLimit: &rate, RateLimitBurst: &burst, Workers: []string{"distincName", "hourlyLog"}}
+			tt.config(&c)
+			got, err := c.settings()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("config.settings() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got.workers, tt.wantWorkers) {
+				t.Errorf("config.settings() workers = %v want %v", got.workers, tt.wantWorkers)
+			}
+		})
+	}
+}
+
+func Test_loadSettings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		name        string
+		file        string
+		wantLevel   logging.Level
+		wantWorkers []string
+		wantErr     bool
+	}{
+		{"No config file", "", logging.LevelInfo, workerIDs, false},
+		{"Config file overrides the flags", `{"log_level": "debug", "workers": ["hourlyLog"], "routes": {"kite_call": ["hourlyLog"]}}`, logging.LevelDebug, []string{"hourlyLog"}, false},
+		{"Unknown field", `{"log_level": "debug", "unknown": true}`, 0, nil, true},
+		{"Invalid JSON", `{"log_level": `, 0, nil, true},
+		{"Invalid settings", `{"workers": ["unknown"]}`, 0, nil, true},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := ""
+			if tt.file != "" {
+				path = filepath.Join(dir, strconv.Itoa(i)+".json")
+				if err := ioutil.WriteFile(path, []byte(tt.file), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := loadSettings(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadSettings() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (got.logLevel != tt.wantLevel || !reflect.DeepEqual(got.workers, tt.wantWorkers)) {
+				t.Errorf("loadSettings() = %v %v want %v %v", got.logLevel, got.workers, tt.wantLevel, tt.wantWorkers)
+			}
+		})
+	}
+	if _, err := loadSettings(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("loadSettings() error = nil want an error for a missing file")
+	}
+}
+
+func Test_reloader_apply(t *testing.T) {
+	defer fakeWorkerFactories()()
+	r := newTestReloader("")
+	//Every step is applied to the settings left by the previous one
+	steps := []struct {
+		name        string
+		settings    settings
+		wantWorkers []string
+		wantLevel   logging.Level
+		wantErr     bool
+	}{
+		{
+			"Register the enabled workers",
+			settings{logLevel: logging.LevelInfo, workers: []string{"distincName", "hourlyLog"}},
+			[]string{"distincName", "hourlyLog"},
+			logging.LevelInfo,
+			false,
+		},
+		{
+			"Rollback rejected settings",
+			settings{
+				logLevel:  logging.LevelDebug,
+				workers:   []string{"hourlyLog", "accountName"},
+				processor: processor.Settings{Routes: map[string][]string{"kite_call": {"unknown"}}},
+			},
+			[]string{"distincName", "hourlyLog"},
+			logging.LevelInfo,
+			true,
+		},
+		{
+			"Replace the enabled workers",
+			settings{
+				logLevel:  logging.LevelDebug,
+				workers:   []string{"hourlyLog", "accountName"},
+				processor: processor.Settings{Routes: map[string][]string{"kite_call": {"accountName"}}},
+			},
+			[]string{"accountName", "hourlyLog"},
+			logging.LevelDebug,
+			false,
+		},
+	}
+	for _, tt := range steps {
+		t.Run(tt.name, func(t *testing.T) {
+			err := r.apply(tt.settings)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("reloader.apply() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := registeredWorkers(r.proc); !reflect.DeepEqual(got, tt.wantWorkers) {
+				t.Errorf("reloader.apply() workers = %v want %v", got, tt.wantWorkers)
+			}
+			if got := r.level.Level(); got != tt.wantLevel {
+				t.Errorf("reloader.apply() level = %v want %v", got, tt.wantLevel)
+			}
+		})
+	}
+}
+
+func Test_reloader_watch(t *testing.T) {
+	defer fakeWorkerFactories()()
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	err = ioutil.WriteFile(path, []byte(`{"workers": ["hourlyLog"]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newTestReloader(path)
+	err = r.apply(settings{logLevel: logging.LevelInfo, workers: workerIDs})
+	if err != nil {
+		t.Fatalf("reloader.apply() error = %v", err)
+	}
+	stop := r.watch()
+	defer stop()
+
+	err = syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"hourlyLog"}
+	deadline := time.Now().Add(5 * time.Second)
+	for !reflect.DeepEqual(registeredWorkers(r.proc), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("reloader.watch() workers = %v want %v", registeredWorkers(r.proc), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,284 @@
+//Package logging provides a structured and leveled logger with logfmt and JSON output
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//Level logging level
+type Level int
+
+//Available levels
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	default:
+		return "error"
+	}
+}
+
+//ParseLevel parses a level name debug|info|warn|error
+func ParseLevel(name string) (Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return LevelInfo, fmt.Errorf("Unknown log level %s", name)
+}
+
+//LevelVar level which can be changed while loggers use it
+type LevelVar struct {
+	level int32
+}
+
+//NewLevelVar returns a new instance of a level variable set to level
+func NewLevelVar(level Level) *LevelVar {
+	return &LevelVar{level: int32(level)}
+}
+
+//Level returns the current level
+func (v *LevelVar) Level() Level {
+	return Level(atomic.LoadInt32(&v.level))
+}
+
+//Set sets the level
+func (v *LevelVar) Set(level Level) {
+	atomic.StoreInt32(&v.level, int32(level))
+}
+
+//Format output format
+type Format string
+
+//Available formats
+const (
+	FormatLogfmt Format = "logfmt"
+	FormatJSON   Format = "json"
+)
+
+//ParseFormat parses a format name logfmt|json
+func ParseFormat(name string) (Format, error) {
+	switch Format(strings.ToLower(name)) {
+	case FormatLogfmt:
+		return FormatLogfmt, nil
+	case FormatJSON:
+		return FormatJSON, nil
+	}
+	return FormatLogfmt, fmt.Errorf("Unknown log format %s", name)
+}
+
+//Fields contextual fields of a log entry
+type Fields map[string]interface{}
+
+//Logger structured and leveled logger
+type Logger interface {
+	Debug(msg string, fields Fields)
+	Info(msg string, fields Fields)
+	Warn(msg string, fields Fields)
+	Error(msg string, fields Fields)
+	//With returns a logger adding fields to every entry
+	With(fields Fields) Logger
+}
+
+//output writes encoded entries
+type output struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format Format
+	now    func() time.Time
+}
+
+type logger struct {
+	out    *output
+	level  *LevelVar
+	fields Fields
+}
+
+//New returns a new instance of a logger writing entries from level in the given format
+func New(w io.Writer, level Level, format Format) Logger {
+	return NewWithLevel(w, NewLevelVar(level), format)
+}
+
+//NewWithLevel returns a new instance of a logger writing entries from the level of v in the given format.
+//Setting v changes the level of the logger and of the loggers returned by its With
+func NewWithLevel(w io.Writer, v *LevelVar, format Format) Logger {
+	return &logger{
+		out:   &output{w: w, format: format, now: time.Now},
+		level: v,
+	}
+}
+
+//Nop returns a logger discarding every entry
+func Nop() Logger {
+	return nopLogger{}
+}
+
+func (l *logger) Debug(msg string, fields Fields) { l.log(LevelDebug, msg, fields) }
+func (l *logger) Info(msg string, fields Fields)  { l.log(LevelInfo, msg, fields) }
+func (l *logger) Warn(msg string, fields Fields)  { l.log(LevelWarn, msg, fields) }
+func (l *logger) Error(msg string, fields Fields) { l.log(LevelError, msg, fields) }
+
+func (l *logger) With(fields Fields) Logger {
+	return &logger{out: l.out, level: l.level, fields: merge(l.fields, fields)}
+}
+
+func (l *logger) log(level Level, msg string, fields Fields) {
+	if level < l.level.Level() {
+		return
+	}
+	entry := merge(l.fields, fields)
+	entry["time"] = l.out.now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = level.String()
+	entry["msg"] = msg
+	var line []byte
+	if l.out.format == FormatJSON {
+		line = encodeJSON(entry)
+	} else {
+		line = encodeLogfmt(entry)
+	}
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	l.out.w.Write(line)
+}
+
+func merge(a, b Fields) Fields {
+	fields := make(Fields, len(a)+len(b)+3)
+	for k, v := range a {
+		fields[k] = v
+	}
+	for k, v := range b {
+		fields[k] = v
+	}
+	return fields
+}
+
+//keys returns the entry keys, time, level and msg first if present and the rest sorted
+func keys(entry Fields) []string {
+	keys := make([]string, 0, len(entry))
+	for k := range entry {
+		if k != "time" && k != "level" && k != "msg" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	head := make([]string, 0, 3)
+	for _, k := range []string{"time", "level", "msg"} {
+		if _, ok := entry[k]; ok {
+			head = append(head, k)
+		}
+	}
+	return append(head, keys...)
+}
+
+func value(v interface{}) interface{} {
+	switch t := v.(type) {
+	case error:
+		return t.Error()
+	case time.Duration:
+		return t.String()
+	case fmt.Stringer:
+		return t.String()
+	}
+	return v
+}
+
+func encodeJSON(entry Fields) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range keys(entry) {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(k)
+		buf.Write(key)
+		buf.WriteByte(':')
+		v, err := json.Marshal(value(entry[k]))
+		if err != nil {
+			v, _ = json.Marshal(fmt.Sprint(entry[k]))
+		}
+		buf.Write(v)
+	}
+	buf.WriteString("}\n")
+	return buf.Bytes()
+}
+
+func encodeLogfmt(entry Fields) []byte {
+	var buf bytes.Buffer
+	for i, k := range keys(entry) {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(k)
+		buf.WriteByte('=')
+		s := fmt.Sprint(value(entry[k]))
+		if s == "" || strings.ContainsAny(s, " =\"\t\n") {
+			s = strconv.Quote(s)
+		}
+		buf.WriteString(s)
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, Fields) {}
+func (nopLogger) Info(string, Fields)  {}
+func (nopLogger) Warn(string, Fields)  {}
+func (nopLogger) Error(string, Fields) {}
+func (n nopLogger) With(Fields) Logger { return n }
+
+//stdLogger writes logfmt entries through a standard library logger
+type stdLogger struct {
+	logger *log.Logger
+	fields Fields
+}
+
+//FromStdLogger returns a logger writing logfmt entries of every level through a standard library logger
+func FromStdLogger(l *log.Logger) Logger {
+	return &stdLogger{logger: l}
+}
+
+func (l *stdLogger) Debug(msg string, fields Fields) { l.log(LevelDebug, msg, fields) }
+func (l *stdLogger) Info(msg string, fields Fields)  { l.log(LevelInfo, msg, fields) }
+func (l *stdLogger) Warn(msg string, fields Fields)  { l.log(LevelWarn, msg, fields) }
+func (l *stdLogger) Error(msg string, fields Fields) { l.log(LevelError, msg, fields) }
+
+func (l *stdLogger) With(fields Fields) Logger {
+	return &stdLogger{logger: l.logger, fields: merge(l.fields, fields)}
+}
+
+func (l *stdLogger) log(level Level, msg string, fields Fields) {
+	entry := merge(l.fields, fields)
+	entry["level"] = level.String()
+	entry["msg"] = msg
+	//the standard logger adds its own time
+	l.logger.Print(string(encodeLogfmt(entry)))
+}
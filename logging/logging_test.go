@@ -0,0 +1,159 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testLogger(buf *bytes.Buffer, level Level, format Format) Logger {
+	l := New(buf, level, format).(*logger)
+	l.out.now = func() time.Time {
+		return time.Date(2017, 4, 12, 2, 12, 13, 0, time.UTC)
+	}
+	return l
+}
+
+func TestLogger(t *testing.T) {
+	tests := []struct {
+		name   string
+		level  Level
+		format Format
+		log    func(l Logger)
+		want   string
+	}{
+		{
+			"Logfmt",
+			LevelInfo,
+			FormatLogfmt,
+			func(l Logger) {
+				l.Info("Task executed", Fields{"worker_id": "distincName", "duration": 2 * time.Second})
+			},
+			"time=2017-04-12T02:12:13Z level=info msg=\"Task executed\" duration=2s worker_id=distincName\n",
+		},
+		{
+			"JSON",
+			LevelInfo,
+			FormatJSON,
+			func(l Logger) {
+				l.Error("Failed to execute task", Fields{"error": errors.New("failure"), "attempt": 2})
+			},
+			`{"time":"2017-04-12T02:12:13Z","level":"error","msg":"Failed to execute task","attempt":2,"error":"failure"}` + "\n",
+		},
+		{
+			"Entries under the level are discarded",
+			LevelWarn,
+			FormatLogfmt,
+			func(l Logger) {
+				l.Debug("debug", nil)
+				l.Info("info", nil)
+				l.Warn("warn", nil)
+			},
+			"time=2017-04-12T02:12:13Z level=warn msg=warn\n",
+		},
+		{
+			"Contextual fields",
+			LevelDebug,
+			FormatLogfmt,
+			func(l Logger) {
+				l.With(Fields{"worker_id": "hourlyLog", "metric": "kite_call"}).Debug("debug", Fields{"metric": "api_call"})
+			},
+			"time=2017-04-12T02:12:13Z level=debug msg=debug metric=api_call worker_id=hourlyLog\n",
+		},
+		{
+			"Quoted values",
+			LevelInfo,
+			FormatLogfmt,
+			func(l Logger) {
+				l.Info("info", Fields{"username": "", "error": errors.New(`invalid "count"`)})
+			},
+			"time=2017-04-12T02:12:13Z level=info msg=info error=\"invalid \\\"count\\\"\" username=\"\"\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tt.log(testLogger(&buf, tt.level, tt.format))
+			if got := buf.String(); got != tt.want {
+				t.Errorf("Logger output = %q want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewWithLevel(t *testing.T) {
+	var buf bytes.Buffer
+	v := NewLevelVar(LevelWarn)
+	l := NewWithLevel(&buf, v, FormatLogfmt)
+	derived := l.With(Fields{"worker_id": "distincName"})
+	l.Info("dropped", nil)
+	v.Set(LevelDebug)
+	derived.Debug("written", nil)
+	if got := buf.String(); strings.Contains(got, "dropped") || !strings.Contains(got, "msg=written") {
+		t.Errorf("Logger output = %q want only the entry logged after the level changed", got)
+	}
+	if v.Level() != LevelDebug {
+		t.Errorf("LevelVar.Level() = %v want %v", v.Level(), LevelDebug)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Level
+		wantErr bool
+	}{
+		{"debug", LevelDebug, false},
+		{"INFO", LevelInfo, false},
+		{"warning", LevelWarn, false},
+		{"error", LevelError, false},
+		{"verbose", LevelInfo, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLevel(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseLevel() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseLevel() = %v want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Format
+		wantErr bool
+	}{
+		{"logfmt", FormatLogfmt, false},
+		{"JSON", FormatJSON, false},
+		{"text", FormatLogfmt, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFormat(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseFormat() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseFormat() = %v want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := FromStdLogger(log.New(&buf, "", 0)).With(Fields{"worker_id": "accountName"})
+	l.Debug("Task executed", Fields{"message_id": "1"})
+	want := "level=debug msg=\"Task executed\" message_id=1 worker_id=accountName\n"
+	if got := buf.String(); got != want {
+		t.Errorf("FromStdLogger() output = %q want %q", got, want)
+	}
+}
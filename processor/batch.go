@@ -0,0 +1,120 @@
+package processor
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ottogiron/metricsworker/logging"
+	"github.com/ottogiron/metricsworker/worker"
+	"github.com/streadway/amqp"
+)
+
+//SetManualAck acknowledges the rabbit deliveries once every metric they contain was processed by every worker.
+//Deliveries are rejected without requeueing when any execution failed, failed tasks are dead-lettered first.
+//The transport should not acknowledge the deliveries itself
+func SetManualAck(enabled bool) Option {
+	return func(p *processor) {
+		p.manualAck = enabled
+	}
+}
+
+//expand returns a task for every metric of a rabbit delivery. Plain JSON bodies are split, see worker.SplitMetrics,
+//single metrics and JSON bodies which can't be split are returned as they are so the workers report them as before.
+//Bodies of other content types and compressed bodies are decoded with the codec of their content type and every metric
+//is passed to the workers as JSON, see worker.Decode, so the workers parse it as any JSON task. Every task is a copy of
+//the delivery with the metric body
+func expand(task interface{}) ([]interface{}, error) {
+	delivery, ok := task.(amqp.Delivery)
+	if !ok {
+		return []interface{}{task}, nil
+	}
+	if !worker.IsPlainJSON(delivery.ContentType, delivery.ContentEncoding, delivery.Body) {
+		return transcode(delivery)
+	}
+	bodies, err := worker.SplitMetrics(delivery.Body)
+	if err != nil || (len(bodies) == 1 && bytes.Equal(bodies[0], bytes.TrimSpace(delivery.Body))) {
+		return []interface{}{task}, nil
+	}
+	return copies(delivery, bodies), nil
+}
+
+//transcode decodes the metrics of a delivery and returns a JSON task for every one of them. The workers decode JSON
+//bodies, so the metrics are marshalled again
+func transcode(delivery amqp.Delivery) ([]interface{}, error) {
+	metrics, err := worker.DecodeDelivery(delivery)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode %s message %s", delivery.ContentType, err)
+	}
+	bodies := make([][]byte, 0, len(metrics))
+	for _, metric := range metrics {
+		body, err := worker.MarshallMetric(metric)
+		if err != nil {
+			return nil, err
+		}
+		bodies = append(bodies, body)
+	}
+	delivery.ContentType = worker.ContentTypeJSON
+	delivery.ContentEncoding = ""
+	return copies(delivery, bodies), nil
+}
+
+//copies returns a copy of the delivery for every body
+func copies(delivery amqp.Delivery, bodies [][]byte) []interface{} {
+	tasks := make([]interface{}, 0, len(bodies))
+	for _, body := range bodies {
+		item := delivery
+		//the copies must not ack the delivery, it is acknowledged once for all of them
+		item.Acknowledger = nil
+		item.Body = body
+		tasks = append(tasks, item)
+	}
+	return tasks
+}
+
+//itemFields returns the fields identifying a task of a message, the tasks of a batch are identified by their index too
+func itemFields(task interface{}, index, total int) logging.Fields {
+	fields := logging.Fields(worker.TaskFields(task))
+	if total > 1 {
+		fields["item"] = index
+	}
+	return fields
+}
+
+//batchError error of a message some of whose tasks failed in a worker or could not be transformed
+type batchError struct {
+	//failed number of failed tasks out of total
+	failed int
+	total  int
+	//err first error
+	err error
+}
+
+func (e *batchError) Error() string {
+	if e.total == 1 {
+		return e.err.Error()
+	}
+	return fmt.Sprintf("%d of %d tasks failed, first error %s", e.failed, e.total, e.err)
+}
+
+//acknowledge reports the outcome of a message to the message processed hooks, and acknowledges its rabbit delivery
+//if manual acknowledgements are enabled. err is nil if every task of the message succeeded. Failed deliveries are
+//rejected without requeueing, except the duplicates of a message in flight (ErrDuplicateInFlight) which are requeued
+func (p *processor) acknowledge(task interface{}, fields logging.Fields, err error) {
+	p.emit(Event{Type: EventMessageProcessed, Task: task, Fields: fields, Err: err})
+	if !p.manualAck {
+		return
+	}
+	delivery, ok := task.(amqp.Delivery)
+	if !ok || delivery.Acknowledger == nil {
+		return
+	}
+	var ackErr error
+	if err == nil {
+		ackErr = delivery.Ack(false)
+	} else {
+		ackErr = delivery.Nack(false, err == ErrDuplicateInFlight)
+	}
+	if ackErr != nil {
+		p.logger.With(fields).Error("Failed to acknowledge message", logging.Fields{"error": ackErr})
+	}
+}
@@ -0,0 +1,266 @@
+package processor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	fworkerprocessor "github.com/ferrariframework/ferrariworker/processor"
+	"github.com/ottogiron/metricsworker/worker"
+	"github.com/streadway/amqp"
+)
+
+//mockAcknowledger records the acknowledgements of deliveries by tag
+type mockAcknowledger struct {
+	mu       sync.Mutex
+	acks     []uint64
+	nacks    []uint64
+	requeued []uint64
+}
+
+func (a *mockAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.acks = append(a.acks, tag)
+	return nil
+}
+
+func (a *mockAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.nacks = append(a.nacks, tag)
+	if requeue {
+		a.requeued = append(a.requeued, tag)
+	}
+	return nil
+}
+
+func (a *mockAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+func Test_expand(t *testing.T) {
+	tests := []struct {
+		name string
+		task interface{}
+		want []string
+	}{
+		{"Not a delivery", "kite_call", nil},
+		{"Single metric", amqp.Delivery{Body: []byte(` {"username": "kodingbot"} `)}, []string{` {"username": "kodingbot"} `}},
+		{"Invalid body", amqp.Delivery{Body: []byte(`[{"username"`)}, []string{`[{"username"`}},
+		{"Array of one metric", amqp.Delivery{Body: []byte(`[{"username": "kodingbot"}]`)}, []string{`{"username": "kodingbot"}`}},
+		{"Array", amqp.Delivery{Body: []byte(`[{"username": "kodingbot"}, {"username": "koding"}]`)}, []string{`{"username": "kodingbot"}`, `{"username": "koding"}`}},
+		{"NDJSON", amqp.Delivery{Body: []byte("{\"username\": \"kodingbot\"}\n{\"username\": \"koding\"}\n"), Acknowledger: &mockAcknowledger{}}, []string{`{"username": "kodingbot"}`, `{"username": "koding"}`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expand(tt.task)
+			if err != nil {
+				t.Fatalf("expand() error = %v", err)
+			}
+			if tt.want == nil {
+				if len(got) != 1 || got[0] != tt.task {
+					t.Errorf("expand() = %v want the task", got)
+				}
+				return
+			}
+			var bodies []string
+			for _, task := range got {
+				bodies = append(bodies, string(task.(amqp.Delivery).Body))
+				if len(got) > 1 && task.(amqp.Delivery).Acknowledger != nil {
+					t.Error("expand() batched tasks should not acknowledge the delivery")
+				}
+			}
+			if !reflect.DeepEqual(bodies, tt.want) {
+				t.Errorf("expand() = %q want %q", bodies, tt.want)
+			}
+		})
+	}
+}
+
+func Test_processor_Start_Batch(t *testing.T) {
+	acknowledger := &mockAcknowledger{}
+	var messages []fworkerprocessor.Message
+	for i, body := range []string{
+		`[{"username": "kodingbot", "count": 1, "metric": "kite_call"}, {"username": "koding", "count": 2, "metric": "kite_fail"}]`,
+		"{\"username\": \"kodingbot\", \"count\": 3, \"metric\": \"kite_call\"}\n{\"username\": \"koding\", \"count\": 4, \"metric\": \"failing\"}\n",
+		`{"username": "kodingbot", "count": 5, "metric": "kite_call"}`,
+	} {
+		delivery := amqp.Delivery{Body: []byte(body), Acknowledger: acknowledger, DeliveryTag: uint64(i + 1)}
+		messages = append(messages, fworkerprocessor.Message{Payload: delivery.Body, OriginalMessage: delivery})
+	}
+	var mu sync.Mutex
+	var executions []string
+	var processed []error
+	p := New(
+		&processorAdapterMock{handler: mockMessagesHandler(messages)},
+		SetLogger(log.New(ioutil.Discard, "", 0)),
+		SetRoutes(map[string][]string{"kite_fail": {"calls"}}),
+		SetManualAck(true),
+		OnMessageProcessed(func(e Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			processed = append(processed, e.Err)
+		}),
+	)
+	for _, id := range []string{"calls", "all"} {
+		id := id
+		p.Register(id, worker.Func(func(task interface{}) error {
+			body := string(task.(amqp.Delivery).Body)
+			if strings.Contains(body, "failing") {
+				return errors.New("failing metric")
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			executions = append(executions, id+" "+body)
+			return nil
+		}))
+	}
+	err := p.Start()
+	if err != nil {
+		t.Fatalf("processor.Start() error = %v", err)
+	}
+
+	sort.Strings(executions)
+	want := []string{
+		`all {"username": "kodingbot", "count": 1, "metric": "kite_call"}`,
+		`all {"username": "kodingbot", "count": 3, "metric": "kite_call"}`,
+		`all {"username": "kodingbot", "count": 5, "metric": "kite_call"}`,
+		`calls {"username": "koding", "count": 2, "metric": "kite_fail"}`,
+		`calls {"username": "kodingbot", "count": 1, "metric": "kite_call"}`,
+		`calls {"username": "kodingbot", "count": 3, "metric": "kite_call"}`,
+		`calls {"username": "kodingbot", "count": 5, "metric": "kite_call"}`,
+	}
+	if !reflect.DeepEqual(executions, want) {
+		t.Errorf("worker executions = %v want %v", executions, want)
+	}
+	sort.Slice(acknowledger.acks, func(i, j int) bool { return acknowledger.acks[i] < acknowledger.acks[j] })
+	if !reflect.DeepEqual(acknowledger.acks, []uint64{1, 3}) || !reflect.DeepEqual(acknowledger.nacks, []uint64{2}) {
+		t.Errorf("acknowledged = %v rejected = %v want [1 3] and [2]", acknowledger.acks, acknowledger.nacks)
+	}
+	var failed []string
+	for _, err := range processed {
+		if err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(processed) != 3 || !reflect.DeepEqual(failed, []string{"1 of 2 tasks failed, first error failing metric"}) {
+		t.Errorf("message processed errors = %v want one failed message", processed)
+	}
+}
+
+func Test_processor_Start_ReceiveOriginal(t *testing.T) {
+	acknowledger := &mockAcknowledger{}
+	batch := `[{"username": "kodingbot", "count": 1, "metric": "kite_call"}, {"username": "koding", "count": 2, "metric": "kite_call"}]`
+	var messages []fworkerprocessor.Message
+	for i, delivery := range []amqp.Delivery{
+		{Body: []byte(batch)},
+		{Body: []byte("kite_call"), ContentType: worker.ContentTypeStatsD},
+		{Body: []byte(`{"username": "kodingbot", "count": 3, "metric": "archive_fail"}`)},
+	} {
+		delivery.Acknowledger = acknowledger
+		delivery.DeliveryTag = uint64(i + 1)
+		messages = append(messages, fworkerprocessor.Message{Payload: delivery.Body, OriginalMessage: delivery})
+	}
+	var mu sync.Mutex
+	executions := make(map[string][]string)
+	record := func(id string, failing string) worker.Worker {
+		return worker.Func(func(task interface{}) error {
+			body := string(task.(amqp.Delivery).Body)
+			mu.Lock()
+			defer mu.Unlock()
+			executions[id] = append(executions[id], body)
+			if failing != "" && strings.Contains(body, failing) {
+				return errors.New("failing metric")
+			}
+			return nil
+		})
+	}
+	p := New(
+		&processorAdapterMock{handler: mockMessagesHandler(messages)},
+		SetLogger(log.New(ioutil.Discard, "", 0)),
+		SetRoutes(map[string][]string{"kite_call": {"all"}}),
+		SetManualAck(true),
+		SetMaxInFlight(1),
+	)
+	p.Register("all", record("all", ""))
+	p.Register("original", record("original", "archive_fail"), ReceiveOriginal())
+	err := p.Start()
+	if err != nil {
+		t.Fatalf("processor.Start() error = %v", err)
+	}
+
+	want := map[string][]string{
+		"all": {
+			`{"username": "kodingbot", "count": 1, "metric": "kite_call"}`,
+			`{"username": "koding", "count": 2, "metric": "kite_call"}`,
+			`{"username": "kodingbot", "count": 3, "metric": "archive_fail"}`,
+		},
+		"original": {batch, "kite_call", `{"username": "kodingbot", "count": 3, "metric": "archive_fail"}`},
+	}
+	sort.Strings(executions["all"])
+	sort.Strings(want["all"])
+	if !reflect.DeepEqual(executions, want) {
+		t.Errorf("worker executions = %v want %v", executions, want)
+	}
+	if !reflect.DeepEqual(acknowledger.acks, []uint64{1}) || !reflect.DeepEqual(acknowledger.nacks, []uint64{2, 3}) {
+		t.Errorf("acknowledged = %v rejected = %v want [1] and [2 3]", acknowledger.acks, acknowledger.nacks)
+	}
+}
+
+func Test_processor_Start_Encodings(t *testing.T) {
+	acknowledger := &mockAcknowledger{}
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write([]byte(`{"username": "koding", "count": 2, "metric": "kite_call"}`))
+	gz.Close()
+	var messages []fworkerprocessor.Message
+	for i, delivery := range []amqp.Delivery{
+		{ContentType: worker.ContentTypeStatsD, Body: []byte("kite_call:1|c|#username:kodingbot\nconnections:3|g|#username:kodingbot\n")},
+		{ContentType: worker.ContentTypeJSON, ContentEncoding: "gzip", Body: gzipped.Bytes()},
+		{ContentType: "text/csv", Body: []byte("kodingbot,kite_call,1")},
+	} {
+		delivery.Acknowledger = acknowledger
+		delivery.DeliveryTag = uint64(i + 1)
+		messages = append(messages, fworkerprocessor.Message{Payload: delivery.Body, OriginalMessage: delivery})
+	}
+	var mu sync.Mutex
+	var executions []string
+	p := New(
+		&processorAdapterMock{handler: mockMessagesHandler(messages)},
+		SetLogger(log.New(ioutil.Discard, "", 0)),
+		SetManualAck(true),
+	)
+	p.Register("all", worker.Func(func(task interface{}) error {
+		delivery := task.(amqp.Delivery)
+		mu.Lock()
+		defer mu.Unlock()
+		executions = append(executions, delivery.ContentType+" "+string(delivery.Body))
+		return nil
+	}))
+	err := p.Start()
+	if err != nil {
+		t.Fatalf("processor.Start() error = %v", err)
+	}
+
+	sort.Strings(executions)
+	want := []string{
+		`application/json {"type":"gauge","username":"kodingbot","metric":"connections","value":3}`,
+		`application/json {"username":"koding","count":2,"metric":"kite_call"}`,
+		`application/json {"username":"kodingbot","count":1,"metric":"kite_call"}`,
+	}
+	if !reflect.DeepEqual(executions, want) {
+		t.Errorf("worker executions = %v want %v", executions, want)
+	}
+	sort.Slice(acknowledger.acks, func(i, j int) bool { return acknowledger.acks[i] < acknowledger.acks[j] })
+	if !reflect.DeepEqual(acknowledger.acks, []uint64{1, 2}) || !reflect.DeepEqual(acknowledger.nacks, []uint64{3}) {
+		t.Errorf("acknowledged = %v rejected = %v want [1 2] and [3]", acknowledger.acks, acknowledger.nacks)
+	}
+}
@@ -0,0 +1,200 @@
+package processor
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ottogiron/metricsworker/worker"
+	"github.com/ottogiron/metricsworker/worker/middleware"
+)
+
+//ErrCircuitOpen returned instead of executing a worker while its circuit breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+//BreakerConfig circuit breaker configuration
+type BreakerConfig struct {
+	//Number of last executions the failure rate is computed on
+	WindowSize int
+	//Minimum number of executions in the window before the breaker can open
+	MinRequests int
+	//Failure rate (0-1] which opens the breaker
+	FailureRate float64
+	//Time the breaker stays open until probing executions are allowed
+	OpenTimeout time.Duration
+	//Number of successful probing executions which close the breaker
+	HalfOpenProbes int
+}
+
+//DefaultBreakerConfig returns the default circuit breaker configuration
+func DefaultBreakerConfig() BreakerConfig {
+	return BreakerConfig{
+		WindowSize:     20,
+		MinRequests:    10,
+		FailureRate:    0.5,
+		OpenTimeout:    30 * time.Second,
+		HalfOpenProbes: 1,
+	}
+}
+
+//SetCircuitBreaker wraps every registered worker with its own circuit breaker. While a breaker is open
+//the worker is not executed and its tasks fail with ErrCircuitOpen. Set a dead letterer (see SetDeadLetter) so
+//the rejected tasks are processed once the worker recovers instead of failing their messages
+func SetCircuitBreaker(config BreakerConfig) Option {
+	return func(p *processor) {
+		p.breakerConfig = &config
+	}
+}
+
+//BreakerState state of a circuit breaker
+type BreakerState int
+
+//Circuit breaker states
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+//breaker tracks the failure rate of the last executions of a worker
+type breaker struct {
+	config BreakerConfig
+	now    func() time.Time
+
+	mu    sync.Mutex
+	state BreakerState
+	//ring buffer of the last executions, true means failed
+	window   []bool
+	next     int
+	count    int
+	failures int
+	openedAt time.Time
+	//probing executions started and succeeded while half open
+	probes    int
+	successes int
+}
+
+func newBreaker(config BreakerConfig) *breaker {
+	if config.WindowSize < 1 {
+		config.WindowSize = 1
+	}
+	if config.HalfOpenProbes < 1 {
+		config.HalfOpenProbes = 1
+	}
+	return &breaker{
+		config: config,
+		now:    time.Now,
+		window: make([]bool, config.WindowSize),
+	}
+}
+
+//allow returns whether an execution is allowed
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen {
+		if b.now().Sub(b.openedAt) < b.config.OpenTimeout {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		b.probes, b.successes = 0, 0
+	}
+	if b.state == BreakerHalfOpen {
+		if b.probes >= b.config.HalfOpenProbes {
+			return false
+		}
+		b.probes++
+	}
+	return true
+}
+
+//record records the result of an allowed execution. Permanent errors (see middleware.Permanent) are caused by the task
+//instead of the worker backend, so they are not recorded and a burst of invalid tasks doesn't open the breaker
+func (b *breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if middleware.IsPermanent(err) {
+		if b.state == BreakerHalfOpen {
+			//the probe didn't reach the backend, another one is allowed
+			b.probes--
+		}
+		return
+	}
+	failed := err != nil
+	switch b.state {
+	case BreakerHalfOpen:
+		if failed {
+			b.open()
+			return
+		}
+		b.successes++
+		if b.successes >= b.config.HalfOpenProbes {
+			b.state = BreakerClosed
+			b.reset()
+		}
+	case BreakerClosed:
+		if b.count == len(b.window) {
+			if b.window[b.next] {
+				b.failures--
+			}
+		} else {
+			b.count++
+		}
+		b.window[b.next] = failed
+		b.next = (b.next + 1) % len(b.window)
+		if failed {
+			b.failures++
+		}
+		if b.count >= b.config.MinRequests && float64(b.failures)/float64(b.count) >= b.config.FailureRate {
+			b.open()
+		}
+	}
+}
+
+func (b *breaker) open() {
+	b.state = BreakerOpen
+	b.openedAt = b.now()
+	b.reset()
+}
+
+func (b *breaker) reset() {
+	for i := range b.window {
+		b.window[i] = false
+	}
+	b.next, b.count, b.failures = 0, 0, 0
+}
+
+//State returns the breaker state
+func (b *breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+var _ worker.Worker = (*breakerWorker)(nil)
+
+//breakerWorker executes a worker through a circuit breaker
+type breakerWorker struct {
+	worker  worker.Worker
+	breaker *breaker
+}
+
+func (w *breakerWorker) Execute(task interface{}) error {
+	if !w.breaker.allow() {
+		return ErrCircuitOpen
+	}
+	err := w.worker.Execute(task)
+	w.breaker.record(err)
+	return err
+}
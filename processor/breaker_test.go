@@ -0,0 +1,116 @@
+package processor
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ottogiron/metricsworker/worker/middleware"
+)
+
+func Test_breaker(t *testing.T) {
+	failure := errors.New("failure")
+	config := BreakerConfig{
+		WindowSize:     4,
+		MinRequests:    4,
+		FailureRate:    0.5,
+		OpenTimeout:    time.Second,
+		HalfOpenProbes: 1,
+	}
+	now := time.Now()
+	b := newBreaker(config)
+	b.now = func() time.Time {
+		return now
+	}
+
+	execute := func(err error) bool {
+		if !b.allow() {
+			return false
+		}
+		b.record(err)
+		return true
+	}
+
+	//Failures below the minimum requests don't open the breaker
+	for _, err := range []error{failure, failure, nil} {
+		execute(err)
+	}
+	if b.State() != BreakerClosed {
+		t.Fatalf("breaker state = %s want closed", b.State())
+	}
+	//2 failures out of 4 reach the failure rate
+	execute(nil)
+	if b.State() != BreakerOpen {
+		t.Fatalf("breaker state = %s want open", b.State())
+	}
+	if execute(nil) {
+		t.Errorf("breaker allowed an execution while open")
+	}
+
+	//Once the open timeout expires a single probe is allowed
+	now = now.Add(time.Second)
+	if !b.allow() {
+		t.Fatalf("breaker did not allow a probe after the open timeout")
+	}
+	if b.allow() {
+		t.Errorf("breaker allowed more probes than configured")
+	}
+	b.record(failure)
+	if b.State() != BreakerOpen {
+		t.Fatalf("breaker state = %s want open after a failed probe", b.State())
+	}
+
+	now = now.Add(time.Second)
+	if !execute(nil) {
+		t.Fatalf("breaker did not allow a probe after the open timeout")
+	}
+	if b.State() != BreakerClosed {
+		t.Fatalf("breaker state = %s want closed after a successful probe", b.State())
+	}
+}
+
+func Test_breakerWorker_Execute(t *testing.T) {
+	failure := errors.New("failure")
+	w := &mockWorker{err: failure}
+	bw := &breakerWorker{worker: w, breaker: newBreaker(BreakerConfig{WindowSize: 2, MinRequests: 2, FailureRate: 1, OpenTimeout: time.Hour})}
+	for i := 0; i < 2; i++ {
+		if err := bw.Execute(nil); err != failure {
+			t.Fatalf("breakerWorker.Execute() error = %v want %v", err, failure)
+		}
+	}
+	if err := bw.Execute(nil); err != ErrCircuitOpen {
+		t.Errorf("breakerWorker.Execute() error = %v want %v", err, ErrCircuitOpen)
+	}
+}
+
+func Test_breakerWorker_Execute_Permanent(t *testing.T) {
+	permanent := middleware.Permanent(errors.New("invalid metric"))
+	config := BreakerConfig{WindowSize: 2, MinRequests: 2, FailureRate: 1, OpenTimeout: time.Hour, HalfOpenProbes: 1}
+	bw := &breakerWorker{worker: &mockWorker{err: permanent}, breaker: newBreaker(config)}
+	for i := 0; i < 10; i++ {
+		if err := bw.Execute(nil); err != permanent {
+			t.Fatalf("breakerWorker.Execute() error = %v want %v", err, permanent)
+		}
+	}
+	if bw.breaker.State() != BreakerClosed {
+		t.Errorf("breaker state = %s want closed after permanent errors", bw.breaker.State())
+	}
+
+	//A probe failing with a permanent error doesn't use up the probes of a half open breaker
+	now := time.Now()
+	bw.breaker.now = func() time.Time {
+		return now
+	}
+	bw.breaker.open()
+	now = now.Add(time.Hour)
+	if err := bw.Execute(nil); err != permanent {
+		t.Fatalf("breakerWorker.Execute() error = %v want %v", err, permanent)
+	}
+	bw.worker = &mockWorker{}
+	if err := bw.Execute(nil); err != nil {
+		t.Fatalf("breakerWorker.Execute() error = %v want a probe execution", err)
+	}
+	if bw.breaker.State() != BreakerClosed {
+		t.Errorf("breaker state = %s want closed after a successful probe", bw.breaker.State())
+	}
+}
@@ -0,0 +1,38 @@
+package processor
+
+import (
+	"github.com/ottogiron/metricsworker/logging"
+)
+
+//DeadLetterer stores the tasks a worker gave up on so they can be processed again by that worker later,
+//e.g. a queue consumed once its backend recovers
+type DeadLetterer interface {
+	DeadLetter(workerID string, task interface{}, err error) error
+}
+
+//SetDeadLetter dead-letters every task a worker gave up on, after its retries failed or while its circuit breaker is open.
+//A failed task which is dead-lettered doesn't fail its message, so with manual acknowledgements the delivery is
+//acknowledged instead of rejected and lost. Tasks which fail to be dead-lettered fail the message as before
+func SetDeadLetter(deadLetterer DeadLetterer) Option {
+	return func(p *processor) {
+		p.deadLetterer = deadLetterer
+	}
+}
+
+//deadLetter reports a task a worker gave up on as failed and dead-letters it if a dead letterer is set. The dead
+//lettered hooks are called once the task was dead-lettered. It returns whether the task was dead-lettered
+func (p *processor) deadLetter(taskResult *taskResult, task interface{}, fields logging.Fields) bool {
+	taskResult.fields = fields
+	p.handleFailedTask(taskResult)
+	if p.deadLetterer == nil {
+		return false
+	}
+	err := p.deadLetterer.DeadLetter(taskResult.workerID, task, taskResult.err)
+	if err != nil {
+		p.logger.With(fields).Error("Failed to dead-letter task", logging.Fields{"worker_id": taskResult.workerID, "error": err})
+		return false
+	}
+	p.logger.With(fields).Info("Dead-lettered task", logging.Fields{"worker_id": taskResult.workerID})
+	p.emit(Event{Type: EventDeadLettered, WorkerID: taskResult.workerID, Task: task, Fields: fields, Err: taskResult.err})
+	return true
+}
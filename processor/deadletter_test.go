@@ -0,0 +1,117 @@
+package processor
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	fworkerprocessor "github.com/ferrariframework/ferrariworker/processor"
+	"github.com/streadway/amqp"
+)
+
+//mockDeadLetterer records the dead-lettered tasks by worker id and error
+type mockDeadLetterer struct {
+	err error
+
+	mu           sync.Mutex
+	deadLettered []string
+}
+
+func (d *mockDeadLetterer) DeadLetter(workerID string, task interface{}, err error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delivery, _ := task.(amqp.Delivery)
+	d.deadLettered = append(d.deadLettered, workerID+" "+delivery.MessageId+" "+err.Error())
+	return d.err
+}
+
+func Test_processor_Start_DeadLetter(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		wantAcks  []uint64
+		wantNacks []uint64
+	}{
+		{"Acknowledge dead-lettered tasks", nil, []uint64{1, 2, 3}, nil},
+		{"Reject tasks which failed to be dead-lettered", errors.New("broker unavailable"), nil, []uint64{1, 2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acknowledger := &mockAcknowledger{}
+			var messages []fworkerprocessor.Message
+			for i, id := range []string{"1", "2", "3"} {
+				delivery := amqp.Delivery{MessageId: id, Body: []byte(`{"username": "kodingbot", "count": 1, "metric": "kite_call"}`), Acknowledger: acknowledger, DeliveryTag: uint64(i + 1)}
+				messages = append(messages, fworkerprocessor.Message{Payload: delivery.Body, OriginalMessage: delivery})
+			}
+			deadLetterer := &mockDeadLetterer{err: tt.err}
+			p := New(
+				&processorAdapterMock{handler: mockMessagesHandler(messages)},
+				SetLogger(log.New(ioutil.Discard, "", 0)),
+				SetMaxInFlight(1),
+				SetManualAck(true),
+				//the first failure opens the circuit, the next tasks are rejected without executing the worker
+				SetCircuitBreaker(BreakerConfig{WindowSize: 1, MinRequests: 1, FailureRate: 1, OpenTimeout: time.Minute}),
+				SetDeadLetter(deadLetterer),
+			)
+			p.Register("ok", &mockWorker{})
+			p.Register("failing", &mockWorker{err: errors.New("store unavailable")})
+			if err := p.Start(); err != nil {
+				t.Fatalf("processor.Start() error = %v", err)
+			}
+
+			want := []string{"failing 1 store unavailable", "failing 2 circuit breaker is open", "failing 3 circuit breaker is open"}
+			if !reflect.DeepEqual(deadLetterer.deadLettered, want) {
+				t.Errorf("dead-lettered = %v want %v", deadLetterer.deadLettered, want)
+			}
+			if !reflect.DeepEqual(acknowledger.acks, tt.wantAcks) || !reflect.DeepEqual(acknowledger.nacks, tt.wantNacks) {
+				t.Errorf("acknowledged = %v rejected = %v want %v and %v", acknowledger.acks, acknowledger.nacks, tt.wantAcks, tt.wantNacks)
+			}
+		})
+	}
+}
+
+func Test_processor_Hooks_DeadLettered(t *testing.T) {
+	tests := []struct {
+		name         string
+		deadLetterer DeadLetterer
+		want         int
+	}{
+		{"Dead-lettered tasks", &mockDeadLetterer{}, 1},
+		{"Without dead letterer", nil, 0},
+		{"Failed to dead-letter", &mockDeadLetterer{err: errors.New("broker unavailable")}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var got []Event
+			options := []Option{
+				SetWaitTimeout(100),
+				SetLogger(log.New(ioutil.Discard, "", 0)),
+				OnDeadLettered(func(e Event) {
+					mu.Lock()
+					defer mu.Unlock()
+					got = append(got, e)
+				}),
+			}
+			if tt.deadLetterer != nil {
+				options = append(options, SetDeadLetter(tt.deadLetterer))
+			}
+			p := New(&processorAdapterMock{handler: mockMessagesHandler(successfullJobs[:1])}, options...)
+			p.Register("ok", &mockWorker{})
+			p.Register("broken", &mockWorker{err: errors.New("store unavailable")})
+			if err := p.Start(); err != nil {
+				t.Fatalf("processor.Start() error = %v", err)
+			}
+			if len(got) != tt.want {
+				t.Fatalf("processor.Start() dead lettered events = %d want %d", len(got), tt.want)
+			}
+			if tt.want > 0 && (got[0].WorkerID != "broken" || got[0].Err == nil) {
+				t.Errorf("processor.Start() dead lettered event = %+v", got[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package processor
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	fworkerprocessor "github.com/ferrariframework/ferrariworker/processor"
+	"github.com/ottogiron/metricsworker/logging"
+	"github.com/ottogiron/metricsworker/worker"
+	"github.com/streadway/amqp"
+)
+
+//seenStoreID id the seen store is initialized and closed with along with the workers
+const seenStoreID = "seen_store"
+
+//ErrDuplicateInFlight fails the duplicates of a message which is still being processed. With manual acknowledgements
+//they are rejected and requeued, so they are skipped once the message is processed or processed if it failed
+var ErrDuplicateInFlight = errors.New("duplicate of a message being processed")
+
+//SetDeduplication skips messages which were already processed by every worker, e.g. broker redeliveries.
+//Messages are identified by their AMQP message id, messages without one are not de-duplicated unless
+//SetContentDeduplication is set. A message key is claimed in the store before the message is processed and marked as
+//processed once every worker processed it successfully. If the message fails its key is released, so the message is
+//processed again if it is redelivered, e.g. requeued or dead-lettered back. Duplicates received while the message is
+//still being processed fail with ErrDuplicateInFlight
+func SetDeduplication(store worker.SeenStore) Option {
+	return func(p *processor) {
+		p.seenStore = store
+	}
+}
+
+//SetContentDeduplication identifies the messages without AMQP message id by a hash of their content when
+//de-duplicating. Identical messages are skipped then, e.g. two counts of 1 of the same metric and username
+//sent within the seen store ttl are processed once
+func SetContentDeduplication(enabled bool) Option {
+	return func(p *processor) {
+		p.contentDedup = enabled
+	}
+}
+
+//messageKey returns the key a message is de-duplicated by, empty if it is not de-duplicated
+func messageKey(m fworkerprocessor.Message, byContent bool) string {
+	body := m.Payload
+	if delivery, ok := m.OriginalMessage.(amqp.Delivery); ok {
+		if delivery.MessageId != "" {
+			return "id:" + delivery.MessageId
+		}
+		body = delivery.Body
+	}
+	if !byContent {
+		return ""
+	}
+	sum := sha1.Sum(body)
+	return "sha1:" + hex.EncodeToString(sum[:])
+}
+
+//claim claims the key of a message in the seen store. Messages which are claimed, not de-duplicated or whose claim
+//fails because of the store are processed until markProcessed is called with the returned key
+func (p *processor) claim(m fworkerprocessor.Message) (key string, state worker.SeenState) {
+	if p.seenStore == nil {
+		return "", worker.SeenClaimed
+	}
+	key = messageKey(m, p.contentDedup)
+	if key == "" {
+		return "", worker.SeenClaimed
+	}
+	state, err := p.seenStore.Claim(key)
+	if err != nil {
+		p.logger.Error("Failed to claim message", logging.Fields{"key": key, "error": err})
+		return "", worker.SeenClaimed
+	}
+	return key, state
+}
+
+//markProcessed ends the processing of a claimed message, it is marked as seen if every worker processed it
+//successfully and released otherwise
+func (p *processor) markProcessed(key string, succeeded bool) {
+	if key == "" {
+		return
+	}
+	if succeeded {
+		err := p.seenStore.MarkSeen(key)
+		if err != nil {
+			p.logger.Error("Failed to mark message as processed", logging.Fields{"key": key, "error": err})
+		}
+		return
+	}
+	err := p.seenStore.Release(key)
+	if err != nil {
+		p.logger.Error("Failed to release message", logging.Fields{"key": key, "error": err})
+	}
+}
+
+var _ worker.SeenStore = (*MemorySeenStore)(nil)
+
+type seenEntry struct {
+	key       string
+	processed bool
+	expires   time.Time
+}
+
+//MemorySeenStore in-memory LRU seen store. Keys are forgotten once they expire or when it is full
+type MemorySeenStore struct {
+	size int
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+//NewMemorySeenStore returns a new instance of an in-memory seen store keeping up to size keys for ttl
+func NewMemorySeenStore(size int, ttl time.Duration) *MemorySeenStore {
+	if size < 1 {
+		size = 1
+	}
+	return &MemorySeenStore{
+		size:    size,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+//Claim claims a key which is not claimed or processed, or returns its state
+func (s *MemorySeenStore) Claim(key string) (worker.SeenState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry := s.entry(key); entry != nil {
+		if entry.processed {
+			return worker.SeenProcessed, nil
+		}
+		return worker.SeenProcessing, nil
+	}
+	s.set(key, false)
+	return worker.SeenClaimed, nil
+}
+
+//MarkSeen marks a key as processed
+func (s *MemorySeenStore) MarkSeen(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.set(key, true)
+	return nil
+}
+
+//Release forgets a claimed key
+func (s *MemorySeenStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[key]; ok {
+		s.order.Remove(e)
+		delete(s.entries, key)
+	}
+	return nil
+}
+
+//entry returns the entry of a key which did not expire, s.mu should be held
+func (s *MemorySeenStore) entry(key string) *seenEntry {
+	e, ok := s.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := e.Value.(*seenEntry)
+	if !s.now().Before(entry.expires) {
+		s.order.Remove(e)
+		delete(s.entries, key)
+		return nil
+	}
+	s.order.MoveToFront(e)
+	return entry
+}
+
+//set sets the entry of a key for ttl, evicting the least recently used keys if the store is full. s.mu should be held
+func (s *MemorySeenStore) set(key string, processed bool) {
+	expires := s.now().Add(s.ttl)
+	if e, ok := s.entries[key]; ok {
+		entry := e.Value.(*seenEntry)
+		entry.processed, entry.expires = processed, expires
+		s.order.MoveToFront(e)
+		return
+	}
+	for s.order.Len() >= s.size {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*seenEntry).key)
+	}
+	s.entries[key] = s.order.PushFront(&seenEntry{key: key, processed: processed, expires: expires})
+}
@@ -0,0 +1,216 @@
+package processor
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	fworkerprocessor "github.com/ferrariframework/ferrariworker/processor"
+	"github.com/ottogiron/metricsworker/worker"
+	"github.com/streadway/amqp"
+)
+
+func TestMemorySeenStore(t *testing.T) {
+	now := time.Now()
+	s := NewMemorySeenStore(2, time.Minute)
+	s.now = func() time.Time {
+		return now
+	}
+	claim := func(key string, want worker.SeenState) {
+		t.Helper()
+		got, err := s.Claim(key)
+		if err != nil {
+			t.Fatalf("MemorySeenStore.Claim() error = %v", err)
+		}
+		if got != want {
+			t.Errorf("MemorySeenStore.Claim(%s) = %v want %v", key, got, want)
+		}
+	}
+
+	claim("a", worker.SeenClaimed)
+	claim("a", worker.SeenProcessing)
+	s.MarkSeen("a")
+	claim("a", worker.SeenProcessed)
+	//Released keys are claimed again
+	claim("b", worker.SeenClaimed)
+	s.Release("b")
+	claim("b", worker.SeenClaimed)
+	//a was used more recently than b, so b is evicted
+	claim("a", worker.SeenProcessed)
+	claim("c", worker.SeenClaimed)
+	claim("b", worker.SeenClaimed)
+	//Keys are forgotten once they expire
+	now = now.Add(time.Minute)
+	claim("b", worker.SeenClaimed)
+}
+
+func Test_messageKey(t *testing.T) {
+	body := []byte(`{"metric": "kite_call"}`)
+	tests := []struct {
+		name      string
+		message   fworkerprocessor.Message
+		byContent bool
+		want      string
+	}{
+		{"Message id", fworkerprocessor.Message{OriginalMessage: amqp.Delivery{MessageId: "1", Body: body}}, false, "id:1"},
+		{"Without message id", fworkerprocessor.Message{OriginalMessage: amqp.Delivery{Body: body}}, false, ""},
+		{"Delivery body hash", fworkerprocessor.Message{OriginalMessage: amqp.Delivery{Body: body}}, true, "sha1:34725d2834cb2ddeb6b7c95b85c06024266915bf"},
+		{"Payload hash", fworkerprocessor.Message{Payload: body}, true, "sha1:34725d2834cb2ddeb6b7c95b85c06024266915bf"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := messageKey(tt.message, tt.byContent); got != tt.want {
+				t.Errorf("messageKey() = %s want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_processor_Start_Deduplication(t *testing.T) {
+	messages := []fworkerprocessor.Message{
+		{OriginalMessage: amqp.Delivery{MessageId: "1", Body: []byte("message 1")}},
+		{OriginalMessage: amqp.Delivery{MessageId: "1", Body: []byte("message 1"), Redelivered: true}},
+		{OriginalMessage: amqp.Delivery{Body: []byte("message 2")}},
+		{OriginalMessage: amqp.Delivery{Body: []byte("message 2")}},
+		{OriginalMessage: amqp.Delivery{Body: []byte("message 3")}},
+	}
+	tests := []struct {
+		name          string
+		options       []Option
+		wantProcessed int
+	}{
+		{"Skip messages with the same id", nil, 4},
+		{"Skip messages with the same content", []Option{SetContentDeduplication(true)}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			processed := 0
+			options := append([]Option{
+				SetDeduplication(NewMemorySeenStore(10, time.Minute)),
+				SetMaxInFlight(1),
+				SetWaitTimeout(100),
+				SetLogger(log.New(ioutil.Discard, "", 0)),
+			}, tt.options...)
+			p := New(&processorAdapterMock{handler: mockMessagesHandler(messages)}, options...)
+			p.Register("counter", &mockWorker{handler: func(task interface{}) {
+				mu.Lock()
+				processed++
+				mu.Unlock()
+			}})
+			if err := p.Start(); err != nil {
+				t.Fatalf("processor.Start() error = %v", err)
+			}
+			if processed != tt.wantProcessed {
+				t.Errorf("processor.Start() processed = %d want %d", processed, tt.wantProcessed)
+			}
+		})
+	}
+}
+
+func Test_processor_Start_DeduplicationInFlight(t *testing.T) {
+	feed := make(chan fworkerprocessor.Message)
+	acknowledger := &mockAcknowledger{}
+	p := New(
+		&processorAdapterMock{handler: mockMessagesHandlerFromChannel(feed)},
+		SetDeduplication(NewMemorySeenStore(10, time.Minute)),
+		SetManualAck(true),
+		SetWaitTimeout(0),
+		SetLogger(log.New(ioutil.Discard, "", 0)),
+	)
+	executing := make(chan struct{})
+	release := make(chan struct{})
+	var executions int32
+	p.Register("blocking", &mockWorker{handler: func(task interface{}) {
+		if atomic.AddInt32(&executions, 1) == 1 {
+			close(executing)
+			<-release
+		}
+	}})
+	started := make(chan error)
+	go func() {
+		started <- p.Start()
+	}()
+	send := func(tag uint64) {
+		delivery := amqp.Delivery{MessageId: "1", Body: []byte("message 1"), Acknowledger: acknowledger, DeliveryTag: tag}
+		feed <- fworkerprocessor.Message{Payload: delivery.Body, OriginalMessage: delivery}
+	}
+
+	send(1)
+	<-executing
+	//the duplicate received while the message is processed is requeued instead of acknowledged
+	send(2)
+	deadline := time.Now().Add(time.Second)
+	for {
+		acknowledger.mu.Lock()
+		requeued := len(acknowledger.requeued)
+		acknowledger.mu.Unlock()
+		if requeued > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the duplicate of a message in flight was not requeued")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(release)
+	close(feed)
+	if err := <-started; err != nil {
+		t.Fatalf("processor.Start() error = %v", err)
+	}
+	if !reflect.DeepEqual(acknowledger.acks, []uint64{1}) || !reflect.DeepEqual(acknowledger.requeued, []uint64{2}) {
+		t.Errorf("acknowledged = %v requeued = %v want [1] and [2]", acknowledger.acks, acknowledger.requeued)
+	}
+	if executions != 1 {
+		t.Errorf("worker executions = %d want 1", executions)
+	}
+}
+
+func Test_processor_Start_DeduplicationFailure(t *testing.T) {
+	messages := []fworkerprocessor.Message{
+		{OriginalMessage: amqp.Delivery{MessageId: "1", Body: []byte("message 1")}},
+		{OriginalMessage: amqp.Delivery{MessageId: "1", Body: []byte("message 1"), Redelivered: true}},
+		{OriginalMessage: amqp.Delivery{MessageId: "1", Body: []byte("message 1"), Redelivered: true}},
+	}
+	var mu sync.Mutex
+	attempts := 0
+	p := New(
+		&processorAdapterMock{handler: mockMessagesHandler(messages)},
+		SetDeduplication(NewMemorySeenStore(10, time.Minute)),
+		SetMaxInFlight(1),
+		SetWaitTimeout(100),
+		SetLogger(log.New(ioutil.Discard, "", 0)),
+	)
+	//deliveries are processed one at a time as redeliveries are, the first delivery fails, so its redelivery is processed again and the next one is skipped
+	p.Register("counter", &failingWorker{failures: 1, handler: func() {
+		mu.Lock()
+		attempts++
+		mu.Unlock()
+	}})
+	if err := p.Start(); err != nil {
+		t.Fatalf("processor.Start() error = %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("processor.Start() attempts = %d want 2", attempts)
+	}
+}
+
+//failingWorker fails its first executions
+type failingWorker struct {
+	failures int
+	handler  func()
+}
+
+func (w *failingWorker) Execute(task interface{}) error {
+	w.handler()
+	if w.failures > 0 {
+		w.failures--
+		return errors.New("store unavailable")
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package processor
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ottogiron/metricsworker/logging"
+	"github.com/ottogiron/metricsworker/worker"
+	"github.com/ottogiron/metricsworker/worker/middleware"
+)
+
+//EventType type of a processor event
+type EventType string
+
+//Processor events hooks can be registered for
+const (
+	//EventMessageReceived a message was read from an adapter
+	EventMessageReceived EventType = "message_received"
+	//EventWorkerStarted a worker started executing a task
+	EventWorkerStarted EventType = "worker_started"
+	//EventWorkerSucceeded a worker execution succeeded
+	EventWorkerSucceeded EventType = "worker_succeeded"
+	//EventWorkerFailed a worker execution failed, it may be retried
+	EventWorkerFailed EventType = "worker_failed"
+	//EventRetryScheduled a failed worker execution will be retried after the event backoff
+	EventRetryScheduled EventType = "retry_scheduled"
+	//EventDeadLettered a task a worker gave up on, after its attempts failed or while its circuit breaker was open,
+	//was dead-lettered, see SetDeadLetter
+	EventDeadLettered EventType = "dead_lettered"
+	//EventMessageProcessed every task of a message was processed by every worker, Err is nil if all of them succeeded.
+	//Batched messages are reported once for all their metrics
+	EventMessageProcessed EventType = "message_processed"
+	//EventIdleTimeout no message was received within the wait timeout, the processor stops
+	EventIdleTimeout EventType = "idle_timeout"
+	//EventShutdown the processor stopped, every message was processed and the adapters closed
+	EventShutdown EventType = "shutdown"
+)
+
+//Event describes something which happened in the processor
+type Event struct {
+	Type EventType
+	Time time.Time
+	//Worker the event belongs to, empty for message and processor events
+	WorkerID string
+	//Task the event belongs to, nil for processor events
+	Task interface{}
+	//Fields identifying the task: message_id, metric and username when available
+	Fields map[string]interface{}
+	//Failed attempt of retry scheduled events
+	Attempt int
+	//Wait until the next attempt of retry scheduled events
+	Backoff time.Duration
+	//Duration of the execution of worker succeeded and failed events
+	Duration time.Duration
+	//Err the task failed with, or the error Start returns on shutdown
+	Err error
+}
+
+//Hook a callback called on processor events. Hooks are called synchronously in the goroutine processing the event,
+//so they should return quickly
+type Hook func(e Event)
+
+//AddHook registers a hook called on every event of the given type
+func AddHook(eventType EventType, hook Hook) Option {
+	return func(p *processor) {
+		if p.hooks == nil {
+			p.hooks = make(map[EventType][]Hook)
+		}
+		p.hooks[eventType] = append(p.hooks[eventType], hook)
+	}
+}
+
+//OnMessageReceived registers a hook called when a message is read from an adapter
+func OnMessageReceived(hook Hook) Option {
+	return AddHook(EventMessageReceived, hook)
+}
+
+//OnWorkerStarted registers a hook called when a worker starts executing a task
+func OnWorkerStarted(hook Hook) Option {
+	return AddHook(EventWorkerStarted, hook)
+}
+
+//OnWorkerSucceeded registers a hook called when a worker execution succeeds
+func OnWorkerSucceeded(hook Hook) Option {
+	return AddHook(EventWorkerSucceeded, hook)
+}
+
+//OnWorkerFailed registers a hook called when a worker execution fails
+func OnWorkerFailed(hook Hook) Option {
+	return AddHook(EventWorkerFailed, hook)
+}
+
+//OnRetryScheduled registers a hook called when a failed worker execution is retried, see SetRetry
+func OnRetryScheduled(hook Hook) Option {
+	return AddHook(EventRetryScheduled, hook)
+}
+
+//OnDeadLettered registers a hook called when a task a worker gave up on is dead-lettered
+func OnDeadLettered(hook Hook) Option {
+	return AddHook(EventDeadLettered, hook)
+}
+
+//OnMessageProcessed registers a hook called when every task of a message was processed, see EventMessageProcessed
+func OnMessageProcessed(hook Hook) Option {
+	return AddHook(EventMessageProcessed, hook)
+}
+
+//OnIdleTimeout registers a hook called when no message is received within the wait timeout
+func OnIdleTimeout(hook Hook) Option {
+	return AddHook(EventIdleTimeout, hook)
+}
+
+//OnShutdown registers a hook called when the processor stops
+func OnShutdown(hook Hook) Option {
+	return AddHook(EventShutdown, hook)
+}
+
+//SetRetry executes every worker up to attempts times until it succeeds. The wait between attempts starts at backoff
+//and doubles after every attempt, permanent errors (see middleware.Permanent) are not retried.
+//Retries are logged and reported to the retry scheduled hooks
+func SetRetry(attempts int, backoff time.Duration) Option {
+	return func(p *processor) {
+		p.retry = &retryConfig{attempts: attempts, backoff: backoff}
+	}
+}
+
+type retryConfig struct {
+	attempts int
+	backoff  time.Duration
+}
+
+//emit calls the hooks of an event
+func (p *processor) emit(e Event) {
+	hooks := p.hooks[e.Type]
+	if len(hooks) == 0 {
+		return
+	}
+	e.Time = time.Now()
+	for _, hook := range hooks {
+		hook(e)
+	}
+}
+
+//hooked returns whether there are hooks for any of the event types
+func (p *processor) hooked(eventTypes ...EventType) bool {
+	for _, eventType := range eventTypes {
+		if len(p.hooks[eventType]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+//retryMiddleware retries the executions of a worker with the processor retry configuration
+func (p *processor) retryMiddleware(id string) worker.Middleware {
+	logger := p.logger.With(logging.Fields{"worker_id": id})
+	return middleware.RetryNotify(p.retry.attempts, p.retry.backoff, func(task interface{}, attempt int, wait time.Duration, err error) {
+		fields := worker.TaskFields(task)
+		logger.Info("Retrying task", logging.Fields{"message_id": fields["message_id"], "metric": fields["metric"], "username": fields["username"], "attempt": attempt, "backoff": wait, "error": err})
+		p.emit(Event{Type: EventRetryScheduled, WorkerID: id, Task: task, Fields: fields, Attempt: attempt, Backoff: wait, Err: err})
+	})
+}
+
+//hookedWorker reports the executions of a worker to the processor hooks
+type hookedWorker struct {
+	id     string
+	worker worker.Worker
+	p      *processor
+}
+
+func (w *hookedWorker) Execute(task interface{}) (err error) {
+	fields := worker.TaskFields(task)
+	w.p.emit(Event{Type: EventWorkerStarted, WorkerID: w.id, Task: task, Fields: fields})
+	start := time.Now()
+	defer func() {
+		e := Event{Type: EventWorkerSucceeded, WorkerID: w.id, Task: task, Fields: fields, Duration: time.Since(start), Err: err}
+		if r := recover(); r != nil {
+			//report the panic as a failure and let the recovery middlewares handle it
+			e.Type = EventWorkerFailed
+			e.Err = fmt.Errorf("Worker panicked %v", r)
+			w.p.emit(e)
+			panic(r)
+		}
+		if err != nil {
+			e.Type = EventWorkerFailed
+		}
+		w.p.emit(e)
+	}()
+	return w.worker.Execute(task)
+}
@@ -0,0 +1,117 @@
+package processor
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ottogiron/metricsworker/worker"
+)
+
+func Test_processor_Hooks(t *testing.T) {
+	var mu sync.Mutex
+	counts := make(map[EventType]int)
+	var deadLettered, retried []Event
+	record := func(e Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		counts[e.Type]++
+		switch e.Type {
+		case EventDeadLettered:
+			deadLettered = append(deadLettered, e)
+		case EventRetryScheduled:
+			retried = append(retried, e)
+		}
+	}
+	options := []Option{
+		SetWaitTimeout(100),
+		SetLogger(log.New(ioutil.Discard, "", 0)),
+		SetRetry(2, time.Millisecond),
+		SetDeadLetter(&mockDeadLetterer{}),
+	}
+	for _, eventType := range []EventType{EventMessageReceived, EventWorkerStarted, EventWorkerSucceeded, EventWorkerFailed, EventRetryScheduled, EventDeadLettered, EventShutdown} {
+		options = append(options, AddHook(eventType, record))
+	}
+	p := New(&processorAdapterMock{handler: mockMessagesHandler(successfullJobs[:1])}, options...)
+
+	failure := errors.New("failure")
+	flakyExecutions := 0
+	p.Register("ok", &mockWorker{})
+	p.Register("flaky", worker.Func(func(task interface{}) error {
+		flakyExecutions++
+		if flakyExecutions == 1 {
+			return failure
+		}
+		return nil
+	}))
+	p.Register("broken", &mockWorker{err: failure})
+	if err := p.Start(); err != nil {
+		t.Fatalf("processor.Start() error = %v", err)
+	}
+
+	want := map[EventType]int{
+		EventMessageReceived: 1,
+		EventWorkerStarted:   5,
+		EventWorkerSucceeded: 2,
+		EventWorkerFailed:    3,
+		EventRetryScheduled:  2,
+		EventDeadLettered:    1,
+		EventShutdown:        1,
+	}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("processor.Start() events = %v want %v", counts, want)
+	}
+	if len(deadLettered) == 1 && (deadLettered[0].WorkerID != "broken" || deadLettered[0].Err != failure) {
+		t.Errorf("processor.Start() dead lettered event = %+v", deadLettered[0])
+	}
+	for _, e := range retried {
+		if e.Attempt != 1 || e.Backoff != time.Millisecond || e.Err != failure {
+			t.Errorf("processor.Start() retry scheduled event = %+v", e)
+		}
+	}
+}
+
+func Test_processor_Hooks_Shutdown(t *testing.T) {
+	openErr := errors.New("open failure")
+	var got []Event
+	p := New(
+		&processorAdapterMock{openErr: openErr},
+		SetLogger(log.New(ioutil.Discard, "", 0)),
+		OnShutdown(func(e Event) {
+			got = append(got, e)
+		}),
+	)
+	err := p.Start()
+	if err == nil {
+		t.Fatal("processor.Start() error = nil want an open error")
+	}
+	if len(got) != 1 || got[0].Err != err || got[0].Time.IsZero() {
+		t.Errorf("processor.Start() shutdown events = %+v want one with error %v", got, err)
+	}
+}
+
+func Test_processor_Hooks_IdleTimeout(t *testing.T) {
+	var mu sync.Mutex
+	idle := 0
+	p := New(
+		&processorAdapterMock{handler: mockSleepMessagesHandler(time.Millisecond * 500)},
+		SetConcurrency(3),
+		SetWaitTimeout(100),
+		SetLogger(log.New(ioutil.Discard, "", 0)),
+		OnIdleTimeout(func(e Event) {
+			mu.Lock()
+			idle++
+			mu.Unlock()
+		}),
+	)
+	if err := p.Start(); err != nil {
+		t.Fatalf("processor.Start() error = %v", err)
+	}
+	if idle != 1 {
+		t.Errorf("processor.Start() idle timeout events = %d want 1", idle)
+	}
+}
@@ -0,0 +1,106 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"sync"
+	"testing"
+
+	fworkerprocessor "github.com/ferrariframework/ferrariworker/processor"
+)
+
+//lifecycleWorker records its lifecycle calls and executions
+type lifecycleWorker struct {
+	id      string
+	mu      *sync.Mutex
+	calls   *[]string
+	initErr error
+	//before and after if set run around the executions
+	before, after func()
+}
+
+func (w *lifecycleWorker) record(call string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	*w.calls = append(*w.calls, w.id+" "+call)
+}
+
+func (w *lifecycleWorker) Init(ctx context.Context) error {
+	if _, ok := ctx.Deadline(); !ok {
+		return errors.New("init context without deadline")
+	}
+	w.record("init")
+	return w.initErr
+}
+
+func (w *lifecycleWorker) Close(ctx context.Context) error {
+	w.record("close")
+	return nil
+}
+
+func (w *lifecycleWorker) Execute(task interface{}) error {
+	if w.before != nil {
+		w.before()
+	}
+	w.record("execute")
+	if w.after != nil {
+		w.after()
+	}
+	return nil
+}
+
+func Test_processor_Start_Lifecycle(t *testing.T) {
+	initErr := errors.New("connection refused")
+	tests := []struct {
+		name      string
+		initErr   error
+		wantErr   bool
+		wantCalls []string
+	}{
+		{
+			"Initialized on start and closed on shutdown",
+			nil,
+			false,
+			[]string{"a init", "b init", "a execute", "b execute", "a close", "b close"},
+		},
+		{
+			"Fails fast if a worker fails to initialize",
+			initErr,
+			true,
+			[]string{"a init", "b init", "a close"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var calls []string
+			opened := false
+			p := New(
+				&processorAdapterMock{handler: func(ctx context.Context) (<-chan fworkerprocessor.Message, error) {
+					opened = true
+					return mockMessagesHandler(successfullJobs[:1])(ctx)
+				}},
+				SetWaitTimeout(100),
+				SetLogger(log.New(ioutil.Discard, "", 0)),
+			)
+			//executions run concurrently so the second worker waits for the first one
+			aDone := make(chan struct{})
+			p.Register("a", &lifecycleWorker{id: "a", mu: &mu, calls: &calls, after: func() { close(aDone) }})
+			p.Register("b", &lifecycleWorker{id: "b", mu: &mu, calls: &calls, initErr: tt.initErr, before: func() { <-aDone }})
+
+			err := p.Start()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("processor.Start() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if opened == tt.wantErr {
+				t.Errorf("processor.Start() adapter opened = %v", opened)
+			}
+			if !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("processor.Start() calls = %v want %v", calls, tt.wantCalls)
+			}
+		})
+	}
+}
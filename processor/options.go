@@ -2,27 +2,73 @@ package processor
 
 import "time"
 import "log"
+import "github.com/ottogiron/metricsworker/logging"
+import "github.com/ottogiron/metricsworker/worker"
 
 //Option a functional option for the processor
 type Option func(*processor)
 
-//SetConcurrency sets the concurrency for registered workers
+//SetConcurrency sets the number of goroutines reading messages and the default pool size of registered workers
 func SetConcurrency(concurrency int) Option {
 	return func(p *processor) {
 		p.concurrency = concurrency
 	}
 }
 
-//SetWaitTimeout sets the time in milliseconds the processor will wait (keep the connection open) for new tasks
+//SetWaitTimeout sets the time in milliseconds the processor will wait (keep the connection open) for new tasks.
+//A timeout of 0 waits forever
 func SetWaitTimeout(waitTimeout time.Duration) Option {
 	return func(p *processor) {
 		p.waitTimeout = waitTimeout
 	}
 }
 
-//SetLogger sets the processor logger
+//SetMaxInFlight sets the maximum number of messages processed at the same time. Once it is reached
+//no more messages are read from the adapters until a message is processed by every worker
+func SetMaxInFlight(maxInFlight int) Option {
+	return func(p *processor) {
+		if maxInFlight > 0 {
+			p.maxInFlight = maxInFlight
+		}
+	}
+}
+
+//DefaultLifecycleTimeout default time workers are given to initialize and to close
+const DefaultLifecycleTimeout = 10 * time.Second
+
+//SetLifecycleTimeout sets the time workers implementing worker.Lifecycle are given to initialize on Start, and to close on shutdown
+func SetLifecycleTimeout(timeout time.Duration) Option {
+	return func(p *processor) {
+		if timeout > 0 {
+			p.lifecycleTimeout = timeout
+		}
+	}
+}
+
+//SetLogger sets a standard library logger as the processor logger, entries of every level are written as logfmt
 func SetLogger(logger *log.Logger) Option {
+	return func(p *processor) {
+		p.logger = logging.FromStdLogger(logger)
+	}
+}
+
+//SetStructuredLogger sets the processor logger
+func SetStructuredLogger(logger logging.Logger) Option {
 	return func(p *processor) {
 		p.logger = logger
 	}
 }
+
+//AddSource adds an adapter whose messages are processed by the registered workers along with the processor adapter ones
+func AddSource(adapter Adapter) Option {
+	return func(p *processor) {
+		p.sources = append(p.sources, adapter)
+	}
+}
+
+//AddMiddleware wraps every registered worker with middlewares. The first middleware is the outermost one
+func AddMiddleware(middlewares ...worker.Middleware) Option {
+	return func(p *processor) {
+		p.middlewares = append(p.middlewares, middlewares...)
+	}
+}
@@ -0,0 +1,119 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sync"
+
+	fworkerprocessor "github.com/ferrariframework/ferrariworker/processor"
+	"github.com/streadway/amqp"
+)
+
+//DefaultPartitionKey default message field messages are partitioned by
+const DefaultPartitionKey = "username"
+
+//SetPartitionKey enables ordered processing per key. Messages are hashed by the value of the given field of their
+//first metric onto a fixed number of lanes (the processor concurrency), see partitionKey. Every lane processes its
+//messages one at a time, so messages with the same key are processed in the order they were received, while lanes
+//run in parallel
+func SetPartitionKey(field string) Option {
+	return func(p *processor) {
+		p.partitionKey = field
+	}
+}
+
+//partitioned a message expanded in its transformed tasks to compute its partition key, see expand
+type partitioned struct {
+	m     fworkerprocessor.Message
+	tasks []transformedTask
+	err   error
+}
+
+//dispatchPartitioned reads messages and dispatches them to the lane of their key.
+//It returns once the messages channel is closed or the wait timeout expires and every message was processed
+func (p *processor) dispatchPartitioned(msgs <-chan fworkerprocessor.Message, inFlight chan struct{}) {
+	lanes := make([]chan partitioned, p.concurrency)
+	wg := sync.WaitGroup{}
+	wg.Add(len(lanes))
+	for i := range lanes {
+		lanes[i] = make(chan partitioned, p.maxInFlight)
+		go func(lane <-chan partitioned) {
+			defer wg.Done()
+			for item := range lane {
+				p.handleExpanded(item.m, item.tasks, item.err)
+				<-inFlight
+			}
+		}(lanes[i])
+	}
+	defer func() {
+		for _, lane := range lanes {
+			close(lane)
+		}
+		wg.Wait()
+	}()
+
+	for {
+		inFlight <- struct{}{}
+		select {
+		case m, ok := <-msgs:
+			if !ok {
+				<-inFlight
+				return
+			}
+			//the message is expanded and transformed once, its tasks are processed by the lane
+			tasks, err := expand(m.OriginalMessage)
+			item := partitioned{m: m, tasks: p.transform(tasks), err: err}
+			lanes[lane(messagePartitionKey(item, p.partitionKey), len(lanes))] <- item
+		case <-p.idle():
+			p.emit(Event{Type: EventIdleTimeout})
+			<-inFlight
+			return
+		case <-p.drain:
+			<-inFlight
+			return
+		}
+	}
+}
+
+//messagePartitionKey returns the partition key of the first transformed metric of an expanded message, so messages
+//of every encoding, compressed and batched messages are partitioned by their metrics as the workers receive them.
+//Batched metrics with different keys are ordered by the key of the first one. Messages which can't be decoded or
+//transformed share the empty key
+func messagePartitionKey(item partitioned, field string) string {
+	if item.err != nil {
+		return ""
+	}
+	for _, t := range item.tasks {
+		if t.err != nil {
+			continue
+		}
+		if delivery, ok := t.task.(amqp.Delivery); ok {
+			return partitionKey(delivery.Body, field)
+		}
+		return partitionKey(item.m.Payload, field)
+	}
+	return ""
+}
+
+//partitionKey returns the value of a payload field. Payloads which are not JSON objects or don't have the field
+//share the empty key
+func partitionKey(payload []byte, field string) string {
+	var fields map[string]interface{}
+	err := json.Unmarshal(payload, &fields)
+	if err != nil {
+		return ""
+	}
+	value, ok := fields[field]
+	if !ok || value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+//lane returns the lane of a key
+func lane(key string, lanes int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(lanes))
+}
@@ -0,0 +1,126 @@
+package processor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	fworkerprocessor "github.com/ferrariframework/ferrariworker/processor"
+	"github.com/ottogiron/metricsworker/worker"
+	"github.com/streadway/amqp"
+)
+
+func Test_partitionKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		field   string
+		want    string
+	}{
+		{"String field", `{"username": "kodingbot", "count": 1}`, "username", "kodingbot"},
+		{"Number field", `{"username": "kodingbot", "count": 1}`, "count", "1"},
+		{"Missing field", `{"count": 1}`, "username", ""},
+		{"Invalid payload", `{"username": `, "username", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := partitionKey([]byte(tt.payload), tt.field); got != tt.want {
+				t.Errorf("partitionKey() = %s want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_messagePartitionKey(t *testing.T) {
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write([]byte(`{"username": "kodingbot", "count": 1, "metric": "kite_call"}`))
+	gz.Close()
+	lowercase := transformerFunc(func(body string) (string, error) {
+		return strings.ToLower(body), nil
+	})
+	failFirst := transformerFunc(func(body string) (string, error) {
+		if strings.Contains(body, `"koding"`) {
+			return "", errors.New("failed to transform")
+		}
+		return body, nil
+	})
+	tests := []struct {
+		name        string
+		message     fworkerprocessor.Message
+		transformer Transformer
+		want        string
+	}{
+		{"JSON", fworkerprocessor.Message{OriginalMessage: amqp.Delivery{Body: []byte(`{"username": "kodingbot", "count": 1}`)}}, nil, "kodingbot"},
+		{"Gzip", fworkerprocessor.Message{OriginalMessage: amqp.Delivery{ContentEncoding: "gzip", Body: gzipped.Bytes()}}, nil, "kodingbot"},
+		{"StatsD", fworkerprocessor.Message{OriginalMessage: amqp.Delivery{ContentType: worker.ContentTypeStatsD, Body: []byte("kite_call:1|c|#username:kodingbot")}}, nil, "kodingbot"},
+		{"Batch", fworkerprocessor.Message{OriginalMessage: amqp.Delivery{Body: []byte(`[{"username": "koding", "count": 1}, {"username": "kodingbot", "count": 1}]`)}}, nil, "koding"},
+		{"Invalid message", fworkerprocessor.Message{OriginalMessage: amqp.Delivery{ContentType: worker.ContentTypeStatsD, Body: []byte("kite_call")}}, nil, ""},
+		{"Payload", fworkerprocessor.Message{Payload: []byte(`{"username": "kodingbot"}`), OriginalMessage: "task"}, nil, "kodingbot"},
+		{"Transformed", fworkerprocessor.Message{OriginalMessage: amqp.Delivery{Body: []byte(`{"username": "Kodingbot", "count": 1}`)}}, lowercase, "kodingbot"},
+		{"First metric failed to transform", fworkerprocessor.Message{OriginalMessage: amqp.Delivery{Body: []byte(`[{"username": "koding", "count": 1}, {"username": "kodingbot", "count": 1}]`)}}, failFirst, "kodingbot"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &processor{transformer: tt.transformer}
+			tasks, err := expand(tt.message.OriginalMessage)
+			if got := messagePartitionKey(partitioned{m: tt.message, tasks: p.transform(tasks), err: err}, "username"); got != tt.want {
+				t.Errorf("messagePartitionKey() = %s want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_processor_Start_Partitioned(t *testing.T) {
+	users := []string{"a", "b", "c", "d"}
+	messages := []fworkerprocessor.Message{}
+	for i := 0; i < 20; i++ {
+		payload := []byte(fmt.Sprintf(`{"username": "%s", "count": %d}`, users[i%len(users)], i))
+		messages = append(messages, fworkerprocessor.Message{Payload: payload, OriginalMessage: payload})
+	}
+
+	var mu sync.Mutex
+	processed := make(map[string][]int)
+	p := New(
+		&processorAdapterMock{handler: mockMessagesHandler(messages)},
+		SetConcurrency(3),
+		SetPartitionKey(DefaultPartitionKey),
+		SetWaitTimeout(200),
+		SetLogger(log.New(ioutil.Discard, "", 0)),
+	)
+	p.Register("ordered", &mockWorker{handler: func(task interface{}) {
+		time.Sleep(time.Duration(rand.Intn(3)) * time.Millisecond)
+		payload := task.([]byte)
+		var user string
+		var count int
+		fmt.Sscanf(partitionKey(payload, "username")+" "+partitionKey(payload, "count"), "%s %d", &user, &count)
+		mu.Lock()
+		processed[user] = append(processed[user], count)
+		mu.Unlock()
+	}})
+	if err := p.Start(); err != nil {
+		t.Fatalf("processor.Start() error = %v", err)
+	}
+
+	total := 0
+	for user, counts := range processed {
+		total += len(counts)
+		for i := 1; i < len(counts); i++ {
+			if counts[i] < counts[i-1] {
+				t.Errorf("processor.Start() user %s processed out of order %v", user, counts)
+				break
+			}
+		}
+	}
+	if total != len(messages) {
+		t.Errorf("processor.Start() processed = %d want %d", total, len(messages))
+	}
+}
@@ -0,0 +1,200 @@
+package processor
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ottogiron/metricsworker/logging"
+	"github.com/ottogiron/metricsworker/tracing"
+	"github.com/ottogiron/metricsworker/worker"
+)
+
+//RegistrationOption a functional option for a worker registration
+type RegistrationOption func(*registration)
+
+//SetPoolSize sets the number of goroutines executing tasks for the registered worker.
+//It defaults to the processor concurrency
+func SetPoolSize(size int) RegistrationOption {
+	return func(r *registration) {
+		r.poolSize = size
+	}
+}
+
+//SetQueueSize sets the number of tasks queued for the registered worker until the processor blocks.
+//It defaults to the pool size
+func SetQueueSize(size int) RegistrationOption {
+	return func(r *registration) {
+		r.queueSize = size
+	}
+}
+
+//AddWorkerMiddleware wraps the registered worker with middlewares. They run inside the processor middlewares
+func AddWorkerMiddleware(middlewares ...worker.Middleware) RegistrationOption {
+	return func(r *registration) {
+		r.middlewares = append(r.middlewares, middlewares...)
+	}
+}
+
+//ReceiveOriginal sets the registered worker to receive every message once as it was delivered, before it is expanded
+//and transformed, e.g. to archive it. Routes don't apply to it and its failures fail the message like any other worker
+func ReceiveOriginal() RegistrationOption {
+	return func(r *registration) {
+		r.original = true
+	}
+}
+
+//SetPaused registers the worker paused, it doesn't receive messages until it is resumed, see Settings.Resume
+func SetPaused() RegistrationOption {
+	return func(r *registration) {
+		r.paused = true
+	}
+}
+
+type registration struct {
+	poolSize    int
+	queueSize   int
+	rateLimit   *RateLimitConfig
+	middlewares []worker.Middleware
+	original    bool
+	paused      bool
+}
+
+type job struct {
+	task   interface{}
+	fields logging.Fields
+	span   *tracing.Span
+	out    chan<- taskResult
+	done   func()
+}
+
+//pool executes the tasks of a worker with a bounded number of goroutines reading from its own queue,
+//so a slow worker doesn't hold back the others
+type pool struct {
+	//counters of the pool executions, first so they are 64-bit aligned for atomic operations
+	running  int64
+	executed uint64
+	failed   uint64
+
+	id     string
+	worker worker.Worker
+	queue  chan job
+	logger logging.Logger
+	wg     sync.WaitGroup
+	//quit stops a goroutine when the pool shrinks, stopping is closed when the pool is stopped
+	quit     chan struct{}
+	stopping chan struct{}
+	//mu guards stopped and size, submitters hold it while queueing so the queue is not closed under them
+	mu      sync.RWMutex
+	stopped bool
+	size    int
+}
+
+func newPool(id string, w worker.Worker, size, queueSize int, logger logging.Logger) *pool {
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	p := &pool{
+		id:       id,
+		worker:   w,
+		queue:    make(chan job, queueSize),
+		quit:     make(chan struct{}),
+		stopping: make(chan struct{}),
+		logger:   logger.With(logging.Fields{"worker_id": id}),
+	}
+	p.resize(size)
+	return p
+}
+
+//run executes queued jobs until the pool is stopped or shrinks
+func (p *pool) run() {
+	defer p.wg.Done()
+	for {
+		select {
+		case j, ok := <-p.queue:
+			if !ok {
+				return
+			}
+			p.execute(j)
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+func (p *pool) execute(j job) {
+	atomic.AddInt64(&p.running, 1)
+	start := time.Now()
+	err := p.worker.Execute(j.task)
+	atomic.AddInt64(&p.running, -1)
+	atomic.AddUint64(&p.executed, 1)
+	if err != nil {
+		atomic.AddUint64(&p.failed, 1)
+	}
+	j.span.SetError(err)
+	j.span.End()
+	p.logger.Debug("Task executed", logging.Fields{"duration": time.Since(start), "error": err, "message_id": j.fields["message_id"], "metric": j.fields["metric"], "username": j.fields["username"]})
+	j.out <- taskResult{workerID: p.id, err: err}
+	j.done()
+}
+
+//resize sets the number of goroutines executing tasks. Removed goroutines finish their current task first,
+//resize waits for them without holding the lock so tasks are still queued meanwhile
+func (p *pool) resize(size int) {
+	if size < 1 {
+		size = 1
+	}
+	p.mu.Lock()
+	if p.stopped {
+		p.mu.Unlock()
+		return
+	}
+	for ; p.size < size; p.size++ {
+		p.wg.Add(1)
+		go p.run()
+	}
+	removed := p.size - size
+	if removed > 0 {
+		p.size = size
+	}
+	p.mu.Unlock()
+	for ; removed > 0; removed-- {
+		select {
+		case p.quit <- struct{}{}:
+		case <-p.stopping:
+			//every goroutine stops once the queue is drained
+			return
+		}
+	}
+}
+
+//submit queues a job, it blocks while the queue is full. It returns false if the pool was stopped
+func (p *pool) submit(j job) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.stopped {
+		return false
+	}
+	p.queue <- j
+	return true
+}
+
+//stop waits for the queued jobs to finish and stops the pool goroutines
+func (p *pool) stop() {
+	p.mu.Lock()
+	if !p.stopped {
+		p.stopped = true
+		close(p.queue)
+		close(p.stopping)
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+//status returns the pool size and counters
+func (p *pool) status() (size, queued int, running int64, executed, failed uint64) {
+	p.mu.RLock()
+	size = p.size
+	p.mu.RUnlock()
+	return size, len(p.queue), atomic.LoadInt64(&p.running), atomic.LoadUint64(&p.executed), atomic.LoadUint64(&p.failed)
+}
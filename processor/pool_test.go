@@ -0,0 +1,108 @@
+package processor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ottogiron/metricsworker/logging"
+	"github.com/ottogiron/metricsworker/worker"
+)
+
+func Test_pool_resize(t *testing.T) {
+	tests := []struct {
+		name string
+		from int
+		to   int
+	}{
+		{"Grow", 1, 3},
+		{"Shrink while every goroutine is busy", 3, 1},
+		{"Minimum size", 2, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			started := make(chan struct{}, tt.from)
+			p := newPool("blocking", worker.Func(func(task interface{}) error {
+				started <- struct{}{}
+				<-release
+				return nil
+			}), tt.from, tt.from, logging.Nop())
+			out := make(chan taskResult, 2*tt.from)
+			for i := 0; i < tt.from; i++ {
+				p.submit(job{out: out, done: func() {}})
+				<-started
+			}
+
+			resized := make(chan struct{})
+			go func() {
+				p.resize(tt.to)
+				close(resized)
+			}()
+			want := tt.to
+			if want < 1 {
+				want = 1
+			}
+			deadline := time.Now().Add(time.Second)
+			for size, _, _, _, _ := p.status(); size != want; size, _, _, _, _ = p.status() {
+				if time.Now().After(deadline) {
+					t.Fatalf("pool.status() size = %d want %d", size, want)
+				}
+				time.Sleep(time.Millisecond)
+			}
+			//the pool is not locked while the removed goroutines finish their tasks
+			queued := make(chan bool)
+			go func() {
+				queued <- p.submit(job{out: out, done: func() {}})
+			}()
+			select {
+			case ok := <-queued:
+				if !ok {
+					t.Fatal("pool.submit() = false want the job queued")
+				}
+			case <-time.After(time.Second):
+				t.Fatal("pool.submit() blocked while the pool resized")
+			}
+
+			close(release)
+			select {
+			case <-resized:
+			case <-time.After(time.Second):
+				t.Fatal("pool.resize() did not return once the tasks finished")
+			}
+			p.stop()
+			if got := len(out); got != tt.from+1 {
+				t.Errorf("pool executed %d tasks want %d", got, tt.from+1)
+			}
+		})
+	}
+}
+
+func Test_pool_resize_Stopped(t *testing.T) {
+	release := make(chan struct{})
+	p := newPool("blocking", worker.Func(func(task interface{}) error {
+		<-release
+		return nil
+	}), 2, 2, logging.Nop())
+	out := make(chan taskResult, 2)
+	p.submit(job{out: out, done: func() {}})
+	p.submit(job{out: out, done: func() {}})
+
+	resized := make(chan struct{})
+	go func() {
+		p.resize(1)
+		close(resized)
+	}()
+	stopped := make(chan struct{})
+	go func() {
+		p.stop()
+		close(stopped)
+	}()
+	close(release)
+	for _, done := range []chan struct{}{resized, stopped} {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("pool.resize() and pool.stop() should return once the tasks finished")
+		}
+	}
+}
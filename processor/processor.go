@@ -6,46 +6,127 @@ import (
 	"sync"
 	"time"
 
-	"log"
-
 	"os"
+	"sort"
 
 	fworkerprocessor "github.com/ferrariframework/ferrariworker/processor"
+	"github.com/ottogiron/metricsworker/logging"
+	"github.com/ottogiron/metricsworker/tracing"
 	"github.com/ottogiron/metricsworker/worker"
 )
 
 //Processor represents a tasks processor. It passes tasks to workers to execute business logic
+//Workers can be registered, unregistered, paused and resumed while the processor is running, changes take effect
+//on the next message
 type Processor interface {
-	Register(id string, worker worker.Worker)
+	Register(id string, worker worker.Worker, options ...RegistrationOption) error
+	Unregister(id string) error
+	Pause(id string) error
+	Resume(id string) error
+	Resize(id string, size int) error
+	Workers() []WorkerStatus
+	InFlight() []InFlightMessage
+	Drain()
+	Update(settings Settings) error
 	Start() error
 }
 
+//Adapter defines the source of the messages consumed by the processor. Every ferrariworker adapter satisfies it
+type Adapter interface {
+	Open() error
+	Messages(ctx context.Context) (<-chan fworkerprocessor.Message, error)
+	Close() error
+}
+
+//Flusher is implemented by adapters holding messages which can't be redelivered once they are closed, e.g. metrics
+//received through datagrams. Once the processor stops reading messages it flushes the adapters, which stop
+//receiving messages and return the ones they hold, and processes them before closing the adapters
+type Flusher interface {
+	Flush() []fworkerprocessor.Message
+}
+
+//ErrorReporter is implemented by adapters which close their messages channel when they fail to read the messages,
+//e.g. a file which can't be read to the end. Once the processor stopped reading messages, Start returns the error of
+//the adapters so the failure is not taken for the end of the messages
+type ErrorReporter interface {
+	Err() error
+}
+
 type taskResult struct {
 	err      error
 	workerID string
+	//fields identifying the task for logging
+	fields logging.Fields
 }
 
 var _ Processor = (*processor)(nil)
 
 type processor struct {
-	adapter fworkerprocessor.Adapter
+	adapter Adapter
 	//Number of registered workers running concurrently
 	concurrency int
 	//Time the processor will wait until new tasks are available
-	waitTimeout    time.Duration
+	waitTimeout time.Duration
+	//Additional adapters whose messages are processed along with the adapter ones
+	sources []Adapter
+	//Maximum number of messages being processed at the same time
+	maxInFlight int
+	//Message field messages are partitioned by, messages are dispatched unordered if it is empty
+	partitionKey string
+	//mu guards the registered workers, their pools, whether they are paused and the updatable settings
+	mu             sync.RWMutex
 	workerRegistry map[string]worker.Worker
-	logger         *log.Logger
+	registrations  map[string]*registration
+	//Worker pools by worker id, they run while the processor is started
+	pools  map[string]*pool
+	paused map[string]bool
+	//Rate limited workers of the pools by worker id, their limiters are replaced by Update
+	limiters map[string]*rateLimitedWorker
+	//Worker ids by metric name, metrics without a route are processed by every active worker
+	routes map[string][]string
+	//Transforms the tasks before they are passed to the workers
+	transformer Transformer
+	//Whether rabbit deliveries are acknowledged once they are processed, see SetManualAck
+	manualAck bool
+	//Stores the tasks workers gave up on, see SetDeadLetter
+	deadLetterer DeadLetterer
+	//lifecycleMu serializes the registration changes with starting and stopping the processor
+	lifecycleMu   sync.Mutex
+	running       bool
+	breakerConfig *BreakerConfig
+	rateLimit     *RateLimitConfig
+	seenStore     worker.SeenStore
+	contentDedup  bool
+	middlewares   []worker.Middleware
+	retry         *retryConfig
+	hooks         map[EventType][]Hook
+	//Messages being processed
+	inFlightMu       sync.Mutex
+	inFlightMessages map[uint64]InFlightMessage
+	inFlightSeq      uint64
+	//drain is closed to stop reading messages
+	drain     chan struct{}
+	drainOnce sync.Once
+	//Time workers are given to initialize and to close
+	lifecycleTimeout time.Duration
+	logger           logging.Logger
 }
 
 //New returns a new instance of a processor
-func New(adapter fworkerprocessor.Adapter, options ...Option) Processor {
+func New(adapter Adapter, options ...Option) Processor {
 	//Initialize and set defaults
 	p := &processor{
-		concurrency:    1,
-		waitTimeout:    500,
-		adapter:        adapter,
-		workerRegistry: make(map[string]worker.Worker),
-		logger:         log.New(os.Stdout, "", 0),
+		concurrency:      1,
+		waitTimeout:      500,
+		maxInFlight:      100,
+		lifecycleTimeout: DefaultLifecycleTimeout,
+		adapter:          adapter,
+		workerRegistry:   make(map[string]worker.Worker),
+		registrations:    make(map[string]*registration),
+		pools:            make(map[string]*pool),
+		limiters:         make(map[string]*rateLimitedWorker),
+		drain:            make(chan struct{}),
+		logger:           logging.New(os.Stdout, logging.LevelInfo, logging.FormatLogfmt),
 	}
 
 	//Apply user defined options
@@ -56,74 +137,502 @@ func New(adapter fworkerprocessor.Adapter, options ...Option) Processor {
 }
 
 //Start starts the task processor
-func (p *processor) Start() error {
-	//open the connection
-	err := p.adapter.Open()
+func (p *processor) Start() (err error) {
+	defer func() {
+		p.emit(Event{Type: EventShutdown, Err: err})
+	}()
+	//Workers are initialized before consuming any message so connectivity problems fail fast
+	err = p.startWorkers()
 	if err != nil {
-		return fmt.Errorf("Failed to open the processor Adapter connection %s", err)
+		return err
+	}
+	defer p.stopWorkers()
+
+	adapters := append([]Adapter{p.adapter}, p.sources...)
+	//open the connections
+	for _, adapter := range adapters {
+		err := adapter.Open()
+		if err != nil {
+			return fmt.Errorf("Failed to open the processor Adapter connection %s", err)
+		}
+		defer adapter.Close()
 	}
-	defer p.adapter.Close()
-	wg := sync.WaitGroup{}
-	//Wait for the timeout once then call done to exit the processing
-	wg.Add(p.concurrency)
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	msgs, err := p.adapter.Messages(ctx)
+	msgs, held, err := messages(ctx, adapters)
 	if err != nil {
-		return fmt.Errorf("Failed to get messages from adapter %s", err)
+		return err
+	}
+
+	//Bounds the messages being processed, once it is full no more messages are read from the adapters
+	inFlight := make(chan struct{}, p.maxInFlight)
+	if p.partitionKey != "" {
+		p.dispatchPartitioned(msgs, inFlight)
+	} else {
+		p.dispatch(msgs, inFlight)
+	}
+	cancel()
+	p.flush(adapters, held())
+	for _, adapter := range adapters {
+		if reporter, ok := adapter.(ErrorReporter); ok && reporter.Err() != nil {
+			return fmt.Errorf("Failed to read messages %s", reporter.Err())
+		}
+	}
+	return nil
+}
 
+//flush processes the messages read from the adapters but not dispatched and the messages of the Flusher adapters
+//once the processor stopped reading messages
+func (p *processor) flush(adapters []Adapter, held []fworkerprocessor.Message) {
+	pending := held
+	for _, adapter := range adapters {
+		if flusher, ok := adapter.(Flusher); ok {
+			pending = append(pending, flusher.Flush()...)
+		}
 	}
+	for _, m := range pending {
+		p.handle(m)
+	}
+}
+
+//startWorkers initializes the workers and starts their pools
+func (p *processor) startWorkers() error {
+	p.lifecycleMu.Lock()
+	defer p.lifecycleMu.Unlock()
+	err := p.initWorkers()
+	if err != nil {
+		return err
+	}
+	p.startPools()
+	p.running = true
+	return nil
+}
+
+//stopWorkers stops the worker pools once their queued tasks finish and closes the workers
+func (p *processor) stopWorkers() {
+	p.lifecycleMu.Lock()
+	defer p.lifecycleMu.Unlock()
+	p.running = false
+	p.stopPools()
+	p.closeWorkers()
+}
+
+//dispatch reads messages concurrently and processes them without waiting for the previous ones to finish.
+//It returns once the messages channel is closed or the wait timeout expires and every message was processed
+func (p *processor) dispatch(msgs <-chan fworkerprocessor.Message, inFlight chan struct{}) {
+	inFlightWg := sync.WaitGroup{}
+	wg := sync.WaitGroup{}
+	//Every goroutine waits for the timeout, it is reported once
+	idleOnce := sync.Once{}
+	//Wait for the timeout once then call done to exit the processing
+	wg.Add(p.concurrency)
 	for i := 0; i < p.concurrency; i++ {
 		go func() {
+			defer wg.Done()
 			for {
+				inFlight <- struct{}{}
 				select {
 				case m, ok := <-msgs:
-					if ok {
-						ids := make([]string, 0, len(p.workerRegistry))
-						for id := range p.workerRegistry {
-							ids = append(ids, id)
-						}
-						out := p.process(m.OriginalMessage, ids...)
-
-						for taskResult := range out {
-							if taskResult.err != nil {
-								p.logger.Printf("Error Failed to execute task for worker id: %s %s in first attempt retrying", taskResult.workerID, taskResult.err)
-							}
-						}
-
-					} else {
-						wg.Done()
+					if !ok {
+						<-inFlight
 						return
 					}
-				case <-time.After(p.waitTimeout * time.Millisecond):
-
-					wg.Done()
+					inFlightWg.Add(1)
+					go func() {
+						defer inFlightWg.Done()
+						p.handle(m)
+						<-inFlight
+					}()
+				case <-p.idle():
+					idleOnce.Do(func() { p.emit(Event{Type: EventIdleTimeout}) })
+					<-inFlight
+					return
+				case <-p.drain:
+					<-inFlight
 					return
 				}
 			}
 		}()
 	}
 	wg.Wait()
+	inFlightWg.Wait()
+}
+
+//handle processes a message in the active workers and waits for the results. Batched messages and messages of other
+//encodings than JSON are expanded in a JSON task for every metric, see expand, and the message is acknowledged once
+//for all of them
+func (p *processor) handle(m fworkerprocessor.Message) {
+	tasks, err := expand(m.OriginalMessage)
+	p.handleExpanded(m, p.transform(tasks), err)
+}
+
+//handleExpanded processes the transformed tasks a message was expanded in, or fails the message with the error
+//expanding it. The message span continues the trace propagated in the message headers
+func (p *processor) handleExpanded(m fworkerprocessor.Message, tasks []transformedTask, err error) {
+	ctx, span := tracing.Start(tracing.ContextFromTask(m.OriginalMessage), "process")
+	defer span.End()
+	fields := logging.Fields(worker.TaskFields(m.OriginalMessage))
+	defer p.track(m, fields)()
+	p.emit(Event{Type: EventMessageReceived, Task: m.OriginalMessage, Fields: fields})
+	for k, v := range fields {
+		span.SetAttribute(k, v)
+	}
+	key, state := p.claim(m)
+	switch state {
+	case worker.SeenProcessed:
+		span.SetAttribute("duplicate", true)
+		p.acknowledge(m.OriginalMessage, fields, nil)
+		return
+	case worker.SeenProcessing:
+		span.SetAttribute("duplicate", true)
+		p.acknowledge(m.OriginalMessage, fields, ErrDuplicateInFlight)
+		return
+	}
+	//the workers receiving the original message get it before it is expanded and transformed
+	original := p.processTask(ctx, m.OriginalMessage, fields, p.originalIDs()...)
+	if err != nil {
+		span.SetError(err)
+		p.logger.With(fields).Error("Failed to decode message", logging.Fields{"error": err})
+		p.waitOriginal(original, m.OriginalMessage, fields)
+		p.markProcessed(key, false)
+		p.acknowledge(m.OriginalMessage, fields, err)
+		return
+	}
+	if len(tasks) > 1 {
+		span.SetAttribute("items", len(tasks))
+	}
+	type item struct {
+		task   interface{}
+		fields logging.Fields
+		out    <-chan taskResult
+	}
+	items := make([]item, 0, len(tasks))
+	batchErr := &batchError{total: len(tasks)}
+	//fail records the error of a task, the message error is the first one
+	fail := func(err error) {
+		span.SetError(err)
+		if batchErr.err == nil {
+			batchErr.err = err
+		}
+	}
+	for i, t := range tasks {
+		taskFields := fields
+		if len(tasks) > 1 {
+			taskFields = itemFields(t.original, i, len(tasks))
+		}
+		if t.err != nil {
+			fail(t.err)
+			batchErr.failed++
+			p.logger.With(taskFields).Error("Failed to transform task", logging.Fields{"error": t.err})
+			continue
+		}
+		task := t.task
+		if t.transformed {
+			//the transformed task identifies the metric workers are routed by
+			taskFields = itemFields(task, i, len(tasks))
+		}
+		metric, _ := taskFields["metric"].(string)
+		items = append(items, item{task: task, fields: taskFields, out: p.processTask(ctx, task, taskFields, p.activeIDs(metric)...)})
+	}
+	for _, item := range items {
+		failed := false
+		for taskResult := range item.out {
+			if taskResult.err != nil && !p.deadLetter(&taskResult, item.task, item.fields) {
+				failed = true
+				fail(taskResult.err)
+			}
+		}
+		if failed {
+			batchErr.failed++
+		}
+	}
+	originalErr := p.waitOriginal(original, m.OriginalMessage, fields)
+	if batchErr.failed > 0 {
+		err = batchErr
+	} else if originalErr != nil {
+		span.SetError(originalErr)
+		err = originalErr
+	}
+	p.markProcessed(key, err == nil)
+	p.acknowledge(m.OriginalMessage, fields, err)
+}
+
+//waitOriginal waits for the workers which received the original message and returns the first error which was not
+//dead-lettered
+func (p *processor) waitOriginal(out <-chan taskResult, task interface{}, fields logging.Fields) error {
+	var err error
+	for taskResult := range out {
+		if taskResult.err == nil || p.deadLetter(&taskResult, task, fields) {
+			continue
+		}
+		if err == nil {
+			err = taskResult.err
+		}
+	}
+	return err
+}
+
+//activeIDs returns the ids of the registered workers which are not paused and the metric is routed to.
+//Workers receiving the original messages are not included, see originalIDs
+func (p *processor) activeIDs(metric string) []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if routed, ok := p.routes[metric]; ok {
+		ids := make([]string, 0, len(routed))
+		for _, id := range routed {
+			if _, ok := p.workerRegistry[id]; ok && !p.paused[id] && !p.receivesOriginal(id) {
+				ids = append(ids, id)
+			}
+		}
+		return ids
+	}
+	ids := make([]string, 0, len(p.workerRegistry))
+	for id := range p.workerRegistry {
+		if !p.paused[id] && !p.receivesOriginal(id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+//originalIDs returns the ids of the registered workers which are not paused and receive the original messages,
+//see ReceiveOriginal
+func (p *processor) originalIDs() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var ids []string
+	for id := range p.workerRegistry {
+		if !p.paused[id] && p.receivesOriginal(id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+//receivesOriginal returns whether a worker was registered with ReceiveOriginal, p.mu should be held
+func (p *processor) receivesOriginal(id string) bool {
+	r, ok := p.registrations[id]
+	return ok && r.original
+}
+
+//startPools starts a pool for every registered worker
+func (p *processor) startPools() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pools == nil {
+		p.pools = make(map[string]*pool)
+	}
+	for id, w := range p.workerRegistry {
+		p.pools[id] = p.newPool(id, w)
+	}
+}
+
+//newPool returns a pool executing a registered worker wrapped with the processor execution features
+func (p *processor) newPool(id string, w worker.Worker) *pool {
+	size, queueSize := p.concurrency, -1
+	if r, ok := p.registrations[id]; ok {
+		if r.poolSize > 0 {
+			size = r.poolSize
+		}
+		queueSize = r.queueSize
+	}
+	if queueSize < 0 {
+		queueSize = size
+	}
+	return newPool(id, p.wrap(id, w), size, queueSize, p.logger)
+}
+
+//wrap wraps a registered worker with the processor execution features
+func (p *processor) wrap(id string, w worker.Worker) worker.Worker {
+	if p.hooked(EventWorkerStarted, EventWorkerSucceeded, EventWorkerFailed) {
+		w = &hookedWorker{id: id, worker: w, p: p}
+	}
+	if r, ok := p.registrations[id]; ok {
+		w = worker.Chain(w, r.middlewares...)
+	}
+	if p.retry != nil && p.retry.attempts > 1 {
+		w = p.retryMiddleware(id)(w)
+	}
+	w = worker.Chain(w, p.middlewares...)
+	//Every worker is rate limited so its limits can be updated while it runs
+	limited := &rateLimitedWorker{worker: w, limiter: p.limiter(id)}
+	if p.limiters == nil {
+		p.limiters = make(map[string]*rateLimitedWorker)
+	}
+	p.limiters[id] = limited
+	w = limited
+	//The breaker wraps the rate limit so tasks are short-circuited without waiting for the limit while it is open
+	if p.breakerConfig != nil {
+		w = &breakerWorker{worker: w, breaker: newBreaker(*p.breakerConfig)}
+	}
+	return w
+}
+
+//stopPools waits for the queued tasks to finish and stops the pools
+func (p *processor) stopPools() {
+	p.mu.Lock()
+	pools := p.pools
+	p.pools = make(map[string]*pool)
+	p.mu.Unlock()
+	for _, pool := range pools {
+		pool.stop()
+	}
+}
+
+//lifecycleResources returns the registered workers and the processor resources which may implement worker.Lifecycle
+//by id, ordered by id
+func (p *processor) lifecycleResources() ([]string, map[string]interface{}) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	resources := make(map[string]interface{}, len(p.workerRegistry)+1)
+	ids := make([]string, 0, len(p.workerRegistry)+1)
+	for id, w := range p.workerRegistry {
+		resources[id] = w
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	if p.seenStore != nil {
+		resources[seenStoreID] = p.seenStore
+		ids = append(ids, seenStoreID)
+	}
+	return ids, resources
+}
+
+//initWorkers initializes the workers and the seen store implementing worker.Lifecycle.
+//If one fails the ones already initialized are closed
+func (p *processor) initWorkers() error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.lifecycleTimeout)
+	defer cancel()
+	ids, resources := p.lifecycleResources()
+	for i, id := range ids {
+		err := worker.Init(ctx, resources[id])
+		if err != nil {
+			for _, initialized := range ids[:i] {
+				worker.Close(ctx, resources[initialized])
+			}
+			return fmt.Errorf("Failed to initialize worker %s %s", id, err)
+		}
+	}
 	return nil
 }
 
+//closeWorkers closes the workers and the seen store implementing worker.Lifecycle
+func (p *processor) closeWorkers() {
+	ctx, cancel := context.WithTimeout(context.Background(), p.lifecycleTimeout)
+	defer cancel()
+	ids, resources := p.lifecycleResources()
+	for _, id := range ids {
+		err := worker.Close(ctx, resources[id])
+		if err != nil {
+			p.logger.Error("Failed to close worker", logging.Fields{"worker_id": id, "error": err})
+		}
+	}
+}
+
+//idle returns a channel notified once the wait timeout expires. It never expires if the wait timeout is not positive
+func (p *processor) idle() <-chan time.Time {
+	if p.waitTimeout <= 0 {
+		return nil
+	}
+	return time.After(p.waitTimeout * time.Millisecond)
+}
+
+//messages merges the messages of all the adapters in a single channel which is closed once every adapter channel is closed.
+//Once the context is done, held waits until the adapter channels are no longer read and returns the messages which were
+//read but not delivered
+func messages(ctx context.Context, adapters []Adapter) (msgs <-chan fworkerprocessor.Message, held func() []fworkerprocessor.Message, err error) {
+	if len(adapters) == 1 {
+		msgs, err := adapters[0].Messages(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to get messages from adapter %s", err)
+		}
+		return msgs, func() []fworkerprocessor.Message { return nil }, nil
+	}
+	out := make(chan fworkerprocessor.Message)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var undelivered []fworkerprocessor.Message
+	for _, adapter := range adapters {
+		msgs, err := adapter.Messages(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to get messages from adapter %s", err)
+		}
+		wg.Add(1)
+		go func(msgs <-chan fworkerprocessor.Message) {
+			defer wg.Done()
+			for {
+				select {
+				case m, ok := <-msgs:
+					if !ok {
+						return
+					}
+					select {
+					case out <- m:
+					case <-ctx.Done():
+						mu.Lock()
+						undelivered = append(undelivered, m)
+						mu.Unlock()
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(msgs)
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out, func() []fworkerprocessor.Message {
+		wg.Wait()
+		mu.Lock()
+		defer mu.Unlock()
+		return undelivered
+	}, nil
+}
+
+//handleFailedTask handles a task which failed or was short-circuited by an open circuit breaker
 func (p *processor) handleFailedTask(taskResult *taskResult) {
-	p.logger.Println("Handling failed task")
+	p.logger.With(taskResult.fields).Error("Failed to execute task", logging.Fields{"worker_id": taskResult.workerID, "error": taskResult.err})
 }
 
-//Process will process a task in all the available workers asynchronously
+//Process will process a task in all the available workers asynchronously.
+//Tasks are queued to the worker pools while the processor is started, otherwise every worker runs in its own goroutine
 func (p *processor) process(task interface{}, workersIDS ...string) <-chan taskResult {
-	out := make(chan taskResult)
+	return p.processTask(context.Background(), task, nil, workersIDS...)
+}
+
+//processTask processes a task like process, fields identify the task in the logs.
+//Every worker execution runs in a child span of the span in ctx, which is propagated to the worker in the task headers
+func (p *processor) processTask(ctx context.Context, task interface{}, fields logging.Fields, workersIDS ...string) <-chan taskResult {
+	out := make(chan taskResult, len(workersIDS))
 	var wg sync.WaitGroup
 	wg.Add(len(workersIDS))
 	for _, id := range workersIDS {
-		w := p.workerRegistry[id]
-		go func(w worker.Worker, workerID string) {
-			err := w.Execute(task)
+		p.mu.RLock()
+		pool, w := p.pools[id], p.workerRegistry[id]
+		p.mu.RUnlock()
+		_, span := tracing.Start(ctx, "worker.Execute")
+		span.SetAttribute("worker_id", id)
+		spanTask := tracing.WithSpanContext(task, span.SpanContext())
+		if pool != nil && pool.submit(job{task: spanTask, fields: fields, span: span, out: out, done: wg.Done}) {
+			continue
+		}
+		if pool != nil || w == nil {
+			//the worker was unregistered since the message was received
+			span.End()
+			wg.Done()
+			continue
+		}
+		go func(w worker.Worker, workerID string, span *tracing.Span) {
+			err := w.Execute(spanTask)
+			span.SetError(err)
+			span.End()
 			out <- taskResult{workerID: workerID, err: err}
 			wg.Done()
-		}(w, id)
+		}(w, id, span)
 	}
 
 	go func() {
@@ -133,7 +642,104 @@ func (p *processor) process(task interface{}, workersIDS ...string) <-chan taskR
 	return out
 }
 
-//Register register a new worker to execute a task
-func (p *processor) Register(id string, worker worker.Worker) {
-	p.workerRegistry[id] = worker
+//Register register a new worker to execute a task. A worker registered with the id of another one replaces it.
+//While the processor is running the worker is initialized and its pool started before it receives messages.
+//Workers registered with SetPaused don't receive messages until they are resumed
+func (p *processor) Register(id string, w worker.Worker, options ...RegistrationOption) error {
+	r := &registration{queueSize: -1}
+	for _, option := range options {
+		option(r)
+	}
+	p.lifecycleMu.Lock()
+	defer p.lifecycleMu.Unlock()
+	if p.running {
+		ctx, cancel := context.WithTimeout(context.Background(), p.lifecycleTimeout)
+		err := worker.Init(ctx, w)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("Failed to initialize worker %s %s", id, err)
+		}
+	}
+	p.mu.Lock()
+	replaced, replacedPool := p.workerRegistry[id], p.pools[id]
+	p.workerRegistry[id] = w
+	p.registrations[id] = r
+	delete(p.paused, id)
+	if r.paused {
+		if p.paused == nil {
+			p.paused = make(map[string]bool)
+		}
+		p.paused[id] = true
+	}
+	if p.running {
+		p.pools[id] = p.newPool(id, w)
+	}
+	p.mu.Unlock()
+	if replaced != nil && p.running {
+		return p.stopWorker(id, replaced, replacedPool)
+	}
+	return nil
+}
+
+//Unregister unregisters a worker. While the processor is running the worker tasks already queued are executed
+//and the worker is closed
+func (p *processor) Unregister(id string) error {
+	p.lifecycleMu.Lock()
+	defer p.lifecycleMu.Unlock()
+	p.mu.Lock()
+	w, ok := p.workerRegistry[id]
+	if !ok {
+		p.mu.Unlock()
+		return fmt.Errorf("Unknown worker %s", id)
+	}
+	pool := p.pools[id]
+	delete(p.workerRegistry, id)
+	delete(p.registrations, id)
+	delete(p.pools, id)
+	delete(p.limiters, id)
+	delete(p.paused, id)
+	p.mu.Unlock()
+	if p.running {
+		return p.stopWorker(id, w, pool)
+	}
+	return nil
+}
+
+//stopWorker stops the pool of a worker which is no longer registered and closes it
+func (p *processor) stopWorker(id string, w worker.Worker, pool *pool) error {
+	if pool != nil {
+		pool.stop()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), p.lifecycleTimeout)
+	defer cancel()
+	err := worker.Close(ctx, w)
+	if err != nil {
+		return fmt.Errorf("Failed to close worker %s %s", id, err)
+	}
+	return nil
+}
+
+//Pause stops passing new messages to a worker until it is resumed
+func (p *processor) Pause(id string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.workerRegistry[id]; !ok {
+		return fmt.Errorf("Unknown worker %s", id)
+	}
+	if p.paused == nil {
+		p.paused = make(map[string]bool)
+	}
+	p.paused[id] = true
+	return nil
+}
+
+//Resume resumes passing messages to a paused worker
+func (p *processor) Resume(id string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.workerRegistry[id]; !ok {
+		return fmt.Errorf("Unknown worker %s", id)
+	}
+	delete(p.paused, id)
+	return nil
 }
@@ -1,18 +1,24 @@
 package processor
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"testing"
 	"time"
 
 	"reflect"
+	"strings"
+	"sync"
 
 	"io/ioutil"
 	"log"
 
 	fworkerprocessor "github.com/ferrariframework/ferrariworker/processor"
+	"github.com/ottogiron/metricsworker/logging"
+	"github.com/ottogiron/metricsworker/tracing"
 	"github.com/ottogiron/metricsworker/worker"
+	"github.com/streadway/amqp"
 )
 
 var successfullJobs = []fworkerprocessor.Message{
@@ -54,6 +60,16 @@ func (s *processorAdapterMock) Messages(context context.Context) (<-chan fworker
 	return s.handler(context)
 }
 
+//errorReporterAdapterMock adapter mock whose messages channel is closed by a read error
+type errorReporterAdapterMock struct {
+	processorAdapterMock
+	err error
+}
+
+func (s *errorReporterAdapterMock) Err() error {
+	return s.err
+}
+
 func mockMessagesHandler(messages []fworkerprocessor.Message) testMessagesHandler {
 	return func(context context.Context) (<-chan fworkerprocessor.Message, error) {
 		msgChannel := make(chan fworkerprocessor.Message)
@@ -135,6 +151,21 @@ func Test_processor_Start(t *testing.T) {
 			},
 			false,
 		},
+		{
+			"Adapter read error",
+			fields{
+				&errorReporterAdapterMock{
+					processorAdapterMock: processorAdapterMock{handler: mockMessagesHandler(successfullJobs)},
+					err:                  errors.New("token too long"),
+				},
+				1,
+				200,
+				map[string]worker.Worker{
+					"distincName": &mockWorker{err: nil},
+				},
+			},
+			true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -291,3 +322,235 @@ func Test_processor_Register(t *testing.T) {
 		})
 	}
 }
+
+func Test_processor_AddSource(t *testing.T) {
+	var mu sync.Mutex
+	processed := 0
+	p := New(
+		&processorAdapterMock{handler: mockMessagesHandler(successfullJobs)},
+		AddSource(&processorAdapterMock{handler: mockMessagesHandler(successfullJobs)}),
+		SetWaitTimeout(200),
+		SetLogger(log.New(ioutil.Discard, "", 0)),
+	)
+	p.Register("counter", &mockWorker{handler: func(task interface{}) {
+		mu.Lock()
+		processed++
+		mu.Unlock()
+	}})
+	if err := p.Start(); err != nil {
+		t.Fatalf("processor.Start() error = %v", err)
+	}
+	if want := 2 * len(successfullJobs); processed != want {
+		t.Errorf("processor.Start() processed = %d want %d", processed, want)
+	}
+}
+
+//flusherAdapterMock holds messages until it is flushed
+type flusherAdapterMock struct {
+	processorAdapterMock
+	held    []fworkerprocessor.Message
+	flushed bool
+	closed  bool
+}
+
+func (s *flusherAdapterMock) Flush() []fworkerprocessor.Message {
+	if s.closed {
+		return nil
+	}
+	s.flushed = true
+	return s.held
+}
+
+func (s *flusherAdapterMock) Close() error {
+	s.closed = true
+	return nil
+}
+
+func Test_processor_Start_Flush(t *testing.T) {
+	var mu sync.Mutex
+	processed := 0
+	idle := func(context context.Context) (<-chan fworkerprocessor.Message, error) {
+		return make(chan fworkerprocessor.Message), nil
+	}
+	flusher := &flusherAdapterMock{processorAdapterMock: processorAdapterMock{handler: idle}, held: successfullJobs}
+	p := New(
+		&processorAdapterMock{handler: mockMessagesHandler(successfullJobs)},
+		AddSource(flusher),
+		SetWaitTimeout(100),
+		SetLogger(log.New(ioutil.Discard, "", 0)),
+	)
+	p.Register("counter", &mockWorker{handler: func(task interface{}) {
+		mu.Lock()
+		processed++
+		mu.Unlock()
+	}})
+	if err := p.Start(); err != nil {
+		t.Fatalf("processor.Start() error = %v", err)
+	}
+	if !flusher.flushed || !flusher.closed {
+		t.Errorf("processor.Start() flushed = %v closed = %v want the adapter flushed before it is closed", flusher.flushed, flusher.closed)
+	}
+	if want := 2 * len(successfullJobs); processed != want {
+		t.Errorf("processor.Start() processed = %d want %d", processed, want)
+	}
+}
+
+func Test_processor_Start_WorkerPools(t *testing.T) {
+	var mu sync.Mutex
+	var fastDone, slowDone time.Time
+	p := New(
+		&processorAdapterMock{handler: mockMessagesHandler(successfullJobs)},
+		SetWaitTimeout(100),
+		SetLogger(log.New(ioutil.Discard, "", 0)),
+	)
+	p.Register("slow", &mockWorker{handler: func(task interface{}) {
+		time.Sleep(time.Millisecond * 30)
+		mu.Lock()
+		slowDone = time.Now()
+		mu.Unlock()
+	}}, SetPoolSize(1), SetQueueSize(len(successfullJobs)))
+	p.Register("fast", &mockWorker{handler: func(task interface{}) {
+		mu.Lock()
+		fastDone = time.Now()
+		mu.Unlock()
+	}})
+	if err := p.Start(); err != nil {
+		t.Fatalf("processor.Start() error = %v", err)
+	}
+	//The fast worker should not wait for the slow worker to process every message
+	if !fastDone.Before(slowDone.Add(-time.Millisecond * 60)) {
+		t.Errorf("processor.Start() fast worker finished at %s, slow worker finished at %s", fastDone, slowDone)
+	}
+}
+
+func Test_processor_Start_MaxInFlight(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	p := New(
+		&processorAdapterMock{handler: mockMessagesHandler(successfullJobs)},
+		SetConcurrency(3),
+		SetMaxInFlight(1),
+		SetWaitTimeout(100),
+		SetLogger(log.New(ioutil.Discard, "", 0)),
+	)
+	p.Register("counter", &mockWorker{handler: func(task interface{}) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(time.Millisecond * 5)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}})
+	if err := p.Start(); err != nil {
+		t.Fatalf("processor.Start() error = %v", err)
+	}
+	if maxInFlight != 1 {
+		t.Errorf("processor.Start() max in flight = %d want 1", maxInFlight)
+	}
+}
+
+func Test_processor_Start_Middlewares(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	tracing := func(name string) worker.Middleware {
+		return func(next worker.Worker) worker.Worker {
+			return worker.Func(func(task interface{}) error {
+				mu.Lock()
+				calls = append(calls, name)
+				mu.Unlock()
+				return next.Execute(task)
+			})
+		}
+	}
+	p := New(
+		&processorAdapterMock{handler: mockMessagesHandler(successfullJobs[:1])},
+		AddMiddleware(tracing("global")),
+		SetWaitTimeout(100),
+		SetLogger(log.New(ioutil.Discard, "", 0)),
+	)
+	p.Register("traced", &mockWorker{handler: func(task interface{}) {
+		mu.Lock()
+		calls = append(calls, "worker")
+		mu.Unlock()
+	}}, AddWorkerMiddleware(tracing("registration")))
+	if err := p.Start(); err != nil {
+		t.Fatalf("processor.Start() error = %v", err)
+	}
+	if want := []string{"global", "registration", "worker"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("processor.Start() calls = %v want %v", calls, want)
+	}
+}
+
+func Test_processor_Start_StructuredLogger(t *testing.T) {
+	var buf bytes.Buffer
+	delivery := amqp.Delivery{MessageId: "1", Body: []byte(`{"username": "kodingbot", "count": 1, "metric": "kite_call"}`)}
+	p := New(
+		&processorAdapterMock{handler: mockMessagesHandler([]fworkerprocessor.Message{{Payload: delivery.Body, OriginalMessage: delivery}})},
+		SetWaitTimeout(100),
+		SetStructuredLogger(logging.New(&buf, logging.LevelDebug, logging.FormatJSON)),
+	)
+	p.Register("failing", &mockWorker{err: errors.New("failure")})
+	if err := p.Start(); err != nil {
+		t.Fatalf("processor.Start() error = %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		`"level":"debug","msg":"Task executed"`,
+		`"level":"error","msg":"Failed to execute task","error":"failure","message_id":"1","metric":"kite_call","username":"kodingbot","worker_id":"failing"`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("processor.Start() logs = %s want %s", out, want)
+		}
+	}
+}
+
+type recordingExporter struct {
+	mu    sync.Mutex
+	spans []tracing.SpanData
+}
+
+func (e *recordingExporter) Export(span tracing.SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, span)
+	return nil
+}
+
+func Test_processor_Start_Tracing(t *testing.T) {
+	exporter := &recordingExporter{}
+	tracing.SetTracer(tracing.NewTracer(exporter))
+	defer tracing.SetTracer(nil)
+
+	parent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	delivery := amqp.Delivery{Headers: amqp.Table{tracing.TraceparentHeader: parent}, Body: []byte(`{"username": "kodingbot", "count": 1, "metric": "kite_call"}`)}
+	var received tracing.SpanContext
+	p := New(
+		&processorAdapterMock{handler: mockMessagesHandler([]fworkerprocessor.Message{{Payload: delivery.Body, OriginalMessage: delivery}})},
+		SetWaitTimeout(100),
+		SetLogger(log.New(ioutil.Discard, "", 0)),
+	)
+	p.Register("traced", &mockWorker{handler: func(task interface{}) {
+		received = tracing.Extract(task.(amqp.Delivery).Headers)
+	}})
+	if err := p.Start(); err != nil {
+		t.Fatalf("processor.Start() error = %v", err)
+	}
+
+	if len(exporter.spans) != 2 {
+		t.Fatalf("processor.Start() exported %d spans want 2", len(exporter.spans))
+	}
+	workerSpan, messageSpan := exporter.spans[0], exporter.spans[1]
+	if messageSpan.Name != "process" || messageSpan.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || messageSpan.ParentSpanID.String() != "00f067aa0ba902b7" {
+		t.Errorf("processor.Start() message span = %+v want a child of %s", messageSpan, parent)
+	}
+	if workerSpan.Name != "worker.Execute" || *workerSpan.ParentSpanID != messageSpan.SpanID || workerSpan.Attributes["worker_id"] != "traced" {
+		t.Errorf("processor.Start() worker span = %+v want a child of %+v", workerSpan, messageSpan)
+	}
+	if received.SpanID != workerSpan.SpanID {
+		t.Errorf("processor.Start() worker received span %s want %s", received.SpanID, workerSpan.SpanID)
+	}
+}
@@ -0,0 +1,166 @@
+package processor
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/ottogiron/metricsworker/worker"
+	"github.com/streadway/amqp"
+)
+
+//RateLimit token bucket limit
+type RateLimit struct {
+	//Executions per second, 0 is unlimited
+	Rate float64
+	//Executions allowed at once above the rate. It defaults to 1
+	Burst int
+}
+
+//RateLimitConfig rate limits of a worker
+type RateLimitConfig struct {
+	//Limit of all the worker executions
+	Global RateLimit
+	//Limits of the executions by metric name, they apply along with the global limit
+	Metrics map[string]RateLimit
+}
+
+//SetRateLimit limits the executions of every registered worker. Each worker has its own buckets.
+//Executions over the limit wait, which slows down the consumption of messages
+func SetRateLimit(config RateLimitConfig) Option {
+	return func(p *processor) {
+		p.rateLimit = &config
+	}
+}
+
+//SetWorkerRateLimit limits the executions of the registered worker, it overrides the processor rate limit
+func SetWorkerRateLimit(config RateLimitConfig) RegistrationOption {
+	return func(r *registration) {
+		r.rateLimit = &config
+	}
+}
+
+//bucket token bucket
+type bucket struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+	sleep func(time.Duration)
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(limit RateLimit) *bucket {
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	return &bucket{
+		rate:   limit.Rate,
+		burst:  burst,
+		now:    time.Now,
+		sleep:  time.Sleep,
+		tokens: burst,
+	}
+}
+
+//wait takes a token, waiting until it is available. Waiting callers reserve their token so they are served in order
+func (b *bucket) wait() {
+	b.mu.Lock()
+	now := b.now()
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+	b.tokens--
+	deficit := -b.tokens
+	b.mu.Unlock()
+	if deficit > 0 {
+		b.sleep(time.Duration(deficit / b.rate * float64(time.Second)))
+	}
+}
+
+//limiter rate limits the executions of a worker
+type limiter struct {
+	global *bucket
+	//metric buckets are created on start and never modified, so they are read without locking
+	metrics map[string]*bucket
+}
+
+func newLimiter(config RateLimitConfig) *limiter {
+	l := &limiter{metrics: make(map[string]*bucket)}
+	if config.Global.Rate > 0 {
+		l.global = newBucket(config.Global)
+	}
+	for metric, limit := range config.Metrics {
+		if limit.Rate > 0 {
+			l.metrics[metric] = newBucket(limit)
+		}
+	}
+	return l
+}
+
+func (l *limiter) wait(metric string) {
+	if b, ok := l.metrics[metric]; ok {
+		b.wait()
+	}
+	if l.global != nil {
+		l.global.wait()
+	}
+}
+
+var _ worker.Worker = (*rateLimitedWorker)(nil)
+
+//rateLimitedWorker waits for the worker rate limits before executing it. A nil limiter doesn't limit the executions,
+//the limiter is replaced when the processor settings are updated
+type rateLimitedWorker struct {
+	worker  worker.Worker
+	mu      sync.RWMutex
+	limiter *limiter
+}
+
+func (w *rateLimitedWorker) Execute(task interface{}) error {
+	w.mu.RLock()
+	l := w.limiter
+	w.mu.RUnlock()
+	if l != nil {
+		metric := ""
+		if len(l.metrics) > 0 {
+			metric = metricName(task)
+		}
+		l.wait(metric)
+	}
+	return w.worker.Execute(task)
+}
+
+//setLimiter replaces the limiter, executions already waiting for the previous one are not affected
+func (w *rateLimitedWorker) setLimiter(l *limiter) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.limiter = l
+}
+
+//metricName returns the metric name of a task, or an empty string if the task is not a metric
+func metricName(task interface{}) string {
+	var body []byte
+	switch t := task.(type) {
+	case amqp.Delivery:
+		body = t.Body
+	case []byte:
+		body = t
+	default:
+		return ""
+	}
+	var metric struct {
+		Metric string `json:"metric"`
+	}
+	if err := json.Unmarshal(body, &metric); err != nil {
+		return ""
+	}
+	return metric.Metric
+}
@@ -0,0 +1,94 @@
+package processor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+func Test_bucket_wait(t *testing.T) {
+	now := time.Now()
+	var slept time.Duration
+	b := newBucket(RateLimit{Rate: 10, Burst: 2})
+	b.now = func() time.Time {
+		return now
+	}
+	b.sleep = func(d time.Duration) {
+		slept += d
+	}
+
+	//The burst is available right away
+	b.wait()
+	b.wait()
+	if slept != 0 {
+		t.Errorf("bucket.wait() slept = %s want 0", slept)
+	}
+	//Next tokens wait for the rate
+	b.wait()
+	b.wait()
+	if want := 300 * time.Millisecond; slept < want-time.Millisecond || slept > want+time.Millisecond {
+		t.Errorf("bucket.wait() slept = %s want %s", slept, want)
+	}
+	//Tokens are refilled over time up to the burst
+	slept = 0
+	now = now.Add(time.Hour)
+	b.wait()
+	b.wait()
+	if slept != 0 {
+		t.Errorf("bucket.wait() slept = %s want 0 after refill", slept)
+	}
+}
+
+func Test_rateLimitedWorker_Execute(t *testing.T) {
+	executed := 0
+	w := &rateLimitedWorker{
+		worker: &mockWorker{handler: func(task interface{}) {
+			executed++
+		}},
+		limiter: newLimiter(RateLimitConfig{
+			Metrics: map[string]RateLimit{"slow_metric": {Rate: 20}},
+		}),
+	}
+	slow := amqp.Delivery{Body: []byte(`{"username": "kodingbot", "count": 1, "metric": "slow_metric"}`)}
+	fast := amqp.Delivery{Body: []byte(`{"username": "kodingbot", "count": 1, "metric": "fast_metric"}`)}
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		w.Execute(fast)
+	}
+	if elapsed := time.Since(start); elapsed > 20*time.Millisecond {
+		t.Errorf("rateLimitedWorker.Execute() unlimited metric took %s", elapsed)
+	}
+	start = time.Now()
+	for i := 0; i < 3; i++ {
+		w.Execute(slow)
+	}
+	//The first execution uses the burst, the next two wait 50ms each
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("rateLimitedWorker.Execute() limited metric took %s want at least 100ms", elapsed)
+	}
+	if executed != 13 {
+		t.Errorf("rateLimitedWorker.Execute() executed = %d want 13", executed)
+	}
+}
+
+func Test_metricName(t *testing.T) {
+	tests := []struct {
+		name string
+		task interface{}
+		want string
+	}{
+		{"Rabbit delivery", amqp.Delivery{Body: []byte(`{"metric": "kite_call"}`)}, "kite_call"},
+		{"Bytes", []byte(`{"metric": "kite_call"}`), "kite_call"},
+		{"Invalid body", []byte(`{"metric": `), ""},
+		{"Unknown task", "kite_call", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := metricName(tt.task); got != tt.want {
+				t.Errorf("metricName() = %s want %s", got, tt.want)
+			}
+		})
+	}
+}
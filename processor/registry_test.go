@@ -0,0 +1,143 @@
+package processor
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	fworkerprocessor "github.com/ferrariframework/ferrariworker/processor"
+)
+
+//recordingWorker records the tasks it executes and its lifecycle calls
+type recordingWorker struct {
+	mu     sync.Mutex
+	tasks  []string
+	inits  int
+	closes int
+	//executed if set is notified after every execution
+	executed chan struct{}
+}
+
+func (w *recordingWorker) Init(ctx context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.inits++
+	return nil
+}
+
+func (w *recordingWorker) Close(ctx context.Context) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closes++
+	return nil
+}
+
+func (w *recordingWorker) Execute(task interface{}) error {
+	w.mu.Lock()
+	w.tasks = append(w.tasks, task.(string))
+	w.mu.Unlock()
+	if w.executed != nil {
+		w.executed <- struct{}{}
+	}
+	return nil
+}
+
+func (w *recordingWorker) state() ([]string, int, int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	tasks := append([]string(nil), w.tasks...)
+	sort.Strings(tasks)
+	return tasks, w.inits, w.closes
+}
+
+func Test_processor_RuntimeRegistration(t *testing.T) {
+	feed := make(chan fworkerprocessor.Message)
+	p := New(
+		&processorAdapterMock{handler: func(ctx context.Context) (<-chan fworkerprocessor.Message, error) {
+			return feed, nil
+		}},
+		SetWaitTimeout(0),
+		SetLogger(log.New(ioutil.Discard, "", 0)),
+	)
+	//barrier is notified once every message was handed to the active workers
+	barrier := &recordingWorker{executed: make(chan struct{})}
+	a, b := &recordingWorker{}, &recordingWorker{}
+	p.Register("barrier", barrier)
+	p.Register("a", a)
+
+	done := make(chan error)
+	go func() {
+		done <- p.Start()
+	}()
+	send := func(task string) {
+		feed <- fworkerprocessor.Message{OriginalMessage: task}
+		<-barrier.executed
+	}
+
+	send("1")
+	if err := p.Pause("a"); err != nil {
+		t.Fatalf("processor.Pause() error = %v", err)
+	}
+	send("2")
+	if err := p.Resume("a"); err != nil {
+		t.Fatalf("processor.Resume() error = %v", err)
+	}
+	if err := p.Register("b", b); err != nil {
+		t.Fatalf("processor.Register() error = %v", err)
+	}
+	send("3")
+	if err := p.Unregister("a"); err != nil {
+		t.Fatalf("processor.Unregister() error = %v", err)
+	}
+	send("4")
+	close(feed)
+	if err := <-done; err != nil {
+		t.Fatalf("processor.Start() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		worker     *recordingWorker
+		wantTasks  []string
+		wantInits  int
+		wantCloses int
+	}{
+		{"Paused and unregistered", a, []string{"1", "3"}, 1, 1},
+		{"Registered while running", b, []string{"3", "4"}, 1, 1},
+		{"Always registered", barrier, []string{"1", "2", "3", "4"}, 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tasks, inits, closes := tt.worker.state()
+			if !reflect.DeepEqual(tasks, tt.wantTasks) {
+				t.Errorf("worker tasks = %v want %v", tasks, tt.wantTasks)
+			}
+			if inits != tt.wantInits || closes != tt.wantCloses {
+				t.Errorf("worker inits = %d closes = %d want %d %d", inits, closes, tt.wantInits, tt.wantCloses)
+			}
+		})
+	}
+}
+
+func Test_processor_UnknownWorker(t *testing.T) {
+	p := newTestProcessor(nil)
+	tests := []struct {
+		name string
+		call func(id string) error
+	}{
+		{"Unregister", p.Unregister},
+		{"Pause", p.Pause},
+		{"Resume", p.Resume},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call("unknown"); err == nil {
+				t.Errorf("processor.%s() error = nil want an unknown worker error", tt.name)
+			}
+		})
+	}
+}
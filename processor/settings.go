@@ -0,0 +1,133 @@
+package processor
+
+import "fmt"
+
+//Settings of the processor which can be updated while it runs
+type Settings struct {
+	//Rate limit of every worker, nil doesn't limit them
+	RateLimit *RateLimitConfig
+	//Rate limits by worker id, they override RateLimit
+	WorkerRateLimits map[string]RateLimitConfig
+	//Worker ids by metric name. Metrics without a route are processed by every active worker
+	Routes map[string][]string
+	//Transforms the tasks before they are passed to the workers, nil doesn't transform them
+	Transformer Transformer
+	//Ids of paused workers which are resumed, e.g. the workers registered with SetPaused so they receive messages
+	//once the settings they are routed and rate limited by are applied
+	Resume []string
+}
+
+//SetRoutes routes the metrics by name to the given worker ids. Metrics without a route are processed by every active worker
+func SetRoutes(routes map[string][]string) Option {
+	return func(p *processor) {
+		p.routes = copyRoutes(routes)
+	}
+}
+
+//Update replaces the rate limits, routes and transformer of the processor and resumes workers. Settings are validated first, invalid settings are rejected
+//and the current ones kept. Valid settings are applied at once, messages being processed are not affected
+func (p *processor) Update(settings Settings) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	err := p.validate(settings)
+	if err != nil {
+		return err
+	}
+	p.rateLimit = copyRateLimit(settings.RateLimit)
+	for id, r := range p.registrations {
+		r.rateLimit = nil
+		if config, ok := settings.WorkerRateLimits[id]; ok {
+			r.rateLimit = copyRateLimit(&config)
+		}
+	}
+	p.routes = copyRoutes(settings.Routes)
+	p.transformer = settings.Transformer
+	for id, w := range p.limiters {
+		w.setLimiter(p.limiter(id))
+	}
+	for _, id := range settings.Resume {
+		delete(p.paused, id)
+	}
+	return nil
+}
+
+//validate checks the settings refer to registered workers and have valid rate limits
+func (p *processor) validate(settings Settings) error {
+	if settings.RateLimit != nil {
+		err := validateRateLimit(*settings.RateLimit)
+		if err != nil {
+			return err
+		}
+	}
+	for id, config := range settings.WorkerRateLimits {
+		if _, ok := p.workerRegistry[id]; !ok {
+			return fmt.Errorf("Unknown worker %s in rate limits", id)
+		}
+		err := validateRateLimit(config)
+		if err != nil {
+			return fmt.Errorf("Invalid %s rate limit %s", id, err)
+		}
+	}
+	for metric, ids := range settings.Routes {
+		for _, id := range ids {
+			if _, ok := p.workerRegistry[id]; !ok {
+				return fmt.Errorf("Unknown worker %s in %s route", id, metric)
+			}
+		}
+	}
+	for _, id := range settings.Resume {
+		if _, ok := p.workerRegistry[id]; !ok {
+			return fmt.Errorf("Unknown resumed worker %s", id)
+		}
+	}
+	return nil
+}
+
+func validateRateLimit(config RateLimitConfig) error {
+	if config.Global.Rate < 0 {
+		return fmt.Errorf("Invalid rate %g", config.Global.Rate)
+	}
+	for metric, limit := range config.Metrics {
+		if limit.Rate < 0 {
+			return fmt.Errorf("Invalid %s rate %g", metric, limit.Rate)
+		}
+	}
+	return nil
+}
+
+//limiter returns the limiter of a registered worker, nil if it is not rate limited
+func (p *processor) limiter(id string) *limiter {
+	config := p.rateLimit
+	if r, ok := p.registrations[id]; ok && r.rateLimit != nil {
+		config = r.rateLimit
+	}
+	if config == nil {
+		return nil
+	}
+	return newLimiter(*config)
+}
+
+func copyRateLimit(config *RateLimitConfig) *RateLimitConfig {
+	if config == nil {
+		return nil
+	}
+	c := RateLimitConfig{Global: config.Global}
+	if config.Metrics != nil {
+		c.Metrics = make(map[string]RateLimit, len(config.Metrics))
+		for metric, limit := range config.Metrics {
+			c.Metrics[metric] = limit
+		}
+	}
+	return &c
+}
+
+func copyRoutes(routes map[string][]string) map[string][]string {
+	if routes == nil {
+		return nil
+	}
+	c := make(map[string][]string, len(routes))
+	for metric, ids := range routes {
+		c[metric] = append([]string(nil), ids...)
+	}
+	return c
+}
@@ -0,0 +1,136 @@
+package processor
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	fworkerprocessor "github.com/ferrariframework/ferrariworker/processor"
+	"github.com/streadway/amqp"
+)
+
+func Test_processor_Update(t *testing.T) {
+	p := newTestProcessor(nil)
+	p.Register("a", &mockWorker{})
+	p.Register("b", &mockWorker{})
+	current := Settings{
+		RateLimit: &RateLimitConfig{Global: RateLimit{Rate: 10}},
+		Routes:    map[string][]string{"kite_call": {"a"}},
+	}
+	if err := p.Update(current); err != nil {
+		t.Fatalf("processor.Update() error = %v", err)
+	}
+	tests := []struct {
+		name     string
+		settings Settings
+	}{
+		{"Unknown worker rate limit", Settings{WorkerRateLimits: map[string]RateLimitConfig{"unknown": {}}}},
+		{"Unknown routed worker", Settings{Routes: map[string][]string{"kite_call": {"a", "unknown"}}}},
+		{"Unknown resumed worker", Settings{Resume: []string{"a", "unknown"}}},
+		{"Negative rate", Settings{RateLimit: &RateLimitConfig{Global: RateLimit{Rate: -1}}}},
+		{"Negative metric rate", Settings{WorkerRateLimits: map[string]RateLimitConfig{"a": {Metrics: map[string]RateLimit{"kite_call": {Rate: -1}}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := p.Update(tt.settings); err == nil {
+				t.Fatal("processor.Update() error = nil want an error")
+			}
+			if !reflect.DeepEqual(p.routes, current.Routes) || !reflect.DeepEqual(p.rateLimit, current.RateLimit) {
+				t.Errorf("processor settings = %v %v want the previous settings kept", p.routes, p.rateLimit)
+			}
+		})
+	}
+}
+
+func Test_processor_Update_Resume(t *testing.T) {
+	p := newTestProcessor(nil)
+	p.Register("a", &mockWorker{})
+	p.Register("b", &mockWorker{}, SetPaused())
+	if ids := p.activeIDs("kite_call"); !reflect.DeepEqual(ids, []string{"a"}) {
+		t.Fatalf("processor.activeIDs() = %v want the paused worker excluded", ids)
+	}
+	err := p.Update(Settings{Routes: map[string][]string{"kite_call": {"b"}}, Resume: []string{"b"}})
+	if err != nil {
+		t.Fatalf("processor.Update() error = %v", err)
+	}
+	if ids := p.activeIDs("kite_call"); !reflect.DeepEqual(ids, []string{"b"}) {
+		t.Errorf("processor.activeIDs(kite_call) = %v want [b]", ids)
+	}
+	ids := p.activeIDs("kite_fail")
+	sort.Strings(ids)
+	if !reflect.DeepEqual(ids, []string{"a", "b"}) {
+		t.Errorf("processor.activeIDs(kite_fail) = %v want [a b]", ids)
+	}
+}
+
+func Test_processor_Update_Running(t *testing.T) {
+	feed := make(chan fworkerprocessor.Message)
+	p := New(
+		&processorAdapterMock{handler: mockMessagesHandlerFromChannel(feed)},
+		SetWaitTimeout(0),
+		SetLogger(log.New(ioutil.Discard, "", 0)),
+		SetRateLimit(RateLimitConfig{Global: RateLimit{Rate: 1}}),
+	)
+	var mu sync.Mutex
+	var executions []string
+	done := make(chan struct{})
+	for _, id := range []string{"a", "b"} {
+		id := id
+		p.Register(id, &mockWorker{handler: func(task interface{}) {
+			mu.Lock()
+			executions = append(executions, id+" "+task.(amqp.Delivery).MessageId)
+			mu.Unlock()
+			done <- struct{}{}
+		}})
+	}
+	started := make(chan error)
+	go func() {
+		started <- p.Start()
+	}()
+	send := func(id string, metric string, workers int) {
+		delivery := amqp.Delivery{MessageId: id, Body: []byte(`{"username": "kodingbot", "count": 1, "metric": "` + metric + `"}`)}
+		feed <- fworkerprocessor.Message{Payload: delivery.Body, OriginalMessage: delivery}
+		for i := 0; i < workers; i++ {
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatalf("message %s was not executed by %d workers", id, workers)
+			}
+		}
+	}
+
+	//The first execution uses the burst
+	send("1", "kite_call", 2)
+	err := p.Update(Settings{Routes: map[string][]string{"kite_call": {"a"}}})
+	if err != nil {
+		t.Fatalf("processor.Update() error = %v", err)
+	}
+	//Without rate limit the next messages don't wait for a second
+	start := time.Now()
+	send("2", "kite_call", 1)
+	send("3", "kite_fail", 2)
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("processor executions after removing the rate limit took %s", elapsed)
+	}
+	close(feed)
+	if err := <-started; err != nil {
+		t.Fatalf("processor.Start() error = %v", err)
+	}
+
+	sort.Strings(executions)
+	want := []string{"a 1", "a 2", "a 3", "b 1", "b 3"}
+	if !reflect.DeepEqual(executions, want) {
+		t.Errorf("worker executions = %v want %v", executions, want)
+	}
+}
+
+func mockMessagesHandlerFromChannel(feed chan fworkerprocessor.Message) testMessagesHandler {
+	return func(ctx context.Context) (<-chan fworkerprocessor.Message, error) {
+		return feed, nil
+	}
+}
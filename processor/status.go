@@ -0,0 +1,120 @@
+package processor
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	fworkerprocessor "github.com/ferrariframework/ferrariworker/processor"
+)
+
+//WorkerStatus status and counters of a registered worker. Counters are kept while the processor runs
+type WorkerStatus struct {
+	ID     string `json:"id"`
+	Paused bool   `json:"paused"`
+	//Number of goroutines executing tasks, 0 while the processor is not running
+	PoolSize int `json:"pool_size"`
+	//Tasks waiting in the pool queue
+	Queued int `json:"queued"`
+	//Tasks being executed
+	Running int64 `json:"running"`
+	//Tasks executed and tasks which failed
+	Executed uint64 `json:"executed"`
+	Failed   uint64 `json:"failed"`
+	//State of the circuit breaker, empty if it is not enabled
+	Breaker string `json:"breaker,omitempty"`
+}
+
+//InFlightMessage a message being processed
+type InFlightMessage struct {
+	ID         uint64    `json:"id"`
+	MessageID  string    `json:"message_id,omitempty"`
+	Metric     string    `json:"metric,omitempty"`
+	Username   string    `json:"username,omitempty"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+//Workers returns the status of the registered workers ordered by id
+func (p *processor) Workers() []WorkerStatus {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	statuses := make([]WorkerStatus, 0, len(p.workerRegistry))
+	for id := range p.workerRegistry {
+		status := WorkerStatus{ID: id, Paused: p.paused[id]}
+		if pool, ok := p.pools[id]; ok {
+			status.PoolSize, status.Queued, status.Running, status.Executed, status.Failed = pool.status()
+			if b, ok := pool.worker.(*breakerWorker); ok {
+				status.Breaker = b.breaker.State().String()
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].ID < statuses[j].ID
+	})
+	return statuses
+}
+
+//InFlight returns the messages being processed ordered by arrival
+func (p *processor) InFlight() []InFlightMessage {
+	p.inFlightMu.Lock()
+	defer p.inFlightMu.Unlock()
+	msgs := make([]InFlightMessage, 0, len(p.inFlightMessages))
+	for _, m := range p.inFlightMessages {
+		msgs = append(msgs, m)
+	}
+	sort.Slice(msgs, func(i, j int) bool {
+		return msgs[i].ID < msgs[j].ID
+	})
+	return msgs
+}
+
+//Resize sets the number of goroutines executing tasks for a worker. While the processor is running its pool is resized
+//right away, removed goroutines finish their current task first
+func (p *processor) Resize(id string, size int) error {
+	if size < 1 {
+		return fmt.Errorf("Invalid pool size %d", size)
+	}
+	p.mu.Lock()
+	r, ok := p.registrations[id]
+	if !ok {
+		p.mu.Unlock()
+		return fmt.Errorf("Unknown worker %s", id)
+	}
+	r.poolSize = size
+	pool := p.pools[id]
+	p.mu.Unlock()
+	//resizing waits for removed goroutines to finish their task, so it doesn't hold the registry lock
+	if pool != nil {
+		pool.resize(size)
+	}
+	return nil
+}
+
+//Drain stops reading new messages. The messages being processed are finished and Start returns
+func (p *processor) Drain() {
+	p.drainOnce.Do(func() {
+		close(p.drain)
+	})
+}
+
+//track adds a message to the in-flight messages and returns a function removing it
+func (p *processor) track(m fworkerprocessor.Message, fields map[string]interface{}) func() {
+	p.inFlightMu.Lock()
+	defer p.inFlightMu.Unlock()
+	if p.inFlightMessages == nil {
+		p.inFlightMessages = make(map[uint64]InFlightMessage)
+	}
+	p.inFlightSeq++
+	id := p.inFlightSeq
+	msg := InFlightMessage{ID: id, ReceivedAt: time.Now()}
+	msg.MessageID, _ = fields["message_id"].(string)
+	msg.Metric, _ = fields["metric"].(string)
+	msg.Username, _ = fields["username"].(string)
+	p.inFlightMessages[id] = msg
+	return func() {
+		p.inFlightMu.Lock()
+		defer p.inFlightMu.Unlock()
+		delete(p.inFlightMessages, id)
+	}
+}
@@ -0,0 +1,89 @@
+package processor
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+
+	fworkerprocessor "github.com/ferrariframework/ferrariworker/processor"
+	"github.com/ottogiron/metricsworker/worker"
+	"github.com/streadway/amqp"
+)
+
+func Test_processor_StatusAndDrain(t *testing.T) {
+	feed := make(chan fworkerprocessor.Message)
+	p := New(
+		&processorAdapterMock{handler: func(ctx context.Context) (<-chan fworkerprocessor.Message, error) {
+			return feed, nil
+		}},
+		SetWaitTimeout(0),
+		SetLogger(log.New(ioutil.Discard, "", 0)),
+	)
+	started, release := make(chan struct{}), make(chan struct{})
+	p.Register("slow", worker.Func(func(task interface{}) error {
+		started <- struct{}{}
+		<-release
+		return nil
+	}))
+	done := make(chan error)
+	go func() {
+		done <- p.Start()
+	}()
+
+	delivery := amqp.Delivery{MessageId: "1", Body: []byte(`{"username": "kodingbot", "count": 1, "metric": "kite_call"}`)}
+	feed <- fworkerprocessor.Message{Payload: delivery.Body, OriginalMessage: delivery}
+	<-started
+
+	inFlight := p.InFlight()
+	if len(inFlight) != 1 || inFlight[0].MessageID != "1" || inFlight[0].Metric != "kite_call" || inFlight[0].Username != "kodingbot" {
+		t.Errorf("processor.InFlight() = %+v want the message being processed", inFlight)
+	}
+	if err := p.Resize("slow", 3); err != nil {
+		t.Fatalf("processor.Resize() error = %v", err)
+	}
+	statuses := p.Workers()
+	if len(statuses) != 1 || statuses[0].ID != "slow" || statuses[0].PoolSize != 3 || statuses[0].Running != 1 {
+		t.Errorf("processor.Workers() = %+v want the slow worker running with a pool of 3", statuses)
+	}
+
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for p.Workers()[0].Executed != 1 || len(p.InFlight()) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("processor.Workers() = %+v want 1 executed task", p.Workers())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	p.Drain()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("processor.Start() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("processor.Start() did not return after Drain()")
+	}
+}
+
+func Test_processor_Resize_Invalid(t *testing.T) {
+	p := newTestProcessor(nil)
+	p.Register("worker", &mockWorker{})
+	tests := []struct {
+		name string
+		id   string
+		size int
+	}{
+		{"Unknown worker", "unknown", 1},
+		{"Invalid size", "worker", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := p.Resize(tt.id, tt.size); err == nil {
+				t.Errorf("processor.Resize() error = nil want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package processor
+
+//Transformer transforms a task before it is passed to the workers, e.g. to normalize the metric names.
+//Tasks which fail to transform are logged and not processed
+type Transformer interface {
+	Transform(task interface{}) (interface{}, error)
+}
+
+//SetTransformer sets the transformer applied to every task before it is passed to the workers.
+//Workers are routed by the metric of the transformed task
+func SetTransformer(transformer Transformer) Option {
+	return func(p *processor) {
+		p.transformer = transformer
+	}
+}
+
+//currentTransformer returns the transformer, nil if tasks are not transformed
+func (p *processor) currentTransformer() Transformer {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.transformer
+}
+
+//transformedTask a task a message was expanded in and the result of transforming it, see transform
+type transformedTask struct {
+	original    interface{}
+	task        interface{}
+	transformed bool
+	err         error
+}
+
+//transform transforms the tasks a message was expanded in with the current transformer, once per message so the
+//partition key and the workers see the same transformed tasks. Tasks are kept as they are without transformer
+func (p *processor) transform(tasks []interface{}) []transformedTask {
+	transformer := p.currentTransformer()
+	transformed := make([]transformedTask, len(tasks))
+	for i, task := range tasks {
+		transformed[i] = transformedTask{original: task, task: task}
+		if transformer != nil {
+			transformed[i].task, transformed[i].err = transformer.Transform(task)
+			transformed[i].transformed = true
+		}
+	}
+	return transformed
+}
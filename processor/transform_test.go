@@ -0,0 +1,75 @@
+package processor
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	fworkerprocessor "github.com/ferrariframework/ferrariworker/processor"
+	"github.com/streadway/amqp"
+)
+
+//transformerFunc transforms the body of a rabbit delivery
+type transformerFunc func(body string) (string, error)
+
+func (f transformerFunc) Transform(task interface{}) (interface{}, error) {
+	delivery := task.(amqp.Delivery)
+	body, err := f(string(delivery.Body))
+	if err != nil {
+		return nil, err
+	}
+	delivery.Body = []byte(body)
+	return delivery, nil
+}
+
+func Test_processor_Start_Transformer(t *testing.T) {
+	var messages []fworkerprocessor.Message
+	for _, body := range []string{
+		`{"username": "kodingbot", "count": 1, "metric": "Kite.Call"}`,
+		`{"username": "kodingbot", "count": 1, "metric": "kite_fail"}`,
+		`{"username": "kodingbot", "count": 1, "metric": "invalid"}`,
+	} {
+		delivery := amqp.Delivery{Body: []byte(body)}
+		messages = append(messages, fworkerprocessor.Message{Payload: delivery.Body, OriginalMessage: delivery})
+	}
+	p := New(
+		&processorAdapterMock{handler: mockMessagesHandler(messages)},
+		SetLogger(log.New(ioutil.Discard, "", 0)),
+		SetTransformer(transformerFunc(func(body string) (string, error) {
+			if strings.Contains(body, "invalid") {
+				return "", errors.New("invalid metric")
+			}
+			return strings.Replace(body, "Kite.Call", "kite_call", 1), nil
+		})),
+		SetRoutes(map[string][]string{"kite_call": {"calls"}}),
+	)
+	var mu sync.Mutex
+	var executions []string
+	for _, id := range []string{"calls", "all"} {
+		id := id
+		p.Register(id, &mockWorker{handler: func(task interface{}) {
+			mu.Lock()
+			defer mu.Unlock()
+			executions = append(executions, id+" "+string(task.(amqp.Delivery).Body))
+		}})
+	}
+	err := p.Start()
+	if err != nil {
+		t.Fatalf("processor.Start() error = %v", err)
+	}
+
+	sort.Strings(executions)
+	want := []string{
+		`all {"username": "kodingbot", "count": 1, "metric": "kite_fail"}`,
+		`calls {"username": "kodingbot", "count": 1, "metric": "kite_call"}`,
+		`calls {"username": "kodingbot", "count": 1, "metric": "kite_fail"}`,
+	}
+	if !reflect.DeepEqual(executions, want) {
+		t.Errorf("worker executions = %v want %v", executions, want)
+	}
+}
@@ -0,0 +1,214 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ottogiron/metricsworker/worker"
+)
+
+//Handler serves the query API of a query service. Times are RFC 3339 or unix seconds.
+//
+//	GET /events?from=&to=&limit=                              latest events stored between from and to
+//	GET /aggregates?type=&username=&metric=&from=&to=&limit=  hourly aggregates
+//	GET /accounts?offset=&limit=                              accounts ordered by username
+//	GET /accounts/{username}                                  an account
+type Handler struct {
+	service *Service
+	mux     *http.ServeMux
+}
+
+//NewHandler returns a new instance of a query API handler
+func NewHandler(s *Service) *Handler {
+	h := &Handler{service: s, mux: http.NewServeMux()}
+	h.mux.HandleFunc("/events", h.events)
+	h.mux.HandleFunc("/aggregates", h.aggregates)
+	h.mux.HandleFunc("/accounts", h.accounts)
+	h.mux.HandleFunc("/accounts/", h.account)
+	return h
+}
+
+//ServeHTTP serves the query API
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) events(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	from, err := parseTime(params, "from")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := parseTime(params, "to")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := parseInt(params, "limit")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	events, err := h.service.Events(r.Context(), from, to, limit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, events)
+}
+
+func (h *Handler) aggregates(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	query := worker.AggregateQuery{
+		Type:     worker.MetricType(params.Get("type")),
+		UserName: params.Get("username"),
+		Metric:   params.Get("metric"),
+	}
+	var err error
+	query.From, err = parseTime(params, "from")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query.To, err = parseTime(params, "to")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query.Limit, err = parseInt(params, "limit")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	aggregates, err := h.service.Aggregates(r.Context(), query)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, aggregates)
+}
+
+func (h *Handler) accounts(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	offset, err := parseInt(params, "offset")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := parseInt(params, "limit")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	accounts, err := h.service.Accounts(r.Context(), offset, limit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, accounts)
+}
+
+//account handles /accounts/{username}
+func (h *Handler) account(w http.ResponseWriter, r *http.Request) {
+	username := strings.TrimPrefix(r.URL.Path, "/accounts/")
+	if username == "" || strings.Contains(username, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	account, err := h.service.Account(r.Context(), username)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, account)
+}
+
+//parseTime parses a time parameter in RFC 3339 or unix seconds, missing parameters are zero
+func parseTime(params url.Values, name string) (time.Time, error) {
+	value := params.Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid %s %s should be RFC 3339 or unix seconds", name, value)
+	}
+	return t, nil
+}
+
+//parseInt parses an integer parameter, missing parameters are 0
+func parseInt(params url.Values, name string) (int, error) {
+	value := params.Get(name)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid %s %s should be a number", name, value)
+	}
+	return n, nil
+}
+
+//writeError replies with the status of a query error
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if _, ok := err.(*InvalidQueryError); ok {
+		status = http.StatusBadRequest
+	}
+	switch err {
+	case worker.ErrNotFound:
+		status = http.StatusNotFound
+	case ErrUnavailable:
+		status = http.StatusNotImplemented
+	}
+	http.Error(w, err.Error(), status)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode response %s", err), http.StatusInternalServerError)
+	}
+}
+
+//Server serves the query API on an address
+type Server struct {
+	server   *http.Server
+	listener net.Listener
+}
+
+//Listen starts serving the query API of a service on address
+func Listen(address string, s *Service) (*Server, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to listen on %s %s", address, err)
+	}
+	server := &Server{server: &http.Server{Handler: NewHandler(s)}, listener: listener}
+	go server.server.Serve(listener)
+	return server, nil
+}
+
+//Addr returns the address the server is listening on
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+//Close stops the server
+func (s *Server) Close() error {
+	return s.server.Close()
+}
@@ -0,0 +1,189 @@
+//Package query provides read access to the metrics stored by the workers: the distinct events kept in redis, the
+//hourly aggregates kept in mongo and the accounts kept in postgres. Results are returned as the worker metric model.
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ottogiron/metricsworker/worker"
+)
+
+//DefaultLimit number of events, aggregates or accounts returned when no limit is requested
+const DefaultLimit = 100
+
+//MaxLimit maximum number of events, aggregates or accounts returned at once
+const MaxLimit = 1000
+
+//ErrUnavailable is returned by the queries of stores which were not configured
+var ErrUnavailable = errors.New("store not configured")
+
+//Option a functional option for the query service
+type Option func(*Service)
+
+//SetEventReader sets the reader of the distinct events
+func SetEventReader(reader worker.EventReader) Option {
+	return func(s *Service) {
+		s.events = reader
+	}
+}
+
+//SetAggregateReader sets the reader of the hourly aggregates
+func SetAggregateReader(reader worker.AggregateReader) Option {
+	return func(s *Service) {
+		s.aggregates = reader
+	}
+}
+
+//SetAccountReader sets the reader of the accounts
+func SetAccountReader(reader worker.AccountReader) Option {
+	return func(s *Service) {
+		s.accounts = reader
+	}
+}
+
+var _ worker.Lifecycle = (*Service)(nil)
+
+//Service queries the stores of the workers
+type Service struct {
+	events     worker.EventReader
+	aggregates worker.AggregateReader
+	accounts   worker.AccountReader
+}
+
+//New returns a new instance of a query service. Queries of the readers which are not set fail with ErrUnavailable
+func New(options ...Option) *Service {
+	s := &Service{}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+//Init initializes the readers and checks their connections
+func (s *Service) Init(ctx context.Context) error {
+	for _, reader := range s.readers() {
+		err := worker.Init(ctx, reader)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Close closes the readers
+func (s *Service) Close(ctx context.Context) error {
+	var closeErr error
+	for _, reader := range s.readers() {
+		err := worker.Close(ctx, reader)
+		if err != nil && closeErr == nil {
+			closeErr = err
+		}
+	}
+	return closeErr
+}
+
+func (s *Service) readers() []interface{} {
+	var readers []interface{}
+	if s.events != nil {
+		readers = append(readers, s.events)
+	}
+	if s.aggregates != nil {
+		readers = append(readers, s.aggregates)
+	}
+	if s.accounts != nil {
+		readers = append(readers, s.accounts)
+	}
+	return readers
+}
+
+//Events returns the latest events stored between from and to inclusive ordered by timestamp, newest first. Zero times
+//are unbounded, see limit for the number of events returned
+func (s *Service) Events(ctx context.Context, from, to time.Time, limit int) ([]worker.Event, error) {
+	if s.events == nil {
+		return nil, ErrUnavailable
+	}
+	if err := validateRange(from, to); err != nil {
+		return nil, err
+	}
+	limit, err := validateLimit(limit)
+	if err != nil {
+		return nil, err
+	}
+	return s.events.FindEvents(ctx, from, to, limit)
+}
+
+//Aggregates returns the hourly aggregates matching a query ordered by hour, type, key and username, see query.Limit for
+//the number of aggregates returned
+func (s *Service) Aggregates(ctx context.Context, query worker.AggregateQuery) ([]worker.Aggregate, error) {
+	if s.aggregates == nil {
+		return nil, ErrUnavailable
+	}
+	if err := validateRange(query.From, query.To); err != nil {
+		return nil, err
+	}
+	limit, err := validateLimit(query.Limit)
+	if err != nil {
+		return nil, err
+	}
+	query.Limit = limit
+	return s.aggregates.FindAggregates(ctx, query)
+}
+
+//Accounts returns the accounts ordered by username skipping offset accounts, see limit for the number of accounts returned
+func (s *Service) Accounts(ctx context.Context, offset, limit int) ([]worker.Account, error) {
+	if s.accounts == nil {
+		return nil, ErrUnavailable
+	}
+	if offset < 0 {
+		return nil, &InvalidQueryError{fmt.Sprintf("Offset should not be negative %d", offset)}
+	}
+	limit, err := validateLimit(limit)
+	if err != nil {
+		return nil, err
+	}
+	return s.accounts.FindAccounts(ctx, offset, limit)
+}
+
+//Account returns an account by username, or worker.ErrNotFound
+func (s *Service) Account(ctx context.Context, username string) (*worker.Account, error) {
+	if s.accounts == nil {
+		return nil, ErrUnavailable
+	}
+	if username == "" {
+		return nil, &InvalidQueryError{"Username is required"}
+	}
+	return s.accounts.FindAccount(ctx, username)
+}
+
+//InvalidQueryError is returned for queries with invalid arguments e.g. a range ending before it starts
+type InvalidQueryError struct {
+	msg string
+}
+
+//Error returns the reason the query is invalid
+func (e *InvalidQueryError) Error() string {
+	return e.msg
+}
+
+func validateRange(from, to time.Time) error {
+	if !from.IsZero() && !to.IsZero() && to.Before(from) {
+		return &InvalidQueryError{fmt.Sprintf("The end of the range %s is before its start %s", to.Format(time.RFC3339), from.Format(time.RFC3339))}
+	}
+	return nil
+}
+
+//validateLimit returns DefaultLimit for 0, limits are at most MaxLimit
+func validateLimit(limit int) (int, error) {
+	switch {
+	case limit < 0:
+		return 0, &InvalidQueryError{fmt.Sprintf("Limit should not be negative %d", limit)}
+	case limit == 0:
+		return DefaultLimit, nil
+	case limit > MaxLimit:
+		return 0, &InvalidQueryError{fmt.Sprintf("Limit should be at most %d", MaxLimit)}
+	}
+	return limit, nil
+}
@@ -0,0 +1,204 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ottogiron/metricsworker/worker"
+	"github.com/ottogiron/metricsworker/worker/storetest"
+)
+
+var hour = time.Date(2017, 6, 1, 10, 0, 0, 0, time.UTC)
+
+//testService returns a query service of in-memory stores holding an event, aggregate and account per hour
+func testService(t *testing.T) *Service {
+	ctx := context.Background()
+	events := storetest.NewEventStore()
+	aggregates := storetest.NewHourlyLogStore()
+	accounts := storetest.NewAccountStore()
+	metrics := []worker.TypedMetric{
+		&worker.CountMetric{UserName: "kodingbot", Metric: "kite_call", Count: 2},
+		&worker.GaugeMetric{UserName: "koding", Metric: "connections", Value: 3, Tags: map[string]string{"region": "eu"}},
+	}
+	for i, metric := range metrics {
+		timestamp := hour.Add(time.Duration(i) * time.Hour)
+		//events are stored as the distinctName worker stores them, with a field for every tag
+		body, err := worker.MarshallMetric(metric)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var fields map[string]interface{}
+		if err := json.Unmarshal(body, &fields); err != nil {
+			t.Fatal(err)
+		}
+		delete(fields, "tags")
+		fields["tag:region"] = "eu"
+		if err := events.SaveEvent(ctx, metric.Identity().Metric+":1", fields, timestamp.Unix()); err != nil {
+			t.Fatal(err)
+		}
+		if err := aggregates.AggregateMetric(ctx, metric, timestamp); err != nil {
+			t.Fatal(err)
+		}
+		if err := accounts.InsertAccount(ctx, metric.Identity().UserName, metric.Identity().Tags, timestamp.Unix()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return New(SetEventReader(events), SetAggregateReader(aggregates), SetAccountReader(accounts))
+}
+
+func TestHandler(t *testing.T) {
+	s := testService(t)
+	tests := []struct {
+		name       string
+		service    *Service
+		method     string
+		target     string
+		wantStatus int
+		want       string
+	}{
+		{
+			"Events",
+			s,
+			http.MethodGet,
+			"/events",
+			http.StatusOK,
+			`[{"id":"connections:1","timestamp":"2017-06-01T11:00:00Z","metric":{"type":"gauge","username":"koding","metric":"connections","tags":{"region":"eu"},"value":3}},` +
+				`{"id":"kite_call:1","timestamp":"2017-06-01T10:00:00Z","metric":{"username":"kodingbot","count":2,"metric":"kite_call","tags":{"region":"eu"}}}]`,
+		},
+		{
+			"Events in range",
+			s,
+			http.MethodGet,
+			"/events?from=2017-06-01T10:30:00Z&to=1496318400",
+			http.StatusOK,
+			`[{"id":"connections:1","timestamp":"2017-06-01T11:00:00Z","metric":{"type":"gauge","username":"koding","metric":"connections","tags":{"region":"eu"},"value":3}}]`,
+		},
+		{
+			"Latest events",
+			s,
+			http.MethodGet,
+			"/events?limit=1",
+			http.StatusOK,
+			`[{"id":"connections:1","timestamp":"2017-06-01T11:00:00Z","metric":{"type":"gauge","username":"koding","metric":"connections","tags":{"region":"eu"},"value":3}}]`,
+		},
+		{
+			"Latest events in range",
+			s,
+			http.MethodGet,
+			"/events?to=2017-06-01T10:30:00Z&limit=1",
+			http.StatusOK,
+			`[{"id":"kite_call:1","timestamp":"2017-06-01T10:00:00Z","metric":{"username":"kodingbot","count":2,"metric":"kite_call","tags":{"region":"eu"}}}]`,
+		},
+		{"Invalid time", s, http.MethodGet, "/events?from=yesterday", http.StatusBadRequest, ""},
+		{"Range ending before it starts", s, http.MethodGet, "/events?from=1496318400&to=1496311200", http.StatusBadRequest, ""},
+		{"Limit too large", s, http.MethodGet, "/events?limit=1001", http.StatusBadRequest, ""},
+		{
+			"Aggregates",
+			s,
+			http.MethodGet,
+			"/aggregates?type=gauge&metric=connections",
+			http.StatusOK,
+			`[{"type":"gauge","username":"koding","key":"connections,region=eu","hour":"2017-06-01T11:00:00Z","value":3,"count":0,"sum":0,"min":0,"max":0}]`,
+		},
+		{
+			"Aggregates by user in range",
+			s,
+			http.MethodGet,
+			"/aggregates?username=kodingbot&to=2017-06-01T10:00:00Z",
+			http.StatusOK,
+			`[{"type":"count","username":"kodingbot","key":"kite_call","hour":"2017-06-01T10:00:00Z","value":0,"count":2,"sum":0,"min":0,"max":0}]`,
+		},
+		{
+			"Aggregates from a later hour",
+			s,
+			http.MethodGet,
+			"/aggregates?username=kodingbot&from=2017-06-01T11:00:00Z",
+			http.StatusOK,
+			`[]`,
+		},
+		{
+			"First aggregate",
+			s,
+			http.MethodGet,
+			"/aggregates?limit=1",
+			http.StatusOK,
+			`[{"type":"count","username":"kodingbot","key":"kite_call","hour":"2017-06-01T10:00:00Z","value":0,"count":2,"sum":0,"min":0,"max":0}]`,
+		},
+		{"Aggregates limit too large", s, http.MethodGet, "/aggregates?limit=1001", http.StatusBadRequest, ""},
+		{
+			"Accounts",
+			s,
+			http.MethodGet,
+			"/accounts?offset=1",
+			http.StatusOK,
+			`[{"username":"kodingbot","timestamp":"2017-06-01T10:00:00Z"}]`,
+		},
+		{
+			"Account",
+			s,
+			http.MethodGet,
+			"/accounts/koding",
+			http.StatusOK,
+			`{"username":"koding","tags":{"region":"eu"},"timestamp":"2017-06-01T11:00:00Z"}`,
+		},
+		{"Unknown account", s, http.MethodGet, "/accounts/unknown", http.StatusNotFound, ""},
+		{"Negative offset", s, http.MethodGet, "/accounts?offset=-1", http.StatusBadRequest, ""},
+		{"Store not configured", New(), http.MethodGet, "/accounts", http.StatusNotImplemented, ""},
+		{"Not a GET", s, http.MethodPost, "/accounts", http.StatusMethodNotAllowed, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			NewHandler(tt.service).ServeHTTP(rec, httptest.NewRequest(tt.method, tt.target, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("Handler status = %d want %d %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.want == "" {
+				return
+			}
+			if rec.Header().Get("Content-Type") != "application/json" {
+				t.Errorf("Handler content type = %s", rec.Header().Get("Content-Type"))
+			}
+			if got := strings.TrimSpace(rec.Body.String()); got != tt.want {
+				t.Errorf("Handler body = %s want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestService_StoreErrors(t *testing.T) {
+	failure := errors.New("connection refused")
+	events := storetest.NewEventStore()
+	events.Err = failure
+	s := New(SetEventReader(events))
+	if _, err := s.Events(context.Background(), time.Time{}, time.Time{}, 0); err != failure {
+		t.Errorf("Service.Events() error = %v want %v", err, failure)
+	}
+	rec := httptest.NewRecorder()
+	NewHandler(s).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/events", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("Handler status = %d want %d", rec.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestListen(t *testing.T) {
+	server, err := Listen("127.0.0.1:0", testService(t))
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer server.Close()
+	res, err := http.Get("http://" + server.Addr().String() + "/accounts/kodingbot")
+	if err != nil {
+		t.Fatalf("Failed to get account %s", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("Server status = %d want %d", res.StatusCode, http.StatusOK)
+	}
+}
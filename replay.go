@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/ottogiron/metricsworker/processor"
+	"github.com/ottogiron/metricsworker/replay"
+	"github.com/ottogiron/metricsworker/worker"
+)
+
+//runReplay runs the replay command: mworker replay [flags]. It returns an error if the replay stopped or there were
+//invalid lines or failed executions
+func runReplay(args []string) error {
+	input := flag.String("input", "-", "Newline delimited JSON metrics file, optionally gzipped. Use - to read from stdin")
+	workers := flag.String("workers", strings.Join(workerIDs, ","), "Comma separated ids of the workers the metrics are replayed through - "+strings.Join(workerIDs, "|"))
+	rate := flag.Float64("rate", 0, "Maximum number of metrics replayed per second. 0 is unlimited")
+	dryRun := flag.Bool("dry-run", false, "Only decode and validate the metrics without executing the workers")
+	progressInterval := flag.Duration("progress-interval", 5*time.Second, "Interval between progress reports")
+	flag.CommandLine.Parse(args)
+
+	selected := make(map[string]worker.Worker)
+	for _, id := range strings.Split(*workers, ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		factory, ok := workerFactories[id]
+		if !ok {
+			return fmt.Errorf("Unknown worker %s", id)
+		}
+		if *dryRun {
+			selected[id] = nil
+			continue
+		}
+		w, err := factory()
+		if err != nil {
+			return fmt.Errorf("Failed to create worker %s %s", id, err)
+		}
+		selected[id] = w
+	}
+
+	//the metrics are transformed by the pipeline of the config file, as the worker does
+	var transformer processor.Transformer
+	if configFlag != "" {
+		c, err := readConfig(configFlag)
+		if err != nil {
+			return err
+		}
+		if c.Transform != nil {
+			pipeline, err := c.Transform.Pipeline()
+			if err != nil {
+				return fmt.Errorf("Invalid transform %s", err)
+			}
+			transformer = pipeline
+		}
+	}
+
+	var r io.Reader = os.Stdin
+	if *input != "-" {
+		f, err := os.Open(*input)
+		if err != nil {
+			return fmt.Errorf("Failed to open replay input %s", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	//the initialized workers are closed however the replay ends
+	initialized := make(map[string]worker.Worker, len(selected))
+	defer func() {
+		for id, w := range initialized {
+			err := worker.Close(context.Background(), w)
+			if err != nil {
+				log.Printf("Failed to close worker %s %s", id, err)
+			}
+		}
+	}()
+	for id, w := range selected {
+		initCtx, cancelInit := context.WithTimeout(ctx, processor.DefaultLifecycleTimeout)
+		err := worker.Init(initCtx, w)
+		cancelInit()
+		if err != nil {
+			return fmt.Errorf("Failed to initialize worker %s %s", id, err)
+		}
+		initialized[id] = w
+	}
+
+	replayer := replay.New(
+		selected,
+		replay.SetRate(*rate),
+		replay.SetDryRun(*dryRun),
+		replay.SetTransformer(transformer),
+		replay.SetProgress(*progressInterval, func(stats replay.Stats) {
+			log.Printf("Replay progress lines: %d invalid: %d succeeded: %d failed: %d elapsed: %s", stats.Lines, stats.Invalid, stats.Succeeded, stats.Failed, stats.Elapsed)
+		}),
+		replay.SetErrorHandler(func(line int64, workerID string, err error) {
+			if workerID == "" {
+				log.Printf("Invalid metric at line %d %s", line, err)
+				return
+			}
+			log.Printf("Failed to replay line %d for worker id: %s %s", line, workerID, err)
+		}),
+	)
+	stats, err := replayer.Run(ctx, r)
+	if err != nil {
+		return fmt.Errorf("Replay stopped %s", err)
+	}
+	if stats.Invalid > 0 || stats.Failed > 0 {
+		return fmt.Errorf("Replay finished with %d invalid lines and %d failed executions", stats.Invalid, stats.Failed)
+	}
+	return nil
+}
@@ -0,0 +1,306 @@
+//Package replay re-processes archived metrics through workers.
+//
+//The input is newline delimited JSON with a metric, a JSON array of metrics or an archive record per line, optionally gzip compressed.
+//Archive records hold the deliveries as they were received, so their bodies are decoded and split like the processor does.
+//Set the transformer the workers consume with (see SetTransformer) so the metrics are transformed as when they were received.
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/ottogiron/metricsworker/processor"
+	"github.com/ottogiron/metricsworker/transport"
+	"github.com/ottogiron/metricsworker/worker"
+	"github.com/streadway/amqp"
+)
+
+const maxLineSize = 1024 * 1024
+
+//Stats replay progress
+type Stats struct {
+	//Lines read from the input, empty lines are not counted
+	Lines int64
+	//Invalid lines which could not be decoded as a metric
+	Invalid int64
+	//Executions which succeeded for a worker
+	Succeeded int64
+	//Executions which failed for a worker
+	Failed int64
+	//Time elapsed since the replay started
+	Elapsed time.Duration
+}
+
+//ProgressHandler receives the replay progress
+type ProgressHandler func(stats Stats)
+
+//Replayer replays metrics through a set of workers
+type Replayer struct {
+	workers          map[string]worker.Worker
+	rate             float64
+	dryRun           bool
+	progressInterval time.Duration
+	progress         ProgressHandler
+	errorHandler     func(line int64, workerID string, err error)
+	transformer      processor.Transformer
+}
+
+//Option a functional option for the replayer
+type Option func(*Replayer)
+
+//SetRate sets the maximum number of metrics replayed per second. A rate of 0 is unlimited
+func SetRate(rate float64) Option {
+	return func(r *Replayer) {
+		r.rate = rate
+	}
+}
+
+//SetDryRun sets whether metrics are only decoded and validated without executing the workers
+func SetDryRun(dryRun bool) Option {
+	return func(r *Replayer) {
+		r.dryRun = dryRun
+	}
+}
+
+//SetProgress sets the handler receiving the progress every interval and once the replay finishes
+func SetProgress(interval time.Duration, handler ProgressHandler) Option {
+	return func(r *Replayer) {
+		r.progressInterval = interval
+		r.progress = handler
+	}
+}
+
+//SetErrorHandler sets the handler called for every invalid line or failed worker execution.
+//workerID is empty for invalid lines
+func SetErrorHandler(handler func(line int64, workerID string, err error)) Option {
+	return func(r *Replayer) {
+		r.errorHandler = handler
+	}
+}
+
+//SetTransformer sets the transformer applied to every metric before it is replayed, as the processor does.
+//Lines with a metric which fails to transform are invalid, their other metrics are replayed
+func SetTransformer(transformer processor.Transformer) Option {
+	return func(r *Replayer) {
+		r.transformer = transformer
+	}
+}
+
+//New returns a new instance of a replayer
+func New(workers map[string]worker.Worker, options ...Option) *Replayer {
+	r := &Replayer{
+		workers:          workers,
+		progressInterval: time.Second * 5,
+		progress:         func(Stats) {},
+		errorHandler:     func(int64, string, error) {},
+	}
+	for _, option := range options {
+		option(r)
+	}
+	return r
+}
+
+//Run replays every metric read from the input, which is decompressed if it is gzipped.
+//Invalid lines and failed executions are counted but don't stop the replay
+func (r *Replayer) Run(ctx context.Context, input io.Reader) (Stats, error) {
+	start := time.Now()
+	stats := Stats{}
+	reader, err := decompress(input)
+	if err != nil {
+		return stats, err
+	}
+
+	var throttle <-chan time.Time
+	if r.rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / r.rate))
+		defer ticker.Stop()
+		throttle = ticker.C
+	}
+	var progress <-chan time.Time
+	if r.progressInterval > 0 {
+		ticker := time.NewTicker(r.progressInterval)
+		defer ticker.Stop()
+		progress = ticker.C
+	}
+
+	ids := make([]string, 0, len(r.workers))
+	for id := range r.workers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		stats.Lines++
+
+		if throttle != nil {
+			select {
+			case <-throttle:
+			case <-ctx.Done():
+				return r.finish(stats, start), ctx.Err()
+			}
+		}
+		select {
+		case <-progress:
+			stats.Elapsed = time.Since(start)
+			r.progress(stats)
+		case <-ctx.Done():
+			return r.finish(stats, start), ctx.Err()
+		default:
+		}
+
+		body := make([]byte, len(line))
+		copy(body, line)
+		tasks, err := decode(body)
+		if err != nil {
+			stats.Invalid++
+			r.errorHandler(stats.Lines, "", err)
+			continue
+		}
+		tasks, err = r.transform(tasks)
+		if err != nil {
+			stats.Invalid++
+			r.errorHandler(stats.Lines, "", err)
+		}
+		if r.dryRun {
+			continue
+		}
+		for _, task := range tasks {
+			for _, id := range ids {
+				err := r.workers[id].Execute(task)
+				if err != nil {
+					stats.Failed++
+					r.errorHandler(stats.Lines, id, err)
+					continue
+				}
+				stats.Succeeded++
+			}
+		}
+	}
+	stats = r.finish(stats, start)
+	if err := scanner.Err(); err != nil {
+		return stats, fmt.Errorf("Failed to read replay input %s", err)
+	}
+	return stats, nil
+}
+
+func (r *Replayer) finish(stats Stats, start time.Time) Stats {
+	stats.Elapsed = time.Since(start)
+	r.progress(stats)
+	return stats
+}
+
+//transform returns the tasks which were transformed and the first transform error, if any
+func (r *Replayer) transform(tasks []amqp.Delivery) ([]amqp.Delivery, error) {
+	if r.transformer == nil {
+		return tasks, nil
+	}
+	transformed := make([]amqp.Delivery, 0, len(tasks))
+	var firstErr error
+	for i, task := range tasks {
+		t, err := r.transformer.Transform(task)
+		delivery, ok := t.(amqp.Delivery)
+		if err == nil && !ok {
+			err = fmt.Errorf("Transformed task should be a rabbit delivery %v", t)
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("Failed to transform metric at index %d %s", i, err)
+			}
+			continue
+		}
+		transformed = append(transformed, delivery)
+	}
+	return transformed, firstErr
+}
+
+//decode returns the tasks for a line, which is either a metric, a JSON array of metrics or an archive record.
+//Archive records are replayed with their original metadata, the time they were received is the worker.EventTimeHeader.
+//Every metric of a batched body is a task
+func decode(line []byte) ([]amqp.Delivery, error) {
+	record, ok, err := worker.UnmarshallArchiveRecord(line)
+	if err != nil {
+		return nil, err
+	}
+	delivery := transport.NewMessage(line).OriginalMessage.(amqp.Delivery)
+	if ok {
+		//the delivery timestamp is the replay time, workers read the original time from the event time header
+		archived := record.Delivery()
+		delivery.Body = archived.Body
+		delivery.Headers = amqp.Table{worker.EventTimeHeader: record.ReceivedAt}
+		delivery.RoutingKey = archived.RoutingKey
+		delivery.MessageId = archived.MessageId
+		delivery.ContentType = archived.ContentType
+		delivery.ContentEncoding = archived.ContentEncoding
+	}
+	bodies, err := splitDelivery(delivery)
+	if err != nil {
+		return nil, err
+	}
+	delivery.ContentType = worker.ContentTypeJSON
+	delivery.ContentEncoding = ""
+	tasks := make([]amqp.Delivery, 0, len(bodies))
+	for i, body := range bodies {
+		metric, err := worker.UnmarshallMetric(body)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid metric at index %d %s", i, err)
+		}
+		err = metric.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("Invalid metric at index %d %s", i, err)
+		}
+		task := delivery
+		task.Body = body
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+//splitDelivery returns the JSON body of every metric of a delivery. Bodies which are not plain JSON are decoded with
+//the codec of their content type and their metrics marshalled as JSON, as the processor does before executing the workers
+func splitDelivery(delivery amqp.Delivery) ([][]byte, error) {
+	if worker.IsPlainJSON(delivery.ContentType, delivery.ContentEncoding, delivery.Body) {
+		return worker.SplitMetrics(delivery.Body)
+	}
+	metrics, err := worker.DecodeDelivery(delivery)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode %s body %s", delivery.ContentType, err)
+	}
+	bodies := make([][]byte, 0, len(metrics))
+	for _, metric := range metrics {
+		body, err := worker.MarshallMetric(metric)
+		if err != nil {
+			return nil, err
+		}
+		bodies = append(bodies, body)
+	}
+	return bodies, nil
+}
+
+//decompress returns a reader of the uncompressed input if it is gzipped, or the input as is otherwise
+func decompress(input io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(input)
+	magic, err := buffered.Peek(2)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("Failed to read replay input %s", err)
+	}
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read gzipped replay input %s", err)
+		}
+		return gz, nil
+	}
+	return buffered, nil
+}
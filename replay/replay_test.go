@@ -0,0 +1,221 @@
+package replay
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ottogiron/metricsworker/transform"
+	"github.com/ottogiron/metricsworker/worker"
+	"github.com/ottogiron/metricsworker/worker/rabbit"
+	"github.com/ottogiron/metricsworker/worker/storetest"
+	"github.com/streadway/amqp"
+)
+
+const input = `{"username": "kodingbot", "count": 1, "metric": "kite_call"}
+
+{"username": "kodingbot", "count": 2, "metric": "kite_call"}
+{"username": "kodingbot"
+{"username": "koding", "count": 3}
+`
+
+type countWorker struct {
+	executed int
+	err      error
+}
+
+func (w *countWorker) Execute(task interface{}) error {
+	w.executed++
+	return w.err
+}
+
+func gzipped(t *testing.T, s string) []byte {
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	if _, err := gz.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	gz.Close()
+	return b.Bytes()
+}
+
+func TestReplayer_Run(t *testing.T) {
+	tests := []struct {
+		name         string
+		input        []byte
+		workerErr    error
+		options      []Option
+		want         Stats
+		wantExecuted int
+	}{
+		{
+			"Replay valid metrics",
+			[]byte(input),
+			nil,
+			nil,
+			Stats{Lines: 4, Invalid: 2, Succeeded: 2},
+			2,
+		},
+		{
+			"Replay gzipped metrics",
+			gzipped(t, input),
+			nil,
+			nil,
+			Stats{Lines: 4, Invalid: 2, Succeeded: 2},
+			2,
+		},
+		{
+			"Replay archive records",
+			[]byte(`{"received_at": "2017-04-12T02:12:13Z", "routing_key": "test-key", "message_id": "1", "body": {"username": "kodingbot", "count": 1, "metric": "kite_call"}}
+{"received_at": "2017-04-12T02:12:14Z", "body": {"username": "kodingbot", "count": 1}}
+`),
+			nil,
+			nil,
+			Stats{Lines: 2, Invalid: 1, Succeeded: 1},
+			1,
+		},
+		{
+			"Replay archived StatsD records",
+			[]byte(`{"received_at": "2017-04-12T02:12:13Z", "content_type": "text/x-statsd", "data": "a2l0ZV9jYWxsOjF8Y3wjdXNlcm5hbWU6a29kaW5nYm90CmtpdGVfY2FsbDoyfGN8I3VzZXJuYW1lOmtvZGluZw=="}
+{"received_at": "2017-04-12T02:12:14Z", "content_type": "text/x-statsd", "data": "a2l0ZV9jYWxs"}
+`),
+			nil,
+			nil,
+			Stats{Lines: 2, Invalid: 1, Succeeded: 2},
+			2,
+		},
+		{
+			"Replay batched metrics",
+			[]byte(`[{"username": "kodingbot", "count": 1, "metric": "kite_call"}, {"username": "koding", "count": 2, "metric": "kite_call"}]
+[{"username": "kodingbot", "count": 1, "metric": "kite_call"}, {"username": "koding", "count": 2}]
+`),
+			nil,
+			nil,
+			Stats{Lines: 2, Invalid: 1, Succeeded: 2},
+			2,
+		},
+		{
+			"Count failed executions",
+			[]byte(input),
+			errors.New("failed"),
+			nil,
+			Stats{Lines: 4, Invalid: 2, Failed: 2},
+			2,
+		},
+		{
+			"Dry run",
+			[]byte(input),
+			nil,
+			[]Option{SetDryRun(true)},
+			Stats{Lines: 4, Invalid: 2},
+			0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &countWorker{err: tt.workerErr}
+			r := New(map[string]worker.Worker{"count": w}, tt.options...)
+			got, err := r.Run(context.Background(), bytes.NewReader(tt.input))
+			if err != nil {
+				t.Fatalf("Replayer.Run() error = %v", err)
+			}
+			got.Elapsed = 0
+			if got != tt.want {
+				t.Errorf("Replayer.Run() = %+v want %+v", got, tt.want)
+			}
+			if w.executed != tt.wantExecuted {
+				t.Errorf("Replayer.Run() executed = %d want %d", w.executed, tt.wantExecuted)
+			}
+		})
+	}
+}
+
+func TestReplayer_Run_Transform(t *testing.T) {
+	var usernames []string
+	w := worker.Func(func(task interface{}) error {
+		metric, err := worker.UnmarshallCountMetric(task.(amqp.Delivery).Body)
+		if err != nil {
+			return err
+		}
+		usernames = append(usernames, metric.UserName)
+		return nil
+	})
+	transformer := transform.New(transform.LowercaseUsernames(), func(m *transform.Metric) error {
+		if m.Metric == "invalid" {
+			return errors.New("invalid metric")
+		}
+		return nil
+	})
+	lines := `{"received_at": "2017-04-12T02:12:13Z", "body": [{"username": "Kodingbot", "count": 1, "metric": "kite_call"}, {"username": "koding", "count": 1, "metric": "invalid"}]}
+{"username": "KODING", "count": 1, "metric": "kite_call"}
+`
+	r := New(map[string]worker.Worker{"usernames": w}, SetTransformer(transformer))
+	got, err := r.Run(context.Background(), strings.NewReader(lines))
+	if err != nil {
+		t.Fatalf("Replayer.Run() error = %v", err)
+	}
+	got.Elapsed = 0
+	if want := (Stats{Lines: 2, Invalid: 1, Succeeded: 2}); got != want {
+		t.Errorf("Replayer.Run() = %+v want %+v", got, want)
+	}
+	if want := []string{"kodingbot", "koding"}; !reflect.DeepEqual(usernames, want) {
+		t.Errorf("Replayer.Run() usernames = %v want %v", usernames, want)
+	}
+}
+
+func TestReplayer_Run_Rate(t *testing.T) {
+	lines := strings.Repeat(`{"username": "kodingbot", "count": 1, "metric": "kite_call"}`+"\n", 5)
+	r := New(map[string]worker.Worker{"count": &countWorker{}}, SetRate(50))
+	start := time.Now()
+	if _, err := r.Run(context.Background(), strings.NewReader(lines)); err != nil {
+		t.Fatalf("Replayer.Run() error = %v", err)
+	}
+	//5 metrics at 50 per second take at least 100ms
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("Replayer.Run() elapsed = %s want at least 100ms", elapsed)
+	}
+}
+
+func TestReplayer_Run_Progress(t *testing.T) {
+	var reports []Stats
+	r := New(map[string]worker.Worker{"count": &countWorker{}}, SetProgress(time.Hour, func(stats Stats) {
+		reports = append(reports, stats)
+	}))
+	if _, err := r.Run(context.Background(), strings.NewReader(input)); err != nil {
+		t.Fatalf("Replayer.Run() error = %v", err)
+	}
+	if len(reports) != 1 || reports[0].Lines != 4 {
+		t.Errorf("Replayer.Run() progress reports = %+v want a final report", reports)
+	}
+}
+
+func TestReplayer_Run_Backfill(t *testing.T) {
+	store := storetest.NewHourlyLogStore()
+	receivedAt := time.Now().UTC().Add(-3 * time.Hour)
+	record := func(body string) string {
+		return `{"received_at": "` + receivedAt.Format(time.RFC3339) + `", "body": ` + body + "}\n"
+	}
+	lines := record(`{"username": "kodingbot", "count": 1, "metric": "kite_call"}`) +
+		record(`{"type": "gauge", "username": "kodingbot", "metric": "connections", "value": 2}`)
+	r := New(map[string]worker.Worker{"hourlyLog": rabbit.NewHourlyLogWorker(store)})
+	got, err := r.Run(context.Background(), strings.NewReader(lines))
+	if err != nil {
+		t.Fatalf("Replayer.Run() error = %v", err)
+	}
+	if got.Succeeded != 2 {
+		t.Errorf("Replayer.Run() = %+v want 2 succeeded executions", got)
+	}
+	if metrics := store.Metrics(); len(metrics) != 1 || metrics[0].Metric != "kite_call" {
+		t.Errorf("Replayer.Run() stored metrics = %+v want kite_call", metrics)
+	}
+	aggregates := store.Aggregates()
+	hour := receivedAt.Truncate(time.Hour)
+	if len(aggregates) != 2 || !aggregates[0].Hour.Equal(hour) || !aggregates[1].Hour.Equal(hour) {
+		t.Errorf("Replayer.Run() stored aggregates = %+v want the kite_call count and connections gauge in hour %s", aggregates, hour)
+	}
+}
@@ -0,0 +1,52 @@
+package tracing
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+var _ Exporter = (*WriterExporter)(nil)
+var _ Exporter = (*FileExporter)(nil)
+
+//WriterExporter exports spans as newline delimited JSON to a writer e.g. os.Stdout
+type WriterExporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+//NewWriterExporter returns a new instance of a writer exporter
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{enc: json.NewEncoder(w)}
+}
+
+//Export writes a span as a JSON line
+func (e *WriterExporter) Export(span SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.enc.Encode(span)
+}
+
+//FileExporter exports spans as newline delimited JSON appended to a file
+type FileExporter struct {
+	*WriterExporter
+	file *os.File
+}
+
+//NewFileExporter returns a new instance of a file exporter, the file is created if it does not exist
+func NewFileExporter(path string) (*FileExporter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open trace file %s %s", path, err)
+	}
+	return &FileExporter{WriterExporter: NewWriterExporter(file), file: file}, nil
+}
+
+//Close closes the file
+func (e *FileExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.file.Close()
+}
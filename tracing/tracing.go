@@ -0,0 +1,303 @@
+//Package tracing provides OpenTelemetry style tracing spans propagated through AMQP headers with the W3C traceparent format.
+//Spans are exported once ended to a pluggable exporter, no span is created until a tracer is set with SetTracer
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+//TraceparentHeader AMQP header the span context is propagated in
+const TraceparentHeader = "traceparent"
+
+//TraceID identifies a trace
+type TraceID [16]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+//MarshalText encodes the trace id as hex
+func (id TraceID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+//SpanID identifies a span within a trace
+type SpanID [8]byte
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+//MarshalText encodes the span id as hex
+func (id SpanID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+//SpanContext identifies a span across process boundaries
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	//Sampled spans are exported
+	Sampled bool
+}
+
+//IsValid returns whether both the trace and span ids are set
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+//Traceparent formats the span context as a W3C traceparent header value
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+//ParseTraceparent parses a W3C traceparent header value e.g. 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func ParseTraceparent(value string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, fmt.Errorf("Invalid traceparent %s", value)
+	}
+	if parts[0] == "ff" {
+		return sc, fmt.Errorf("Invalid traceparent version %s", value)
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, fmt.Errorf("Invalid traceparent trace id %s %s", value, err)
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, fmt.Errorf("Invalid traceparent span id %s %s", value, err)
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, fmt.Errorf("Invalid traceparent flags %s %s", value, err)
+	}
+	sc.Sampled = flags[0]&1 == 1
+	if !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("Invalid traceparent %s", value)
+	}
+	return sc, nil
+}
+
+type spanContextKey struct{}
+
+//ContextWithSpanContext returns a context whose spans are started as children of sc
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	if !sc.IsValid() {
+		return ctx
+	}
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+//SpanContextFromContext returns the span context of ctx, it is not valid if there is none
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	sc, _ := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc
+}
+
+//SpanData a span as it is exported
+type SpanData struct {
+	Name         string                 `json:"name"`
+	TraceID      TraceID                `json:"trace_id"`
+	SpanID       SpanID                 `json:"span_id"`
+	ParentSpanID *SpanID                `json:"parent_span_id,omitempty"`
+	Start        time.Time              `json:"start"`
+	End          time.Time              `json:"end"`
+	Attributes   map[string]interface{} `json:"attributes,omitempty"`
+	Error        string                 `json:"error,omitempty"`
+}
+
+//Span a timed operation. Methods of a nil span do nothing so callers don't need to check whether tracing is enabled
+type Span struct {
+	tracer  *Tracer
+	sampled bool
+	mu      sync.Mutex
+	data    SpanData
+	ended   bool
+}
+
+//SpanContext returns the span context to propagate
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return SpanContext{TraceID: s.data.TraceID, SpanID: s.data.SpanID, Sampled: s.sampled}
+}
+
+//SetAttribute sets an attribute of the span
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.Attributes == nil {
+		s.data.Attributes = make(map[string]interface{})
+	}
+	s.data.Attributes[key] = value
+}
+
+//SetError records the error the operation failed with, nil errors are ignored
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Error = err.Error()
+}
+
+//End ends the span and exports it if it is sampled. Only the first call has effect
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = s.tracer.now()
+	data := s.data
+	s.mu.Unlock()
+	if s.sampled {
+		s.tracer.export(data)
+	}
+}
+
+//Exporter exports ended spans
+type Exporter interface {
+	Export(span SpanData) error
+}
+
+//TracerOption defines a tracer option
+type TracerOption func(t *Tracer)
+
+//SetErrorHandler sets a function called with the errors returned by the exporter. They are ignored by default
+func SetErrorHandler(handler func(err error)) TracerOption {
+	return func(t *Tracer) {
+		t.errorHandler = handler
+	}
+}
+
+//Tracer starts spans and exports them once ended
+type Tracer struct {
+	exporter     Exporter
+	errorHandler func(err error)
+	now          func() time.Time
+}
+
+//NewTracer returns a new instance of a tracer exporting spans to exporter
+func NewTracer(exporter Exporter, options ...TracerOption) *Tracer {
+	t := &Tracer{
+		exporter:     exporter,
+		errorHandler: func(err error) {},
+		now:          time.Now,
+	}
+	for _, option := range options {
+		option(t)
+	}
+	return t
+}
+
+//Start starts a span as a child of the span context of ctx, or as the root of a new trace if there is none.
+//The returned context carries the new span context. A nil tracer returns ctx and a nil span
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	parent := SpanContextFromContext(ctx)
+	s := &Span{tracer: t, sampled: true}
+	s.data.Name = name
+	s.data.Start = t.now()
+	if parent.IsValid() {
+		s.data.TraceID = parent.TraceID
+		parentID := parent.SpanID
+		s.data.ParentSpanID = &parentID
+		s.sampled = parent.Sampled
+	} else {
+		rand.Read(s.data.TraceID[:])
+	}
+	rand.Read(s.data.SpanID[:])
+	return ContextWithSpanContext(ctx, s.SpanContext()), s
+}
+
+func (t *Tracer) export(data SpanData) {
+	if err := t.exporter.Export(data); err != nil {
+		t.errorHandler(err)
+	}
+}
+
+var global atomic.Value
+
+//SetTracer sets the tracer used by Start. A nil tracer disables tracing
+func SetTracer(t *Tracer) {
+	global.Store(&t)
+}
+
+//Start starts a span with the tracer set by SetTracer
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	t, _ := global.Load().(**Tracer)
+	if t == nil {
+		return ctx, nil
+	}
+	return (*t).Start(ctx, name)
+}
+
+//Extract returns the span context propagated in AMQP headers, it is not valid if there is none
+func Extract(headers amqp.Table) SpanContext {
+	value, ok := headers[TraceparentHeader].(string)
+	if !ok {
+		return SpanContext{}
+	}
+	sc, err := ParseTraceparent(value)
+	if err != nil {
+		return SpanContext{}
+	}
+	return sc
+}
+
+//Inject propagates a span context in AMQP headers
+func Inject(headers amqp.Table, sc SpanContext) {
+	if sc.IsValid() {
+		headers[TraceparentHeader] = sc.Traceparent()
+	}
+}
+
+//ContextFromTask returns a context carrying the span context propagated in the headers of a rabbit delivery task
+func ContextFromTask(task interface{}) context.Context {
+	ctx := context.Background()
+	if delivery, ok := task.(amqp.Delivery); ok {
+		return ContextWithSpanContext(ctx, Extract(delivery.Headers))
+	}
+	return ctx
+}
+
+//WithSpanContext returns a copy of a rabbit delivery task propagating sc in its headers.
+//Other tasks, or invalid span contexts, return the task unchanged
+func WithSpanContext(task interface{}, sc SpanContext) interface{} {
+	delivery, ok := task.(amqp.Delivery)
+	if !ok || !sc.IsValid() {
+		return task
+	}
+	headers := make(amqp.Table, len(delivery.Headers)+1)
+	for k, v := range delivery.Headers {
+		headers[k] = v
+	}
+	Inject(headers, sc)
+	delivery.Headers = headers
+	return delivery
+}
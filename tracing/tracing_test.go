@@ -0,0 +1,179 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/streadway/amqp"
+)
+
+//recordingExporter keeps the exported spans in memory
+type recordingExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (e *recordingExporter) Export(span SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, span)
+	return nil
+}
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       string
+		wantSampled bool
+		wantErr     bool
+	}{
+		{"Sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, false},
+		{"Not sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", false, false},
+		{"Invalid length", "00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01", false, true},
+		{"Invalid hex", "00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01", false, true},
+		{"Zero trace id", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, true},
+		{"Invalid version", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := ParseTraceparent(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTraceparent() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if sc.Sampled != tt.wantSampled {
+				t.Errorf("ParseTraceparent() sampled = %v want %v", sc.Sampled, tt.wantSampled)
+			}
+			if got := sc.Traceparent(); got != tt.value {
+				t.Errorf("SpanContext.Traceparent() = %s want %s", got, tt.value)
+			}
+		})
+	}
+}
+
+func TestTracer_Start(t *testing.T) {
+	exporter := &recordingExporter{}
+	tracer := NewTracer(exporter)
+	parent, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	ctx, span := tracer.Start(ContextWithSpanContext(context.Background(), parent), "process")
+	_, child := tracer.Start(ctx, "worker.Execute")
+	child.SetAttribute("worker_id", "distincName")
+	child.SetError(errors.New("failure"))
+	child.End()
+	span.End()
+	span.End()
+
+	if len(exporter.spans) != 2 {
+		t.Fatalf("Tracer.Start() exported %d spans want 2", len(exporter.spans))
+	}
+	childData, spanData := exporter.spans[0], exporter.spans[1]
+	if spanData.TraceID != parent.TraceID || *spanData.ParentSpanID != parent.SpanID {
+		t.Errorf("Tracer.Start() span = %+v want a child of %+v", spanData, parent)
+	}
+	if childData.TraceID != parent.TraceID || *childData.ParentSpanID != spanData.SpanID {
+		t.Errorf("Tracer.Start() child = %+v want a child of %+v", childData, spanData)
+	}
+	wantAttributes := map[string]interface{}{"worker_id": "distincName"}
+	if !reflect.DeepEqual(childData.Attributes, wantAttributes) || childData.Error != "failure" {
+		t.Errorf("Tracer.Start() child attributes = %v error = %s", childData.Attributes, childData.Error)
+	}
+}
+
+func TestTracer_Start_RootAndNotSampled(t *testing.T) {
+	exporter := &recordingExporter{}
+	tracer := NewTracer(exporter)
+
+	_, root := tracer.Start(context.Background(), "root")
+	if !root.SpanContext().IsValid() || root.data.ParentSpanID != nil {
+		t.Errorf("Tracer.Start() root = %+v want a new trace", root.data)
+	}
+	root.End()
+
+	notSampled, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	_, span := tracer.Start(ContextWithSpanContext(context.Background(), notSampled), "not sampled")
+	span.End()
+	if len(exporter.spans) != 1 || exporter.spans[0].Name != "root" {
+		t.Errorf("Tracer.Start() exported = %+v want only the root span", exporter.spans)
+	}
+}
+
+func TestStart_Disabled(t *testing.T) {
+	SetTracer(nil)
+	ctx := context.Background()
+	got, span := Start(ctx, "disabled")
+	if got != ctx || span != nil {
+		t.Errorf("Start() = %v, %v want the same context and a nil span", got, span)
+	}
+	//nil spans are safe to use
+	span.SetAttribute("key", "value")
+	span.SetError(errors.New("failure"))
+	span.End()
+}
+
+func TestWithSpanContext(t *testing.T) {
+	sc, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	delivery := amqp.Delivery{Headers: amqp.Table{"key": "value"}}
+	task := WithSpanContext(delivery, sc)
+	if _, ok := delivery.Headers[TraceparentHeader]; ok {
+		t.Errorf("WithSpanContext() modified the original delivery headers")
+	}
+	if got := Extract(task.(amqp.Delivery).Headers); got != sc {
+		t.Errorf("WithSpanContext() propagated %+v want %+v", got, sc)
+	}
+	if got := SpanContextFromContext(ContextFromTask(task)); got != sc {
+		t.Errorf("ContextFromTask() = %+v want %+v", got, sc)
+	}
+	if got := WithSpanContext("task", sc); got != "task" {
+		t.Errorf("WithSpanContext() = %v want the task unchanged", got)
+	}
+}
+
+func TestWriterExporter(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := NewTracer(NewWriterExporter(&buf))
+	_, span := tracer.Start(context.Background(), "process")
+	span.End()
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("WriterExporter output = %s %s", buf.String(), err)
+	}
+	if got["name"] != "process" || got["trace_id"] != span.SpanContext().TraceID.String() || got["span_id"] != span.SpanContext().SpanID.String() {
+		t.Errorf("WriterExporter output = %s", buf.String())
+	}
+}
+
+func TestFileExporter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tracing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "traces.json")
+	for i := 0; i < 2; i++ {
+		exporter, err := NewFileExporter(path)
+		if err != nil {
+			t.Fatalf("NewFileExporter() error = %v", err)
+		}
+		_, span := NewTracer(exporter).Start(context.Background(), "process")
+		span.End()
+		exporter.Close()
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(data, []byte("\n")); lines != 2 {
+		t.Errorf("FileExporter wrote %d lines want 2 %s", lines, data)
+	}
+}
@@ -0,0 +1,99 @@
+package transform
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+//DefaultAccountField body field the account ids are set to
+const DefaultAccountField = "account_id"
+
+//Table lookup table
+type Table map[string]string
+
+//Lookup returns the value of a key
+func (t Table) Lookup(key string) (string, bool) {
+	value, ok := t[key]
+	return value, ok
+}
+
+//ReadTable reads a lookup table from CSV records of key and value e.g. kodingbot,42
+func ReadTable(r io.Reader) (Table, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+	t := make(Table)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return t, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read lookup table %s", err)
+		}
+		t[strings.TrimSpace(record[0])] = strings.TrimSpace(record[1])
+	}
+}
+
+//LoadTable loads a lookup table from a CSV file, see ReadTable
+func LoadTable(path string) (Table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open lookup table %s", err)
+	}
+	defer f.Close()
+	return ReadTable(f)
+}
+
+//Config transformations of a pipeline. They are applied in the order of the fields
+type Config struct {
+	//Metric names renamed by name
+	RenameMetrics map[string]string `json:"rename_metrics"`
+	//Lowercase the metric names and replace dots, dashes and spaces with underscores
+	NormalizeMetrics bool `json:"normalize_metrics"`
+	//Lowercase the usernames
+	LowercaseUsernames bool `json:"lowercase_usernames"`
+	//Fields removed from the metric body
+	DropFields []string `json:"drop_fields"`
+	//Static tags added to the metric tags
+	Tags map[string]string `json:"tags"`
+	//CSV file of usernames and account ids, the account id of the metric username is set to AccountField
+	AccountsFile string `json:"accounts_file"`
+	//Field the account ids are set to, DefaultAccountField if empty
+	AccountField string `json:"account_field"`
+}
+
+//Pipeline returns a pipeline applying the configured transformations
+func (c Config) Pipeline() (*Pipeline, error) {
+	var steps []Step
+	if len(c.RenameMetrics) > 0 {
+		steps = append(steps, RenameMetrics(c.RenameMetrics))
+	}
+	if c.NormalizeMetrics {
+		steps = append(steps, NormalizeMetrics())
+	}
+	if c.LowercaseUsernames {
+		steps = append(steps, LowercaseUsernames())
+	}
+	if len(c.DropFields) > 0 {
+		steps = append(steps, DropFields(c.DropFields...))
+	}
+	if len(c.Tags) > 0 {
+		steps = append(steps, AddTags(c.Tags))
+	}
+	if c.AccountsFile != "" {
+		accounts, err := LoadTable(c.AccountsFile)
+		if err != nil {
+			return nil, err
+		}
+		field := c.AccountField
+		if field == "" {
+			field = DefaultAccountField
+		}
+		steps = append(steps, MapAccounts(field, accounts))
+	}
+	return New(steps...), nil
+}
@@ -0,0 +1,181 @@
+//Package transform provides a pipeline of transformations applied to the metrics before they are passed to the workers,
+//e.g. to normalize the metric names sent by different producers. Pipelines transform rabbit deliveries, so they apply
+//to the messages of every transport
+package transform
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ottogiron/metricsworker/worker"
+	"github.com/streadway/amqp"
+)
+
+//Metric decoded metric along with the other fields of the message body. Metrics of every type are decoded,
+//the fields of the types other than count e.g. type and value are kept in Fields
+type Metric struct {
+	worker.CountMetric
+	//Fields of the body other than username, count, metric and tags
+	Fields map[string]interface{}
+}
+
+//Decode decodes a metric body
+func Decode(body []byte) (*Metric, error) {
+	metric, err := worker.UnmarshallCountMetric(body)
+	if err != nil {
+		return nil, err
+	}
+	//numbers are kept as they were sent
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var fields map[string]interface{}
+	err = decoder.Decode(&fields)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse metric fields %s", err)
+	}
+	for _, name := range []string{"username", "count", "metric", "tags"} {
+		delete(fields, name)
+	}
+	return &Metric{CountMetric: *metric, Fields: fields}, nil
+}
+
+//Encode encodes the metric and its fields as a body
+func (m *Metric) Encode() ([]byte, error) {
+	body := make(map[string]interface{}, len(m.Fields)+4)
+	for name, value := range m.Fields {
+		body[name] = value
+	}
+	body["username"] = m.UserName
+	//only counts have a count, metrics of other types keep their type in the fields
+	if _, typed := m.Fields["type"]; !typed || m.Fields["type"] == string(worker.MetricTypeCount) || m.Count != 0 {
+		body["count"] = m.Count
+	}
+	body["metric"] = m.Metric
+	if len(m.Tags) > 0 {
+		body["tags"] = m.Tags
+	}
+	return json.Marshal(body)
+}
+
+//Step transforms a metric
+type Step func(m *Metric) error
+
+//Pipeline applies a list of steps in order
+type Pipeline struct {
+	steps []Step
+}
+
+//New returns a new instance of a pipeline applying the steps in order
+func New(steps ...Step) *Pipeline {
+	return &Pipeline{steps: steps}
+}
+
+//Apply applies the steps to a metric, it stops at the first step failing
+func (p *Pipeline) Apply(m *Metric) error {
+	for _, step := range p.steps {
+		err := step(m)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Transform applies the steps to the metric of a rabbit delivery and returns a copy of the delivery with the
+//transformed body. It satisfies processor.Transformer
+func (p *Pipeline) Transform(task interface{}) (interface{}, error) {
+	delivery, ok := task.(amqp.Delivery)
+	if !ok {
+		return nil, fmt.Errorf("Task should be a rabbit delivery %v", task)
+	}
+	metric, err := Decode(delivery.Body)
+	if err != nil {
+		return nil, err
+	}
+	err = p.Apply(metric)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to transform metric %s", err)
+	}
+	body, err := metric.Encode()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encode metric %s", err)
+	}
+	delivery.Body = body
+	return delivery, nil
+}
+
+//RenameMetrics renames the metrics by name, e.g. kite.call=kite_call. Other metrics keep their names
+func RenameMetrics(names map[string]string) Step {
+	return func(m *Metric) error {
+		if name, ok := names[m.Metric]; ok {
+			m.Metric = name
+		}
+		return nil
+	}
+}
+
+//metricNameReplacer replaces the separators of metric names with underscores
+var metricNameReplacer = strings.NewReplacer(".", "_", "-", "_", " ", "_")
+
+//NormalizeMetrics lowercases the metric names and replaces dots, dashes and spaces with underscores e.g. Kite.Call is kite_call
+func NormalizeMetrics() Step {
+	return func(m *Metric) error {
+		m.Metric = metricNameReplacer.Replace(strings.ToLower(strings.TrimSpace(m.Metric)))
+		return nil
+	}
+}
+
+//LowercaseUsernames lowercases the usernames
+func LowercaseUsernames() Step {
+	return func(m *Metric) error {
+		m.UserName = strings.ToLower(m.UserName)
+		return nil
+	}
+}
+
+//DropFields removes fields from the metric body. The username, count, metric and tags can't be dropped
+func DropFields(names ...string) Step {
+	return func(m *Metric) error {
+		for _, name := range names {
+			delete(m.Fields, name)
+		}
+		return nil
+	}
+}
+
+//AddTags adds static tags to the metric tags, tags sent by the producer are kept
+func AddTags(tags map[string]string) Step {
+	return func(m *Metric) error {
+		if m.Tags == nil {
+			m.Tags = make(map[string]string, len(tags))
+		}
+		for name, value := range tags {
+			if _, ok := m.Tags[name]; !ok {
+				m.Tags[name] = value
+			}
+		}
+		return nil
+	}
+}
+
+//Lookup looks up a value by key
+type Lookup interface {
+	Lookup(key string) (string, bool)
+}
+
+//MapAccounts sets field to the account id of the metric username. Usernames without account are left unchanged
+func MapAccounts(field string, accounts Lookup) Step {
+	return func(m *Metric) error {
+		account, ok := accounts.Lookup(m.UserName)
+		if !ok {
+			return nil
+		}
+		if m.Fields == nil {
+			m.Fields = make(map[string]interface{})
+		}
+		m.Fields[field] = account
+		return nil
+	}
+}
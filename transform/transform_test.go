@@ -0,0 +1,218 @@
+package transform
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/streadway/amqp"
+)
+
+func TestSteps(t *testing.T) {
+	tests := []struct {
+		name    string
+		step    Step
+		body    string
+		want    string
+		wantErr bool
+	}{
+		{
+			"Rename metrics",
+			RenameMetrics(map[string]string{"kite.call": "kite_call"}),
+			`{"username": "kodingbot", "count": 1, "metric": "kite.call"}`,
+			`{"count":1,"metric":"kite_call","username":"kodingbot"}`,
+			false,
+		},
+		{
+			"Rename other metric",
+			RenameMetrics(map[string]string{"kite.call": "kite_call"}),
+			`{"username": "kodingbot", "count": 1, "metric": "kite_fail"}`,
+			`{"count":1,"metric":"kite_fail","username":"kodingbot"}`,
+			false,
+		},
+		{
+			"Normalize metrics",
+			NormalizeMetrics(),
+			`{"username": "kodingbot", "count": 1, "metric": " Kite.Call-Count "}`,
+			`{"count":1,"metric":"kite_call_count","username":"kodingbot"}`,
+			false,
+		},
+		{
+			"Lowercase usernames",
+			LowercaseUsernames(),
+			`{"username": "KodingBot", "count": 1, "metric": "kite_call"}`,
+			`{"count":1,"metric":"kite_call","username":"kodingbot"}`,
+			false,
+		},
+		{
+			"Drop fields",
+			DropFields("debug", "username"),
+			`{"username": "kodingbot", "count": 1, "metric": "kite_call", "debug": true, "region": "eu"}`,
+			`{"count":1,"metric":"kite_call","region":"eu","username":"kodingbot"}`,
+			false,
+		},
+		{
+			"Add tags",
+			AddTags(map[string]string{"env": "prod"}),
+			`{"username": "kodingbot", "count": 1, "metric": "kite_call"}`,
+			`{"count":1,"metric":"kite_call","tags":{"env":"prod"},"username":"kodingbot"}`,
+			false,
+		},
+		{
+			"Add tags keeps producer tags",
+			AddTags(map[string]string{"env": "prod", "region": "us"}),
+			`{"username": "kodingbot", "count": 1, "metric": "kite_call", "tags": {"region": "eu"}}`,
+			`{"count":1,"metric":"kite_call","tags":{"env":"prod","region":"eu"},"username":"kodingbot"}`,
+			false,
+		},
+		{
+			"Add tags to invalid tags",
+			AddTags(map[string]string{"env": "prod"}),
+			`{"username": "kodingbot", "count": 1, "metric": "kite_call", "tags": "eu"}`,
+			"",
+			true,
+		},
+		{
+			"Gauges have no count",
+			NormalizeMetrics(),
+			`{"type": "gauge", "username": "kodingbot", "metric": "Open.Connections", "value": 3}`,
+			`{"metric":"open_connections","type":"gauge","username":"kodingbot","value":3}`,
+			false,
+		},
+		{
+			"Map accounts",
+			MapAccounts("account_id", Table{"kodingbot": "42"}),
+			`{"username": "kodingbot", "count": 1, "metric": "kite_call"}`,
+			`{"account_id":"42","count":1,"metric":"kite_call","username":"kodingbot"}`,
+			false,
+		},
+		{
+			"Map unknown account",
+			MapAccounts("account_id", Table{"kodingbot": "42"}),
+			`{"username": "someone", "count": 1, "metric": "kite_call"}`,
+			`{"count":1,"metric":"kite_call","username":"someone"}`,
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(tt.step).Transform(amqp.Delivery{MessageId: "1", Body: []byte(tt.body)})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Pipeline.Transform() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			delivery := got.(amqp.Delivery)
+			if delivery.MessageId != "1" {
+				t.Errorf("Pipeline.Transform() message id = %s want 1", delivery.MessageId)
+			}
+			if string(delivery.Body) != tt.want {
+				t.Errorf("Pipeline.Transform() body = %s want %s", delivery.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestPipeline_Transform(t *testing.T) {
+	tests := []struct {
+		name    string
+		task    interface{}
+		wantErr bool
+	}{
+		{"Not a delivery", "kite_call", true},
+		{"Invalid body", amqp.Delivery{Body: []byte(`{"metric":`)}, true},
+		{"Large counts are kept", amqp.Delivery{Body: []byte(`{"username": "kodingbot", "count": 9007199254740993, "metric": "kite_call", "value": 9007199254740993}`)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(NormalizeMetrics()).Transform(tt.task)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Pipeline.Transform() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			want := `{"count":9007199254740993,"metric":"kite_call","username":"kodingbot","value":9007199254740993}`
+			if body := string(got.(amqp.Delivery).Body); body != want {
+				t.Errorf("Pipeline.Transform() body = %s want %s", body, want)
+			}
+		})
+	}
+}
+
+func TestConfig_Pipeline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transform")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	accounts := filepath.Join(dir, "accounts.csv")
+	err = ioutil.WriteFile(accounts, []byte("kodingbot, 42\nsomeone,7\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var config Config
+	err = json.Unmarshal([]byte(`{
+		"rename_metrics": {"Kite.Calls": "kite.call"},
+		"normalize_metrics": true,
+		"lowercase_usernames": true,
+		"drop_fields": ["debug"],
+		"tags": {"env": "prod"},
+		"accounts_file": "`+accounts+`"
+	}`), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipeline, err := config.Pipeline()
+	if err != nil {
+		t.Fatalf("Config.Pipeline() error = %v", err)
+	}
+	metric, err := Decode([]byte(`{"username": "KodingBot", "count": 2, "metric": "Kite.Calls", "debug": true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = pipeline.Apply(metric)
+	if err != nil {
+		t.Fatalf("Pipeline.Apply() error = %v", err)
+	}
+	want := map[string]interface{}{DefaultAccountField: "42"}
+	if metric.UserName != "kodingbot" || metric.Metric != "kite_call" || metric.Count != 2 || !reflect.DeepEqual(metric.Fields, want) ||
+		!reflect.DeepEqual(metric.Tags, map[string]string{"env": "prod"}) {
+		t.Errorf("Pipeline.Apply() = %+v", metric)
+	}
+
+	config.AccountsFile = filepath.Join(dir, "missing.csv")
+	if _, err := config.Pipeline(); err == nil {
+		t.Error("Config.Pipeline() error = nil want a missing accounts file error")
+	}
+}
+
+func TestReadTable(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    Table
+		wantErr bool
+	}{
+		{"Valid", "kodingbot,42\n someone , 7\n", Table{"kodingbot": "42", "someone": "7"}, false},
+		{"Empty", "", Table{}, false},
+		{"Missing value", "kodingbot\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadTable(strings.NewReader(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadTable() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadTable() = %v want %v", got, tt.want)
+			}
+		})
+	}
+}
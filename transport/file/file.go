@@ -0,0 +1,119 @@
+//Package file provides a transport reading newline delimited JSON metrics from a file or stdin
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	fworkerprocessor "github.com/ferrariframework/ferrariworker/processor"
+	"github.com/ottogiron/metricsworker/processor"
+	"github.com/ottogiron/metricsworker/transport"
+)
+
+//Name transport name
+const Name = "file"
+
+//Stdin path value used to read from the standard input
+const Stdin = "-"
+
+const maxLineSize = 1024 * 1024
+
+func init() {
+	transport.Register(transport.Transport{
+		Name: Name,
+		Properties: []transport.Property{
+			{Name: "path", Type: transport.PropertyTypeString, Default: Stdin, Description: "Path of a newline delimited JSON metrics file. Use - to read from stdin"},
+		},
+		Factory: func(config transport.Config) (processor.Adapter, error) {
+			return New(config.String("path")), nil
+		},
+	})
+}
+
+var _ processor.Adapter = (*Adapter)(nil)
+var _ processor.ErrorReporter = (*Adapter)(nil)
+
+//Adapter reads one metric per line from a file. The messages channel is closed when the file is consumed or fails to
+//be read, see Err
+type Adapter struct {
+	path   string
+	reader io.Reader
+	closer io.Closer
+	err    error
+}
+
+//New returns a new instance of a file adapter
+func New(path string) *Adapter {
+	return &Adapter{path: path}
+}
+
+//NewFromReader returns a new instance of a file adapter reading from r
+func NewFromReader(r io.Reader) *Adapter {
+	return &Adapter{reader: r}
+}
+
+//Open opens the file
+func (a *Adapter) Open() error {
+	if a.reader != nil {
+		return nil
+	}
+	if a.path == Stdin || a.path == "" {
+		a.reader = os.Stdin
+		return nil
+	}
+	f, err := os.Open(a.path)
+	if err != nil {
+		return fmt.Errorf("Failed to open metrics file %s %s", a.path, err)
+	}
+	a.reader = f
+	a.closer = f
+	return nil
+}
+
+//Messages returns a channel of messages, one for every non empty line in the file
+func (a *Adapter) Messages(ctx context.Context) (<-chan fworkerprocessor.Message, error) {
+	if a.reader == nil {
+		return nil, fmt.Errorf("File adapter %s is not open", a.path)
+	}
+	scanner := bufio.NewScanner(a.reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	msgs := make(chan fworkerprocessor.Message)
+	go func() {
+		defer close(msgs)
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			body := make([]byte, len(line))
+			copy(body, line)
+			select {
+			case msgs <- transport.NewMessage(body):
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			a.err = fmt.Errorf("Failed to read metrics file %s %s", a.path, err)
+		}
+	}()
+	return msgs, nil
+}
+
+//Err returns the error found while reading the file, e.g. a line longer than 1MB, if any. It should be called once
+//the messages channel is closed, the processor returns it from Start
+func (a *Adapter) Err() error {
+	return a.err
+}
+
+//Close closes the file
+func (a *Adapter) Close() error {
+	if a.closer == nil {
+		return nil
+	}
+	return a.closer.Close()
+}
@@ -0,0 +1,119 @@
+package file
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/streadway/amqp"
+)
+
+func TestAdapter_Messages(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{
+			"One message per line",
+			"{\"metric\": \"a\"}\n{\"metric\": \"b\"}\n",
+			[]string{`{"metric": "a"}`, `{"metric": "b"}`},
+		},
+		{
+			"Skip empty lines",
+			"\n{\"metric\": \"a\"}\n\n  \n{\"metric\": \"b\"}",
+			[]string{`{"metric": "a"}`, `{"metric": "b"}`},
+		},
+		{
+			"Empty input",
+			"",
+			[]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewFromReader(strings.NewReader(tt.input))
+			if err := a.Open(); err != nil {
+				t.Fatalf("Adapter.Open() error = %v", err)
+			}
+			defer a.Close()
+			msgs, err := a.Messages(context.Background())
+			if err != nil {
+				t.Fatalf("Adapter.Messages() error = %v", err)
+			}
+			got := []string{}
+			for m := range msgs {
+				delivery, ok := m.OriginalMessage.(amqp.Delivery)
+				if !ok {
+					t.Fatalf("Adapter.Messages() original message should be an amqp delivery %v", m.OriginalMessage)
+				}
+				got = append(got, string(delivery.Body))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Adapter.Messages() = %v want %v", got, tt.want)
+			}
+			if a.Err() != nil {
+				t.Errorf("Adapter.Err() = %v", a.Err())
+			}
+		})
+	}
+}
+
+func TestAdapter_Err(t *testing.T) {
+	//the line is longer than the maximum line size
+	input := `{"metric": "a"}` + "\n" + strings.Repeat("a", maxLineSize+1) + "\n" + `{"metric": "b"}`
+	a := NewFromReader(strings.NewReader(input))
+	if err := a.Open(); err != nil {
+		t.Fatalf("Adapter.Open() error = %v", err)
+	}
+	defer a.Close()
+	msgs, err := a.Messages(context.Background())
+	if err != nil {
+		t.Fatalf("Adapter.Messages() error = %v", err)
+	}
+	read := 0
+	for range msgs {
+		read++
+	}
+	if read != 1 {
+		t.Errorf("Adapter.Messages() read = %d want 1", read)
+	}
+	if a.Err() == nil {
+		t.Errorf("Adapter.Err() = nil want the line size error")
+	}
+}
+
+func TestAdapter_Open(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filetransport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "metrics.json")
+	if err := ioutil.WriteFile(path, []byte(`{"metric": "a"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{"Existing file", path, false},
+		{"Missing file", filepath.Join(dir, "missing.json"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := New(tt.path)
+			err := a.Open()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Adapter.Open() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			a.Close()
+		})
+	}
+}
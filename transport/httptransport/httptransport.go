@@ -0,0 +1,197 @@
+//Package httptransport provides a transport receiving JSON metrics through HTTP POST requests.
+//
+//Requests contain a single metric object of any type, an array of them or NDJSON metrics. Every metric is validated and
+//buffered as an individual message. The endpoint replies 202 when the metrics are accepted, 400 when the payload is
+//invalid and 503 when the buffer has no room for the metrics. Accepted metrics can't be posted again, so the buffered
+//ones are flushed to the processor when it stops, see Adapter.Flush.
+package httptransport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	fworkerprocessor "github.com/ferrariframework/ferrariworker/processor"
+	"github.com/ottogiron/metricsworker/processor"
+	"github.com/ottogiron/metricsworker/transport"
+	"github.com/ottogiron/metricsworker/worker"
+)
+
+//Name transport name
+const Name = "http"
+
+//DefaultBufferSize default number of metrics buffered until the processor picks them up
+const DefaultBufferSize = 100
+
+const maxBodySize = 10 * 1024 * 1024
+
+//shutdownTimeout time the requests being served are given to finish when the adapter stops
+const shutdownTimeout = 5 * time.Second
+
+//ErrBufferFull returned when the buffer has no room for the posted metrics
+var ErrBufferFull = errors.New("buffer is full")
+
+func init() {
+	transport.Register(transport.Transport{
+		Name: Name,
+		Properties: []transport.Property{
+			{Name: "address", Type: transport.PropertyTypeString, Default: ":8080", Description: "HTTP address to listen on e.g. :8080"},
+			{Name: "path", Type: transport.PropertyTypeString, Default: "/metrics", Description: "HTTP path metrics are posted to"},
+			{Name: "buffer_size", Type: transport.PropertyTypeInt, Default: DefaultBufferSize, Description: "Number of metrics buffered until they are processed. Requests are rejected with 503 when the buffer is full"},
+		},
+		Factory: func(config transport.Config) (processor.Adapter, error) {
+			bufferSize, err := config.Int("buffer_size")
+			if err != nil {
+				return nil, err
+			}
+			return New(config.String("address"), config.String("path"), bufferSize), nil
+		},
+	})
+}
+
+var _ processor.Adapter = (*Adapter)(nil)
+var _ processor.Flusher = (*Adapter)(nil)
+
+//Adapter receives metrics posted to an HTTP endpoint
+type Adapter struct {
+	address  string
+	path     string
+	server   *http.Server
+	listener net.Listener
+	//guards the buffer room check so a batch is either fully buffered or rejected
+	mu       sync.Mutex
+	msgs     chan fworkerprocessor.Message
+	stopOnce sync.Once
+	stopErr  error
+}
+
+//New returns a new instance of an http adapter
+func New(address, path string, bufferSize int) *Adapter {
+	if bufferSize < 1 {
+		bufferSize = DefaultBufferSize
+	}
+	return &Adapter{
+		address: address,
+		path:    path,
+		msgs:    make(chan fworkerprocessor.Message, bufferSize),
+	}
+}
+
+//Open starts listening for metrics
+func (a *Adapter) Open() error {
+	listener, err := net.Listen("tcp", a.address)
+	if err != nil {
+		return fmt.Errorf("Failed to listen on %s %s", a.address, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(a.path, a)
+	a.listener = listener
+	a.server = &http.Server{Handler: mux}
+	go a.server.Serve(listener)
+	return nil
+}
+
+//Addr returns the address the adapter is listening on
+func (a *Adapter) Addr() net.Addr {
+	return a.listener.Addr()
+}
+
+//Messages returns the channel of posted metrics
+func (a *Adapter) Messages(ctx context.Context) (<-chan fworkerprocessor.Message, error) {
+	return a.msgs, nil
+}
+
+//ServeHTTP validates and buffers the posted metrics
+func (a *Adapter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read request body %s", err), http.StatusBadRequest)
+		return
+	}
+	metrics, err := decode(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = a.enqueue(metrics)
+	if err != nil {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+//enqueue buffers the metrics if there is room for all of them
+func (a *Adapter) enqueue(metrics [][]byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if cap(a.msgs)-len(a.msgs) < len(metrics) {
+		return ErrBufferFull
+	}
+	for _, metric := range metrics {
+		a.msgs <- transport.NewMessage(metric)
+	}
+	return nil
+}
+
+//decode splits a single metric, an array of metrics or NDJSON metrics in individual valid metric bodies
+func decode(body []byte) ([][]byte, error) {
+	raws, err := worker.SplitMetrics(body)
+	if err != nil {
+		return nil, err
+	}
+	for i, raw := range raws {
+		metric, err := worker.UnmarshallMetric(raw)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid metric at index %d %s", i, err)
+		}
+		err = metric.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("Invalid metric at index %d %s", i, err)
+		}
+	}
+	return raws, nil
+}
+
+//Flush stops the http server once the requests being served finished, and returns the metrics which were accepted
+//but not processed yet. The processor flushes the adapter once it stops reading messages
+func (a *Adapter) Flush() []fworkerprocessor.Message {
+	a.stop()
+	var flushed []fworkerprocessor.Message
+	for {
+		select {
+		case msg := <-a.msgs:
+			flushed = append(flushed, msg)
+		default:
+			return flushed
+		}
+	}
+}
+
+//stop shuts the http server down, waiting up to shutdownTimeout for the requests being served
+func (a *Adapter) stop() error {
+	if a.server == nil {
+		return nil
+	}
+	a.stopOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		a.stopErr = a.server.Shutdown(ctx)
+	})
+	return a.stopErr
+}
+
+//Close stops the http server
+func (a *Adapter) Close() error {
+	return a.stop()
+}
@@ -0,0 +1,145 @@
+package httptransport
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/streadway/amqp"
+)
+
+func newTestAdapter(t *testing.T, bufferSize int) (*Adapter, string) {
+	a := New("127.0.0.1:0", "/metrics", bufferSize)
+	if err := a.Open(); err != nil {
+		t.Fatalf("Adapter.Open() error = %v", err)
+	}
+	return a, "http://" + a.Addr().String() + "/metrics"
+}
+
+func post(t *testing.T, url string, payload string) int {
+	res, err := http.Post(url, "application/json", bytes.NewReader([]byte(payload)))
+	if err != nil {
+		t.Fatalf("Failed to post metrics %s", err)
+	}
+	res.Body.Close()
+	return res.StatusCode
+}
+
+func TestAdapter_ServeHTTP(t *testing.T) {
+	tests := []struct {
+		name         string
+		bufferSize   int
+		payload      string
+		wantStatus   int
+		wantMessages int
+	}{
+		{
+			"Single metric",
+			10,
+			`{"username": "kodingbot", "count": 1, "metric": "kite_call"}`,
+			http.StatusAccepted,
+			1,
+		},
+		{
+			"Batched metrics",
+			10,
+			`[{"username": "kodingbot", "count": 1, "metric": "kite_call"}, {"username": "koding", "count": 2, "metric": "kite_call"}]`,
+			http.StatusAccepted,
+			2,
+		},
+		{
+			"NDJSON metrics",
+			10,
+			"{\"username\": \"kodingbot\", \"count\": 1, \"metric\": \"kite_call\"}\n{\"username\": \"koding\", \"count\": 2, \"metric\": \"kite_call\"}\n",
+			http.StatusAccepted,
+			2,
+		},
+		{
+			"Invalid json",
+			10,
+			`{"username": "kodingbot"`,
+			http.StatusBadRequest,
+			0,
+		},
+		{
+			"Missing metric name",
+			10,
+			`[{"username": "kodingbot", "count": 1, "metric": "kite_call"}, {"username": "koding", "count": 2}]`,
+			http.StatusBadRequest,
+			0,
+		},
+		{
+			"Empty batch",
+			10,
+			`[]`,
+			http.StatusBadRequest,
+			0,
+		},
+		{
+			"Buffer full",
+			1,
+			`[{"username": "kodingbot", "count": 1, "metric": "kite_call"}, {"username": "koding", "count": 2, "metric": "kite_call"}]`,
+			http.StatusServiceUnavailable,
+			0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, url := newTestAdapter(t, tt.bufferSize)
+			defer a.Close()
+			if got := post(t, url, tt.payload); got != tt.wantStatus {
+				t.Errorf("Adapter.ServeHTTP() status = %d want %d", got, tt.wantStatus)
+			}
+			msgs, err := a.Messages(context.Background())
+			if err != nil {
+				t.Fatalf("Adapter.Messages() error = %v", err)
+			}
+			if got := len(msgs); got != tt.wantMessages {
+				t.Fatalf("Adapter.ServeHTTP() buffered messages = %d want %d", got, tt.wantMessages)
+			}
+			for i := 0; i < tt.wantMessages; i++ {
+				m := <-msgs
+				if _, ok := m.OriginalMessage.(amqp.Delivery); !ok {
+					t.Errorf("Adapter.ServeHTTP() original message should be an amqp delivery %v", m.OriginalMessage)
+				}
+			}
+		})
+	}
+}
+
+func TestAdapter_ServeHTTP_MethodNotAllowed(t *testing.T) {
+	a, url := newTestAdapter(t, 1)
+	defer a.Close()
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("Failed to get %s", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Adapter.ServeHTTP() status = %d want %d", res.StatusCode, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestAdapter_Flush(t *testing.T) {
+	a, url := newTestAdapter(t, 10)
+	if status := post(t, url, `[{"username": "kodingbot", "count": 1, "metric": "kite_call"}, {"username": "koding", "count": 2, "metric": "kite_call"}]`); status != http.StatusAccepted {
+		t.Fatalf("status = %d want %d", status, http.StatusAccepted)
+	}
+	var got []string
+	for _, msg := range a.Flush() {
+		got = append(got, string(msg.OriginalMessage.(amqp.Delivery).Body))
+	}
+	want := []string{`{"username": "kodingbot", "count": 1, "metric": "kite_call"}`, `{"username": "koding", "count": 2, "metric": "kite_call"}`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Adapter.Flush() = %v want %v", got, want)
+	}
+	//the server stopped accepting metrics
+	if _, err := http.Post(url, "application/json", bytes.NewReader([]byte(want[0]))); err == nil {
+		t.Errorf("the http server accepted metrics after Adapter.Flush()")
+	}
+	if err := a.Close(); err != nil {
+		t.Errorf("Adapter.Close() error = %v", err)
+	}
+}
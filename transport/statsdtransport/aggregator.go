@@ -0,0 +1,62 @@
+package statsdtransport
+
+import "github.com/ottogiron/metricsworker/worker"
+
+//seriesKey identifies the metrics aggregated together
+type seriesKey struct {
+	metricType worker.MetricType
+	username   string
+	key        string
+	member     string
+}
+
+//aggregator aggregates the counts, gauges and sets received in a flush interval
+type aggregator struct {
+	series map[seriesKey]worker.TypedMetric
+	//order of the first metric of every series, so metrics are flushed in the order they were received
+	order []seriesKey
+}
+
+func newAggregator() *aggregator {
+	return &aggregator{series: make(map[seriesKey]worker.TypedMetric)}
+}
+
+//add aggregates a metric, it returns false for histograms which are not aggregated
+func (a *aggregator) add(metric worker.TypedMetric) bool {
+	id := metric.Identity()
+	k := seriesKey{metricType: metric.MetricType(), username: id.UserName, key: id.SeriesKey()}
+	switch m := metric.(type) {
+	case *worker.HistogramMetric:
+		return false
+	case *worker.SetMetric:
+		//every distinct member is flushed once
+		k.member = m.Member
+	}
+	current, ok := a.series[k]
+	if !ok {
+		a.series[k] = metric
+		a.order = append(a.order, k)
+		return true
+	}
+	switch m := metric.(type) {
+	case *worker.CountMetric:
+		current.(*worker.CountMetric).Count += m.Count
+	case *worker.GaugeMetric:
+		//deltas are added to the last value or delta of the interval, absolute values replace it
+		if m.Delta {
+			current.(*worker.GaugeMetric).Value += m.Value
+			break
+		}
+		a.series[k] = m
+	}
+	return true
+}
+
+//metrics returns the aggregated metrics in the order their series were first received
+func (a *aggregator) metrics() []worker.TypedMetric {
+	metrics := make([]worker.TypedMetric, 0, len(a.order))
+	for _, k := range a.order {
+		metrics = append(metrics, a.series[k])
+	}
+	return metrics
+}
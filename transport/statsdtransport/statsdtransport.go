@@ -0,0 +1,341 @@
+//Package statsdtransport provides a transport receiving StatsD lines through UDP or Unix datagram sockets.
+//
+//Every datagram contains one or more newline delimited StatsD lines, see worker.ParseStatsD. Gauge deltas are kept
+//as deltas, they are added to the stored value of the gauge by the workers. Every valid metric is
+//buffered as an individual JSON message. Datagrams can't be rejected, so lines which can't be parsed and metrics which
+//don't fit in the buffer are dropped and counted, see Adapter.Stats. Metrics are optionally pre-aggregated over a flush
+//interval to cut the number of messages processed.
+package statsdtransport
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	fworkerprocessor "github.com/ferrariframework/ferrariworker/processor"
+	"github.com/ottogiron/metricsworker/processor"
+	"github.com/ottogiron/metricsworker/transport"
+	"github.com/ottogiron/metricsworker/worker"
+)
+
+//Name transport name
+const Name = "statsd"
+
+//DefaultBufferSize default number of metrics buffered until the processor picks them up
+const DefaultBufferSize = 1000
+
+//maxDatagramSize maximum size of a datagram read from the socket
+const maxDatagramSize = 65535
+
+func init() {
+	transport.Register(transport.Transport{
+		Name: Name,
+		Properties: []transport.Property{
+			{Name: "network", Type: transport.PropertyTypeString, Default: "udp", Description: "Network to listen on - udp|unixgram"},
+			{Name: "address", Type: transport.PropertyTypeString, Default: ":8125", Description: "Address to listen on e.g. :8125, or the socket path of unixgram"},
+			{Name: "buffer_size", Type: transport.PropertyTypeInt, Default: DefaultBufferSize, Description: "Number of metrics buffered until they are processed. Metrics are dropped when the buffer is full"},
+			{Name: "flush_interval", Type: transport.PropertyTypeString, Default: "0s", Description: "Interval metrics are pre-aggregated over e.g. 10s. Disabled if 0"},
+			{Name: "default_username", Type: transport.PropertyTypeString, Default: "", Description: "Username of the metrics without username tag. Metrics without username are dropped if empty"},
+		},
+		Factory: func(config transport.Config) (processor.Adapter, error) {
+			bufferSize, err := config.Int("buffer_size")
+			if err != nil {
+				return nil, err
+			}
+			flushInterval, err := time.ParseDuration(config.String("flush_interval"))
+			if err != nil {
+				return nil, fmt.Errorf("Property flush_interval should be a duration %s", err)
+			}
+			return New(config.String("network"), config.String("address"),
+				SetBufferSize(bufferSize),
+				SetFlushInterval(flushInterval),
+				SetDefaultUserName(config.String("default_username")),
+			), nil
+		},
+	})
+}
+
+var _ processor.Adapter = (*Adapter)(nil)
+var _ processor.Flusher = (*Adapter)(nil)
+
+//Stats counters of the received datagrams and metrics
+type Stats struct {
+	//Datagrams read from the socket
+	Datagrams uint64 `json:"datagrams"`
+	//Valid metrics parsed from the datagrams
+	Metrics uint64 `json:"metrics"`
+	//Lines which could not be parsed as valid metrics
+	ParseErrors uint64 `json:"parse_errors"`
+	//Metrics dropped because the buffer was full
+	Dropped uint64 `json:"dropped"`
+}
+
+//Option a functional option for the adapter
+type Option func(*Adapter)
+
+//SetBufferSize sets the number of metrics buffered until the processor picks them up
+func SetBufferSize(size int) Option {
+	return func(a *Adapter) {
+		if size > 0 {
+			a.bufferSize = size
+		}
+	}
+}
+
+//SetFlushInterval pre-aggregates the metrics over an interval: counts are added up, the last value of gauges is
+//kept, gauge deltas are added to the last value or delta of the interval and set members are deduplicated.
+//Histogram values are buffered as they are received, since their stored aggregates need every value. An interval
+//of 0 disables the pre-aggregation
+func SetFlushInterval(interval time.Duration) Option {
+	return func(a *Adapter) {
+		a.flushInterval = interval
+	}
+}
+
+//SetDefaultUserName sets the username of the metrics without username tag, they are counted as parse errors otherwise
+func SetDefaultUserName(username string) Option {
+	return func(a *Adapter) {
+		a.parser.DefaultUserName = username
+	}
+}
+
+//Adapter receives StatsD metrics from a datagram socket
+type Adapter struct {
+	//counters, see Stats. They are first so they are 64-bit aligned for the atomic operations
+	datagrams   uint64
+	metrics     uint64
+	parseErrors uint64
+	dropped     uint64
+
+	network       string
+	address       string
+	bufferSize    int
+	flushInterval time.Duration
+	parser        worker.StatsDParser
+
+	conn net.PacketConn
+	msgs chan fworkerprocessor.Message
+	//metrics pre-aggregated until the next flush
+	mu         sync.Mutex
+	aggregator *aggregator
+	done       chan struct{}
+	wg         sync.WaitGroup
+	stopOnce   sync.Once
+	stopErr    error
+}
+
+//New returns a new instance of a StatsD adapter listening on a udp or unixgram address
+func New(network, address string, options ...Option) *Adapter {
+	a := &Adapter{
+		network:    network,
+		address:    address,
+		bufferSize: DefaultBufferSize,
+	}
+	for _, option := range options {
+		option(a)
+	}
+	a.msgs = make(chan fworkerprocessor.Message, a.bufferSize)
+	return a
+}
+
+//Open starts listening for metrics
+func (a *Adapter) Open() error {
+	switch a.network {
+	case "udp", "udp4", "udp6":
+	case "unixgram":
+		//a socket file left by a previous run would make the listen fail
+		if info, err := os.Stat(a.address); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(a.address)
+		}
+	default:
+		return fmt.Errorf("Unsupported StatsD network %s", a.network)
+	}
+	conn, err := net.ListenPacket(a.network, a.address)
+	if err != nil {
+		return fmt.Errorf("Failed to listen on %s %s %s", a.network, a.address, err)
+	}
+	a.conn = conn
+	a.done = make(chan struct{})
+	if a.flushInterval > 0 {
+		a.aggregator = newAggregator()
+		a.wg.Add(1)
+		go a.flushEvery(a.flushInterval)
+	}
+	a.wg.Add(1)
+	go a.read()
+	return nil
+}
+
+//Addr returns the address the adapter is listening on
+func (a *Adapter) Addr() net.Addr {
+	return a.conn.LocalAddr()
+}
+
+//Messages returns the channel of received metrics
+func (a *Adapter) Messages(ctx context.Context) (<-chan fworkerprocessor.Message, error) {
+	return a.msgs, nil
+}
+
+//Stats returns the counters of the received datagrams and metrics
+func (a *Adapter) Stats() Stats {
+	return Stats{
+		Datagrams:   atomic.LoadUint64(&a.datagrams),
+		Metrics:     atomic.LoadUint64(&a.metrics),
+		ParseErrors: atomic.LoadUint64(&a.parseErrors),
+		Dropped:     atomic.LoadUint64(&a.dropped),
+	}
+}
+
+//read reads datagrams until the connection is closed
+func (a *Adapter) read() {
+	defer a.wg.Done()
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, _, err := a.conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-a.done:
+				return
+			default:
+			}
+			//transient errors e.g. a datagram larger than the buffer
+			continue
+		}
+		atomic.AddUint64(&a.datagrams, 1)
+		a.receive(buf[:n])
+	}
+}
+
+//receive parses the lines of a datagram and buffers or aggregates their metrics
+func (a *Adapter) receive(datagram []byte) {
+	for _, line := range bytes.Split(datagram, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		metric, err := a.parser.Parse(string(line))
+		if err == nil {
+			err = metric.Validate()
+		}
+		if err != nil {
+			atomic.AddUint64(&a.parseErrors, 1)
+			continue
+		}
+		atomic.AddUint64(&a.metrics, 1)
+		if a.flushInterval > 0 && a.aggregate(metric) {
+			continue
+		}
+		a.enqueue(metric)
+	}
+}
+
+//aggregate adds a metric to the aggregates of the current interval, it returns false if the metric is not aggregated
+func (a *Adapter) aggregate(metric worker.TypedMetric) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.aggregator.add(metric)
+}
+
+//enqueue buffers a metric as a JSON message, it is dropped if the buffer is full
+func (a *Adapter) enqueue(metric worker.TypedMetric) {
+	body, err := worker.MarshallMetric(metric)
+	if err != nil {
+		atomic.AddUint64(&a.parseErrors, 1)
+		return
+	}
+	select {
+	case a.msgs <- transport.NewMessage(body):
+	default:
+		atomic.AddUint64(&a.dropped, 1)
+	}
+}
+
+//flushEvery buffers the aggregated metrics every interval until the adapter is closed
+func (a *Adapter) flushEvery(interval time.Duration) {
+	defer a.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.flush()
+		case <-a.done:
+			return
+		}
+	}
+}
+
+//flush buffers the aggregated metrics and starts a new interval
+func (a *Adapter) flush() {
+	for _, metric := range a.aggregates() {
+		a.enqueue(metric)
+	}
+}
+
+//aggregates returns the aggregated metrics and starts a new interval
+func (a *Adapter) aggregates() []worker.TypedMetric {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.aggregator == nil {
+		return nil
+	}
+	metrics := a.aggregator.metrics()
+	a.aggregator = newAggregator()
+	return metrics
+}
+
+//FlushInterval returns the interval metrics are pre-aggregated over
+func (a *Adapter) FlushInterval() time.Duration {
+	return a.flushInterval
+}
+
+//Flush stops listening and returns the metrics which were not processed yet: the buffered ones and the aggregates
+//of the current interval. The processor flushes the adapter once it stops reading messages
+func (a *Adapter) Flush() []fworkerprocessor.Message {
+	a.stop()
+	var flushed []fworkerprocessor.Message
+	for {
+		select {
+		case msg := <-a.msgs:
+			flushed = append(flushed, msg)
+		default:
+			for _, metric := range a.aggregates() {
+				body, err := worker.MarshallMetric(metric)
+				if err != nil {
+					atomic.AddUint64(&a.parseErrors, 1)
+					continue
+				}
+				flushed = append(flushed, transport.NewMessage(body))
+			}
+			return flushed
+		}
+	}
+}
+
+//stop stops reading datagrams and removes the socket file of unixgram sockets
+func (a *Adapter) stop() error {
+	a.stopOnce.Do(func() {
+		close(a.done)
+		a.stopErr = a.conn.Close()
+		a.wg.Wait()
+		if a.network == "unixgram" {
+			os.Remove(a.address)
+		}
+	})
+	return a.stopErr
+}
+
+//Close stops listening. Aggregated metrics which were not flushed yet are flushed to the buffer
+func (a *Adapter) Close() error {
+	if a.conn == nil {
+		return nil
+	}
+	err := a.stop()
+	a.flush()
+	return err
+}
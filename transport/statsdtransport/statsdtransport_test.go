@@ -0,0 +1,249 @@
+package statsdtransport
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+//send writes datagrams to the adapter and waits until they are read
+func send(t *testing.T, a *Adapter, datagrams []string) {
+	conn, err := net.Dial(a.Addr().Network(), a.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial %s %s", a.Addr(), err)
+	}
+	defer conn.Close()
+	for _, datagram := range datagrams {
+		if _, err := conn.Write([]byte(datagram)); err != nil {
+			t.Fatalf("Failed to write datagram %s", err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for a.Stats().Datagrams < uint64(len(datagrams)) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Adapter read %d of %d datagrams", a.Stats().Datagrams, len(datagrams))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+//bodies returns the bodies of the buffered messages
+func bodies(t *testing.T, a *Adapter) []string {
+	msgs, err := a.Messages(context.Background())
+	if err != nil {
+		t.Fatalf("Adapter.Messages() error = %v", err)
+	}
+	var got []string
+	for {
+		select {
+		case msg := <-msgs:
+			delivery := msg.OriginalMessage.(amqp.Delivery)
+			if delivery.ContentType != "application/json" {
+				t.Errorf("Message content type = %s want application/json", delivery.ContentType)
+			}
+			got = append(got, string(delivery.Body))
+		default:
+			return got
+		}
+	}
+}
+
+func TestAdapter_Receive(t *testing.T) {
+	tests := []struct {
+		name        string
+		options     []Option
+		datagrams   []string
+		want        []string
+		wantStats   Stats
+		wantFlushed bool
+	}{
+		{
+			"Metrics",
+			nil,
+			[]string{
+				"kite_call:1|c|#username:kodingbot\nconnections:3|g|#username:kodingbot",
+				"kite_call_ms:230|ms|#username:koding,region:eu\n",
+			},
+			[]string{
+				`{"username":"kodingbot","count":1,"metric":"kite_call"}`,
+				`{"type":"gauge","username":"kodingbot","metric":"connections","value":3}`,
+				`{"type":"histogram","username":"koding","metric":"kite_call_ms","tags":{"region":"eu"},"value":230}`,
+			},
+			Stats{Datagrams: 2, Metrics: 3},
+			false,
+		},
+		{
+			"Parse errors",
+			nil,
+			[]string{"kite_call:1|c|#username:kodingbot\nkite_call:one|c|#username:kodingbot\nkite_call:1|c"},
+			[]string{`{"username":"kodingbot","count":1,"metric":"kite_call"}`},
+			Stats{Datagrams: 1, Metrics: 1, ParseErrors: 2},
+			false,
+		},
+		{
+			"Buffer full",
+			[]Option{SetBufferSize(1)},
+			[]string{"kite_call:1|c|#username:kodingbot\nkite_call:2|c|#username:koding"},
+			[]string{`{"username":"kodingbot","count":1,"metric":"kite_call"}`},
+			Stats{Datagrams: 1, Metrics: 2, Dropped: 1},
+			false,
+		},
+		{
+			"Gauge deltas and decrements",
+			nil,
+			[]string{"connections:-1|g|#username:kodingbot\nconnections:3|g|#username:kodingbot\nconnections:+2|g|#username:kodingbot\nkite_call:-1|c|#username:kodingbot"},
+			[]string{
+				`{"type":"gauge","username":"kodingbot","metric":"connections","value":-1,"delta":true}`,
+				`{"type":"gauge","username":"kodingbot","metric":"connections","value":3}`,
+				`{"type":"gauge","username":"kodingbot","metric":"connections","value":2,"delta":true}`,
+				`{"username":"kodingbot","count":-1,"metric":"kite_call"}`,
+			},
+			Stats{Datagrams: 1, Metrics: 4},
+			false,
+		},
+		{
+			"Default username",
+			[]Option{SetDefaultUserName("koding")},
+			[]string{"kite_call:1|c\nkite_call:1|c|#username:kodingbot"},
+			[]string{
+				`{"username":"koding","count":1,"metric":"kite_call"}`,
+				`{"username":"kodingbot","count":1,"metric":"kite_call"}`,
+			},
+			Stats{Datagrams: 1, Metrics: 2},
+			false,
+		},
+		{
+			"Pre-aggregation",
+			[]Option{SetFlushInterval(time.Hour)},
+			[]string{
+				"kite_call:1|c|#username:kodingbot\nconnections:3|g|#username:kodingbot\nvisitors:v1|s|#username:kodingbot",
+				"kite_call:2|c|#username:kodingbot\nconnections:5|g|#username:kodingbot\nvisitors:v1|s|#username:kodingbot",
+				"kite_call:4|c|#username:kodingbot,region:eu\nvisitors:v2|s|#username:kodingbot\nkite_call_ms:230|ms|#username:kodingbot",
+			},
+			[]string{
+				`{"type":"histogram","username":"kodingbot","metric":"kite_call_ms","value":230}`,
+				`{"username":"kodingbot","count":3,"metric":"kite_call"}`,
+				`{"type":"gauge","username":"kodingbot","metric":"connections","value":5}`,
+				`{"type":"set","username":"kodingbot","metric":"visitors","member":"v1"}`,
+				`{"username":"kodingbot","count":4,"metric":"kite_call","tags":{"region":"eu"}}`,
+				`{"type":"set","username":"kodingbot","metric":"visitors","member":"v2"}`,
+			},
+			Stats{Datagrams: 3, Metrics: 9},
+			true,
+		},
+		{
+			"Pre-aggregated gauge deltas",
+			[]Option{SetFlushInterval(time.Hour)},
+			[]string{
+				"connections:+2|g|#username:kodingbot\nconnections:-1|g|#username:kodingbot\nusers:+1|g|#username:kodingbot",
+				"users:4|g|#username:kodingbot\nusers:+2|g|#username:kodingbot",
+			},
+			[]string{
+				`{"type":"gauge","username":"kodingbot","metric":"connections","value":1,"delta":true}`,
+				`{"type":"gauge","username":"kodingbot","metric":"users","value":6}`,
+			},
+			Stats{Datagrams: 2, Metrics: 5},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := New("udp", "127.0.0.1:0", tt.options...)
+			if err := a.Open(); err != nil {
+				t.Fatalf("Adapter.Open() error = %v", err)
+			}
+			send(t, a, tt.datagrams)
+			//histograms are buffered as they are received, the aggregates when the adapter is closed
+			got := bodies(t, a)
+			if err := a.Close(); err != nil {
+				t.Fatalf("Adapter.Close() error = %v", err)
+			}
+			flushed := bodies(t, a)
+			if tt.wantFlushed != (len(flushed) > 0) {
+				t.Errorf("Adapter.Close() flushed %d metrics", len(flushed))
+			}
+			got = append(got, flushed...)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Adapter messages = %v want %v", got, tt.want)
+			}
+			if stats := a.Stats(); stats != tt.wantStats {
+				t.Errorf("Adapter.Stats() = %+v want %+v", stats, tt.wantStats)
+			}
+		})
+	}
+}
+
+func TestAdapter_Flush(t *testing.T) {
+	a := New("udp", "127.0.0.1:0", SetFlushInterval(time.Hour), SetBufferSize(1))
+	if err := a.Open(); err != nil {
+		t.Fatalf("Adapter.Open() error = %v", err)
+	}
+	send(t, a, []string{"kite_call_ms:230|ms|#username:kodingbot\nkite_call:1|c|#username:kodingbot\nkite_call:2|c|#username:kodingbot"})
+	//the aggregates are returned even though the buffer is full
+	var got []string
+	for _, msg := range a.Flush() {
+		got = append(got, string(msg.OriginalMessage.(amqp.Delivery).Body))
+	}
+	want := []string{
+		`{"type":"histogram","username":"kodingbot","metric":"kite_call_ms","value":230}`,
+		`{"username":"kodingbot","count":3,"metric":"kite_call"}`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Adapter.Flush() = %v want %v", got, want)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatalf("Adapter.Close() error = %v", err)
+	}
+	if flushed := bodies(t, a); len(flushed) > 0 {
+		t.Errorf("Adapter.Close() flushed %v after the adapter was flushed", flushed)
+	}
+	if stats := a.Stats(); stats.Dropped != 0 {
+		t.Errorf("Adapter.Stats() dropped = %d want 0", stats.Dropped)
+	}
+}
+
+func TestAdapter_Unixgram(t *testing.T) {
+	dir, err := ioutil.TempDir("", "statsdtransport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "statsd.sock")
+
+	//closing a unixgram socket leaves its file behind, as a previous run would
+	stale, err := net.ListenPacket("unixgram", socket)
+	if err != nil {
+		t.Fatalf("Failed to listen on %s %s", socket, err)
+	}
+	stale.Close()
+	a := New("unixgram", socket)
+	if err := a.Open(); err != nil {
+		t.Fatalf("Adapter.Open() error = %v", err)
+	}
+	send(t, a, []string{"kite_call:1|c|#username:kodingbot"})
+	want := []string{`{"username":"kodingbot","count":1,"metric":"kite_call"}`}
+	if got := bodies(t, a); !reflect.DeepEqual(got, want) {
+		t.Errorf("Adapter messages = %v want %v", got, want)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatalf("Adapter.Close() error = %v", err)
+	}
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("Adapter.Close() left socket file %s", socket)
+	}
+}
+
+func TestAdapter_Open(t *testing.T) {
+	a := New("tcp", "127.0.0.1:0")
+	if err := a.Open(); err == nil {
+		a.Close()
+		t.Errorf("Adapter.Open() listened on tcp")
+	}
+}
@@ -0,0 +1,177 @@
+//Package transport provides a registry of the message transports the processor can consume from.
+//Transports are either maintained in this repository or provided by ferrariworker adapters.
+package transport
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	fworkerprocessor "github.com/ferrariframework/ferrariworker/processor"
+	"github.com/ottogiron/metricsworker/processor"
+	"github.com/streadway/amqp"
+)
+
+//PropertyType type of a transport configuration property
+type PropertyType string
+
+//Available property types
+const (
+	PropertyTypeString PropertyType = "string"
+	PropertyTypeInt    PropertyType = "int"
+	PropertyTypeBool   PropertyType = "bool"
+)
+
+//Property describes a transport configuration property
+type Property struct {
+	Name        string
+	Type        PropertyType
+	Default     interface{}
+	Description string
+}
+
+//Config transport configuration values by property name
+type Config map[string]string
+
+//String returns a property value
+func (c Config) String(name string) string {
+	return c[name]
+}
+
+//Int returns a property value as an int
+func (c Config) Int(name string) (int, error) {
+	value, err := strconv.Atoi(c[name])
+	if err != nil {
+		return 0, fmt.Errorf("Property %s should be an int %s", name, err)
+	}
+	return value, nil
+}
+
+//Bool returns a property value as a bool
+func (c Config) Bool(name string) (bool, error) {
+	value, err := strconv.ParseBool(c[name])
+	if err != nil {
+		return false, fmt.Errorf("Property %s should be a bool %s", name, err)
+	}
+	return value, nil
+}
+
+//Factory creates a new transport adapter from its configuration
+type Factory func(config Config) (processor.Adapter, error)
+
+//Transport describes a registered transport
+type Transport struct {
+	Name       string
+	Properties []Property
+	Factory    Factory
+}
+
+var (
+	mu         sync.RWMutex
+	transports = make(map[string]Transport)
+)
+
+//Register registers a transport. Registering a transport with the same name twice panics
+func Register(t Transport) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := transports[t.Name]; ok {
+		panic(fmt.Sprintf("transport %s already registered", t.Name))
+	}
+	transports[t.Name] = t
+}
+
+//Names returns the names of the transports registered in this repository
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(transports))
+	for name := range transports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//FerrariNames returns the names of the adapters registered in the ferrariworker registry, adapters are registered by
+//blank importing their packages. Adapters with the name of a transport registered in this repository are not included
+func FerrariNames() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	var names []string
+	for _, name := range fworkerprocessor.AvailableAdapters() {
+		if _, ok := transports[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+//Lookup returns a transport by name. Transports registered in this repository take precedence over
+//ferrariworker adapters with the same name
+func Lookup(name string) (Transport, error) {
+	mu.RLock()
+	t, ok := transports[name]
+	mu.RUnlock()
+	if ok {
+		return t, nil
+	}
+	return ferrariTransport(name)
+}
+
+//ferrariTransport wraps a ferrariworker adapter as a transport
+func ferrariTransport(name string) (Transport, error) {
+	schema, err := fworkerprocessor.AdapterSchema(name)
+	if err != nil {
+		return Transport{}, fmt.Errorf("Transport %s not found %s", name, err)
+	}
+	properties := make([]Property, 0, len(schema.Properties))
+	for _, property := range schema.Properties {
+		var propertyType PropertyType
+		switch property.Type {
+		case fworkerprocessor.PropertyTypeString:
+			propertyType = PropertyTypeString
+		case fworkerprocessor.PropertyTypeInt:
+			propertyType = PropertyTypeInt
+		case fworkerprocessor.PropertyTypeBool:
+			propertyType = PropertyTypeBool
+		default:
+			continue
+		}
+		properties = append(properties, Property{
+			Name:        property.Name,
+			Type:        propertyType,
+			Default:     property.Default,
+			Description: property.Description,
+		})
+	}
+	return Transport{
+		Name:       name,
+		Properties: properties,
+		Factory: func(config Config) (processor.Adapter, error) {
+			factory, err := fworkerprocessor.AdapterFactory(name)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to load adapter factory for %s %s", name, err)
+			}
+			adapterConfig := fworkerprocessor.NewAdapterConfig()
+			for key, value := range config {
+				adapterConfig.Set(key, value)
+			}
+			return factory.New(adapterConfig), nil
+		},
+	}, nil
+}
+
+//NewMessage returns a processor message for a metric body. The body is wrapped in an amqp delivery
+//so the same workers process metrics from any transport
+func NewMessage(body []byte) fworkerprocessor.Message {
+	delivery := amqp.Delivery{
+		ContentType: "application/json",
+		Body:        body,
+		Timestamp:   time.Now().UTC(),
+	}
+	return fworkerprocessor.Message{Payload: body, OriginalMessage: delivery}
+}
@@ -0,0 +1,62 @@
+package transport
+
+import (
+	"reflect"
+	"testing"
+
+	_ "github.com/ferrariframework/ferrariworker/processor/rabbit"
+	"github.com/ottogiron/metricsworker/processor"
+)
+
+func init() {
+	Register(Transport{
+		Name: "test-transport",
+		Factory: func(config Config) (processor.Adapter, error) {
+			return nil, nil
+		},
+	})
+}
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{"test-transport", false},
+		{"missing-transport", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Lookup(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Lookup() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && got.Name != tt.name {
+				t.Errorf("Lookup() = %s want %s", got.Name, tt.name)
+			}
+		})
+	}
+}
+
+func TestFerrariNames(t *testing.T) {
+	if got := FerrariNames(); !reflect.DeepEqual(got, []string{"rabbit"}) {
+		t.Errorf("FerrariNames() = %v want the imported rabbit adapter", got)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	config := Config{"size": "10", "enabled": "true", "name": "metrics", "invalid": "x"}
+	if got := config.String("name"); got != "metrics" {
+		t.Errorf("Config.String() = %s want metrics", got)
+	}
+	if got, err := config.Int("size"); err != nil || got != 10 {
+		t.Errorf("Config.Int() = %d, %v want 10", got, err)
+	}
+	if got, err := config.Bool("enabled"); err != nil || !got {
+		t.Errorf("Config.Bool() = %v, %v want true", got, err)
+	}
+	if _, err := config.Int("invalid"); err == nil {
+		t.Errorf("Config.Int() expected error for invalid value")
+	}
+}
@@ -0,0 +1,91 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+//EventTimeHeader delivery header carrying the time a replayed metric was originally received. Workers use it as the
+//time of the metric instead of the delivery timestamp, which is the time it was replayed
+const EventTimeHeader = "x-event-time"
+
+//EventTime returns the time the metric of a delivery was received: the EventTimeHeader of replayed deliveries, or the
+//delivery timestamp otherwise. replayed is true if the time was read from the header
+func EventTime(delivery amqp.Delivery) (t time.Time, replayed bool) {
+	switch value := delivery.Headers[EventTimeHeader].(type) {
+	case time.Time:
+		return value, true
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			return t, true
+		}
+	}
+	return delivery.Timestamp, false
+}
+
+//ArchiveRecord represents an archived delivery as it was received, it is stored as a line of newline delimited JSON.
+//Plain JSON bodies are stored in Body, any other body (compressed, another content type or invalid JSON) is stored
+//base64 encoded in Data with its content type and encoding so it is decoded again when it is replayed
+type ArchiveRecord struct {
+	ReceivedAt      time.Time       `json:"received_at"`
+	RoutingKey      string          `json:"routing_key,omitempty"`
+	MessageID       string          `json:"message_id,omitempty"`
+	ContentType     string          `json:"content_type,omitempty"`
+	ContentEncoding string          `json:"content_encoding,omitempty"`
+	Body            json.RawMessage `json:"body,omitempty"`
+	Data            []byte          `json:"data,omitempty"`
+}
+
+//NewArchiveRecord returns the archive record of a delivery received at receivedAt
+func NewArchiveRecord(delivery amqp.Delivery, receivedAt time.Time) ArchiveRecord {
+	record := ArchiveRecord{
+		ReceivedAt:      receivedAt,
+		RoutingKey:      delivery.RoutingKey,
+		MessageID:       delivery.MessageId,
+		ContentType:     delivery.ContentType,
+		ContentEncoding: delivery.ContentEncoding,
+	}
+	var body json.RawMessage
+	if IsPlainJSON(delivery.ContentType, delivery.ContentEncoding, delivery.Body) && json.Unmarshal(delivery.Body, &body) == nil {
+		record.Body = body
+	} else {
+		record.Data = delivery.Body
+	}
+	return record
+}
+
+//Delivery returns the archived delivery, its metadata and body as they were received
+func (r *ArchiveRecord) Delivery() amqp.Delivery {
+	body := []byte(r.Body)
+	if len(r.Data) > 0 {
+		body = r.Data
+	}
+	return amqp.Delivery{
+		RoutingKey:      r.RoutingKey,
+		MessageId:       r.MessageID,
+		ContentType:     r.ContentType,
+		ContentEncoding: r.ContentEncoding,
+		Body:            body,
+	}
+}
+
+//UnmarshallArchiveRecord unmarshalls an array of bytes to an ArchiveRecord. ok is false if the bytes are valid JSON
+//but not an archive record, e.g. a plain CountMetric or an array of metrics
+func UnmarshallArchiveRecord(line []byte) (record *ArchiveRecord, ok bool, err error) {
+	if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 && trimmed[0] == '[' {
+		var raws []json.RawMessage
+		return nil, false, json.Unmarshal(trimmed, &raws)
+	}
+	var r ArchiveRecord
+	err = json.Unmarshal(line, &r)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(r.Body) == 0 && len(r.Data) == 0 {
+		return nil, false, nil
+	}
+	return &r, true, nil
+}
@@ -0,0 +1,71 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+//SplitMetrics splits a body in the bodies of the metrics it contains. Bodies are either a single metric object,
+//a JSON array of metric objects or newline delimited metric objects (NDJSON)
+func SplitMetrics(body []byte) ([][]byte, error) {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 {
+		return nil, errors.New("Failed to parse metrics empty body")
+	}
+	if trimmed[0] == '[' {
+		var raws []json.RawMessage
+		err := json.Unmarshal(trimmed, &raws)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse metrics array %s", err)
+		}
+		if len(raws) == 0 {
+			return nil, errors.New("Failed to parse metrics empty array")
+		}
+		bodies := make([][]byte, 0, len(raws))
+		for i, raw := range raws {
+			raw = bytes.TrimSpace(raw)
+			if len(raw) == 0 || raw[0] != '{' {
+				return nil, fmt.Errorf("Metric at index %d should be an object", i)
+			}
+			bodies = append(bodies, raw)
+		}
+		return bodies, nil
+	}
+	//a single object may span several lines, so objects are decoded one after the other instead of split by line
+	var bodies [][]byte
+	decoder := json.NewDecoder(bytes.NewReader(trimmed))
+	for {
+		var raw json.RawMessage
+		err := decoder.Decode(&raw)
+		if err == io.EOF {
+			return bodies, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse metric at index %d %s", len(bodies), err)
+		}
+		if raw[0] != '{' {
+			return nil, fmt.Errorf("Metric at index %d should be an object", len(bodies))
+		}
+		bodies = append(bodies, raw)
+	}
+}
+
+//UnmarshallMetrics unmarshalls the metrics of a single, array or NDJSON body, see SplitMetrics and UnmarshallMetric
+func UnmarshallMetrics(body []byte) ([]TypedMetric, error) {
+	bodies, err := SplitMetrics(body)
+	if err != nil {
+		return nil, err
+	}
+	metrics := make([]TypedMetric, 0, len(bodies))
+	for i, b := range bodies {
+		metric, err := UnmarshallMetric(b)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid metric at index %d %s", i, err)
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics, nil
+}
@@ -0,0 +1,75 @@
+package worker
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitMetrics(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    []string
+		wantErr bool
+	}{
+		{
+			"Single metric",
+			`{"username": "kodingbot", "count": 1, "metric": "kite_call"}`,
+			[]string{`{"username": "kodingbot", "count": 1, "metric": "kite_call"}`},
+			false,
+		},
+		{
+			"Single metric spanning lines",
+			"{\n  \"username\": \"kodingbot\",\n  \"count\": 1\n}\n",
+			[]string{"{\n  \"username\": \"kodingbot\",\n  \"count\": 1\n}"},
+			false,
+		},
+		{
+			"Array",
+			`[{"username": "kodingbot", "count": 1}, {"username": "koding", "count": 2}]`,
+			[]string{`{"username": "kodingbot", "count": 1}`, `{"username": "koding", "count": 2}`},
+			false,
+		},
+		{
+			"NDJSON",
+			"{\"username\": \"kodingbot\", \"count\": 1}\n\n{\"username\": \"koding\", \"count\": 2}\n",
+			[]string{`{"username": "kodingbot", "count": 1}`, `{"username": "koding", "count": 2}`},
+			false,
+		},
+		{"Empty body", " ", nil, true},
+		{"Empty array", `[]`, nil, true},
+		{"Array of numbers", `[1, 2]`, nil, true},
+		{"Invalid array", `[{"username": "kodingbot"}`, nil, true},
+		{"Invalid NDJSON line", "{\"username\": \"kodingbot\"}\n{\"username\": \n", nil, true},
+		{"NDJSON string", "{\"username\": \"kodingbot\"}\n\"kodingbot\"\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SplitMetrics([]byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SplitMetrics() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var bodies []string
+			for _, body := range got {
+				bodies = append(bodies, string(body))
+			}
+			if !reflect.DeepEqual(bodies, tt.want) {
+				t.Errorf("SplitMetrics() = %q want %q", bodies, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnmarshallMetrics(t *testing.T) {
+	metrics, err := UnmarshallMetrics([]byte(`[{"username": "kodingbot", "count": 1, "metric": "kite_call"}, {"type": "gauge", "username": "kodingbot", "metric": "connections", "value": 3}]`))
+	if err != nil {
+		t.Fatalf("UnmarshallMetrics() error = %v", err)
+	}
+	if len(metrics) != 2 || metrics[0].MetricType() != MetricTypeCount || metrics[1].MetricType() != MetricTypeGauge {
+		t.Errorf("UnmarshallMetrics() = %v want a count and a gauge", metrics)
+	}
+	_, err = UnmarshallMetrics([]byte(`[{"username": "kodingbot", "count": 1, "metric": "kite_call"}, {"type": "summary"}]`))
+	if err == nil {
+		t.Error("UnmarshallMetrics() error = nil want an unknown type error")
+	}
+}
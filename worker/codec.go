@@ -0,0 +1,312 @@
+package worker
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"mime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/streadway/amqp"
+)
+
+//Content types of the registered codecs
+const (
+	ContentTypeJSON        = "application/json"
+	ContentTypeMessagePack = "application/msgpack"
+	ContentTypeProtobuf    = "application/protobuf"
+	ContentTypeStatsD      = "text/x-statsd"
+)
+
+//DefaultMaxDecompressedSize default maximum size in bytes compressed bodies are decompressed to
+const DefaultMaxDecompressedSize = 64 << 20
+
+//maxDecompressedSize see SetMaxDecompressedSize
+var maxDecompressedSize int64 = DefaultMaxDecompressedSize
+
+//SetMaxDecompressedSize sets the maximum size in bytes compressed bodies are decompressed to, bodies which are larger
+//once decompressed fail to decode, so a small compressed body can't exhaust the memory. 0 or less sets the default
+func SetMaxDecompressedSize(size int64) {
+	if size <= 0 {
+		size = DefaultMaxDecompressedSize
+	}
+	atomic.StoreInt64(&maxDecompressedSize, size)
+}
+
+//Codec decodes the message bodies of a content type to metrics
+type Codec interface {
+	Decode(body []byte) ([]TypedMetric, error)
+}
+
+//CodecFunc adapts a function to a Codec
+type CodecFunc func(body []byte) ([]TypedMetric, error)
+
+//Decode calls f(body)
+func (f CodecFunc) Decode(body []byte) ([]TypedMetric, error) {
+	return f(body)
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{
+		ContentTypeJSON:          CodecFunc(UnmarshallMetrics),
+		ContentTypeMessagePack:   CodecFunc(UnmarshallMessagePackMetrics),
+		"application/x-msgpack":  CodecFunc(UnmarshallMessagePackMetrics),
+		ContentTypeProtobuf:      CodecFunc(UnmarshallProtobufMetrics),
+		"application/x-protobuf": CodecFunc(UnmarshallProtobufMetrics),
+		ContentTypeStatsD:        CodecFunc(UnmarshallStatsDMetrics),
+	}
+)
+
+//RegisterCodec registers the codec of a content type. Registering a content type twice panics
+func RegisterCodec(contentType string, codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	contentType = mediaType(contentType)
+	if _, ok := codecs[contentType]; ok {
+		panic(fmt.Sprintf("codec %s already registered", contentType))
+	}
+	codecs[contentType] = codec
+}
+
+//ContentTypes returns the content types of the registered codecs
+func ContentTypes() []string {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	contentTypes := make([]string, 0, len(codecs))
+	for contentType := range codecs {
+		contentTypes = append(contentTypes, contentType)
+	}
+	sort.Strings(contentTypes)
+	return contentTypes
+}
+
+//LookupCodec returns the codec of a content type, parameters such as the charset are ignored.
+//Bodies without content type are JSON
+func LookupCodec(contentType string) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	codec, ok := codecs[mediaType(contentType)]
+	if !ok {
+		return nil, fmt.Errorf("Unsupported content type %s", contentType)
+	}
+	return codec, nil
+}
+
+//mediaType returns the lowercased media type of a content type without parameters
+func mediaType(contentType string) string {
+	if contentType == "" {
+		return ContentTypeJSON
+	}
+	media, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+	return media
+}
+
+//isGzip returns whether a body is gzip compressed, either by its content encoding or by its magic number
+func isGzip(contentEncoding string, body []byte) bool {
+	return strings.EqualFold(contentEncoding, "gzip") || (len(body) > 1 && body[0] == 0x1f && body[1] == 0x8b)
+}
+
+//decompress returns the uncompressed body of a content encoding, see SetMaxDecompressedSize
+func decompress(contentEncoding string, body []byte) ([]byte, error) {
+	if isGzip(contentEncoding, body) {
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read gzip body %s", err)
+		}
+		defer gz.Close()
+		max := atomic.LoadInt64(&maxDecompressedSize)
+		uncompressed, err := ioutil.ReadAll(io.LimitReader(gz, max+1))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read gzip body %s", err)
+		}
+		if int64(len(uncompressed)) > max {
+			return nil, fmt.Errorf("Gzip body is larger than %d bytes decompressed", max)
+		}
+		return uncompressed, nil
+	}
+	switch strings.ToLower(contentEncoding) {
+	case "", "identity":
+		return body, nil
+	}
+	return nil, fmt.Errorf("Unsupported content encoding %s", contentEncoding)
+}
+
+//IsPlainJSON returns whether a body is uncompressed JSON, the encoding the workers decode
+func IsPlainJSON(contentType, contentEncoding string, body []byte) bool {
+	if isGzip(contentEncoding, body) || (contentEncoding != "" && !strings.EqualFold(contentEncoding, "identity")) {
+		return false
+	}
+	return mediaType(contentType) == ContentTypeJSON
+}
+
+//Decode decodes the metrics of a body with the codec of its content type. Gzip bodies are decompressed first
+func Decode(contentType, contentEncoding string, body []byte) ([]TypedMetric, error) {
+	codec, err := LookupCodec(contentType)
+	if err != nil {
+		return nil, err
+	}
+	body, err = decompress(contentEncoding, body)
+	if err != nil {
+		return nil, err
+	}
+	return codec.Decode(body)
+}
+
+//DecodeDelivery decodes the metrics of a rabbit delivery by its content type and content encoding, see Decode
+func DecodeDelivery(delivery amqp.Delivery) ([]TypedMetric, error) {
+	return Decode(delivery.ContentType, delivery.ContentEncoding, delivery.Body)
+}
+
+//MarshallMetric marshalls a metric to JSON along with its type, so UnmarshallMetric decodes it back.
+//Counts are marshalled without type as before
+func MarshallMetric(metric TypedMetric) ([]byte, error) {
+	body, err := json.Marshal(metric)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshall metric %s", err)
+	}
+	if metric.MetricType() == MetricTypeCount {
+		return body, nil
+	}
+	typed := make([]byte, 0, len(body)+len(metric.MetricType())+10)
+	typed = append(typed, `{"type":"`...)
+	typed = append(typed, metric.MetricType()...)
+	typed = append(typed, `",`...)
+	return append(typed, body[1:]...), nil
+}
+
+//metricFromFields returns the metric of the decoded fields of a body, e.g. a MessagePack map. Numbers may be
+//of any integer or float type
+func metricFromFields(fields map[string]interface{}) (TypedMetric, error) {
+	metricType := MetricTypeCount
+	if value, ok := fields["type"]; ok && value != nil {
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("type should be a string %v", value)
+		}
+		if s != "" {
+			metricType = MetricType(s)
+		}
+	}
+	var id Identity
+	var err error
+	if id.UserName, err = stringField(fields, "username"); err != nil {
+		return nil, err
+	}
+	if id.Metric, err = stringField(fields, "metric"); err != nil {
+		return nil, err
+	}
+	if value, ok := fields["tags"]; ok && value != nil {
+		tags, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("tags should be a map %v", value)
+		}
+		id.Tags = make(map[string]string, len(tags))
+		for name, value := range tags {
+			s, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("tag %s should be a string %v", name, value)
+			}
+			id.Tags[name] = s
+		}
+	}
+	switch metricType {
+	case MetricTypeCount:
+		count, err := integerField(fields, "count")
+		if err != nil {
+			return nil, err
+		}
+		return &CountMetric{UserName: id.UserName, Metric: id.Metric, Tags: id.Tags, Count: count}, nil
+	case MetricTypeGauge:
+		value, err := numberField(fields, "value")
+		if err != nil {
+			return nil, err
+		}
+		delta, ok := fields["delta"].(bool)
+		if !ok && fields["delta"] != nil {
+			return nil, fmt.Errorf("delta should be a boolean %v", fields["delta"])
+		}
+		return &GaugeMetric{UserName: id.UserName, Metric: id.Metric, Tags: id.Tags, Value: value, Delta: delta}, nil
+	case MetricTypeHistogram:
+		value, err := numberField(fields, "value")
+		if err != nil {
+			return nil, err
+		}
+		return &HistogramMetric{UserName: id.UserName, Metric: id.Metric, Tags: id.Tags, Value: value}, nil
+	case MetricTypeSet:
+		member, err := stringField(fields, "member")
+		if err != nil {
+			return nil, err
+		}
+		return &SetMetric{UserName: id.UserName, Metric: id.Metric, Tags: id.Tags, Member: member}, nil
+	}
+	return nil, fmt.Errorf("Unknown metric type %s", metricType)
+}
+
+//stringField returns a string field, missing fields are empty
+func stringField(fields map[string]interface{}, name string) (string, error) {
+	value, ok := fields[name]
+	if !ok || value == nil {
+		return "", nil
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("%s should be a string %v", name, value)
+	}
+	return s, nil
+}
+
+//integerField returns an integer field, missing fields are 0. Floats without fraction are accepted
+func integerField(fields map[string]interface{}, name string) (int64, error) {
+	value := fields[name]
+	if f, ok := value.(float32); ok {
+		value = float64(f)
+	}
+	switch value := value.(type) {
+	case nil:
+		return 0, nil
+	case int64:
+		return value, nil
+	case uint64:
+		if value > math.MaxInt64 {
+			return 0, fmt.Errorf("%s overflows an int64 %d", name, value)
+		}
+		return int64(value), nil
+	case float64:
+		//float64(math.MaxInt64) is rounded up to 2^63 which overflows
+		if value != math.Trunc(value) || value >= math.MaxInt64 || value < math.MinInt64 {
+			return 0, fmt.Errorf("%s should be an integer %v", name, value)
+		}
+		return int64(value), nil
+	default:
+		return 0, fmt.Errorf("%s should be an integer %v", name, value)
+	}
+}
+
+//numberField returns a number field, missing fields are 0
+func numberField(fields map[string]interface{}, name string) (float64, error) {
+	switch value := fields[name].(type) {
+	case nil:
+		return 0, nil
+	case int64:
+		return float64(value), nil
+	case uint64:
+		return float64(value), nil
+	case float64:
+		return value, nil
+	case float32:
+		return float64(value), nil
+	default:
+		return 0, fmt.Errorf("%s should be a number %v", name, value)
+	}
+}
@@ -0,0 +1,328 @@
+package worker
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+)
+
+//messagePackGauge returns the MessagePack map of a gauge
+//{"type": "gauge", "username": "kodingbot", "metric": "connections", "value": 1.5, "tags": {"region": "eu"}}
+func messagePackGauge() []byte {
+	b := []byte{0x85}
+	b = append(b, 0xa4, 't', 'y', 'p', 'e', 0xa5, 'g', 'a', 'u', 'g', 'e')
+	b = append(b, 0xa8, 'u', 's', 'e', 'r', 'n', 'a', 'm', 'e', 0xa9, 'k', 'o', 'd', 'i', 'n', 'g', 'b', 'o', 't')
+	b = append(b, 0xa6, 'm', 'e', 't', 'r', 'i', 'c', 0xab, 'c', 'o', 'n', 'n', 'e', 'c', 't', 'i', 'o', 'n', 's')
+	b = append(b, 0xa5, 'v', 'a', 'l', 'u', 'e', 0xcb)
+	b = append(b, make([]byte, 8)...)
+	binary.BigEndian.PutUint64(b[len(b)-8:], math.Float64bits(1.5))
+	b = append(b, 0xa4, 't', 'a', 'g', 's', 0x81, 0xa6, 'r', 'e', 'g', 'i', 'o', 'n', 0xa2, 'e', 'u')
+	return b
+}
+
+//messagePackCount returns the MessagePack map {"username": "koding", "metric": "kite_call", "count": 300}
+func messagePackCount() []byte {
+	b := []byte{0x83}
+	b = append(b, 0xa8, 'u', 's', 'e', 'r', 'n', 'a', 'm', 'e', 0xa6, 'k', 'o', 'd', 'i', 'n', 'g')
+	b = append(b, 0xa6, 'm', 'e', 't', 'r', 'i', 'c', 0xa9, 'k', 'i', 't', 'e', '_', 'c', 'a', 'l', 'l')
+	return append(b, 0xa5, 'c', 'o', 'u', 'n', 't', 0xcd, 0x01, 0x2c)
+}
+
+func appendUvarint(b []byte, x uint64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return append(b, buf[:binary.PutUvarint(buf, x)]...)
+}
+
+func appendFixed64(b []byte, x uint64) []byte {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, x)
+	return append(b, buf...)
+}
+
+func protobufKey(field, wireType int) []byte {
+	return appendUvarint(nil, uint64(field<<3|wireType))
+}
+
+func protobufString(field int, s string) []byte {
+	b := protobufKey(field, protobufBytes)
+	b = appendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+//protobufBatch returns a MetricBatch of a tagged histogram and a count
+func protobufBatch() []byte {
+	var histogram []byte
+	histogram = append(histogram, protobufString(1, "histogram")...)
+	histogram = append(histogram, protobufString(2, "kodingbot")...)
+	histogram = append(histogram, protobufString(3, "kite_call_ms")...)
+	histogram = append(histogram, protobufKey(5, protobufFixed64)...)
+	histogram = appendFixed64(histogram, math.Float64bits(230))
+	tag := append(protobufString(1, "region"), protobufString(2, "eu")...)
+	histogram = append(histogram, protobufString(7, string(tag))...)
+	//unknown fields are skipped
+	histogram = append(histogram, protobufKey(15, protobufVarint)...)
+	histogram = appendUvarint(histogram, 42)
+
+	var count []byte
+	count = append(count, protobufString(2, "koding")...)
+	count = append(count, protobufString(3, "kite_call")...)
+	count = append(count, protobufKey(4, protobufVarint)...)
+	count = appendUvarint(count, 300)
+
+	return append(protobufString(1, string(histogram)), protobufString(1, string(count))...)
+}
+
+//protobufGaugeDelta returns a MetricBatch of a gauge decrement
+func protobufGaugeDelta() []byte {
+	var gauge []byte
+	gauge = append(gauge, protobufString(1, "gauge")...)
+	gauge = append(gauge, protobufString(2, "kodingbot")...)
+	gauge = append(gauge, protobufString(3, "connections")...)
+	gauge = append(gauge, protobufKey(5, protobufFixed64)...)
+	gauge = appendFixed64(gauge, math.Float64bits(-2))
+	gauge = append(gauge, protobufKey(8, protobufVarint)...)
+	gauge = appendUvarint(gauge, 1)
+	return protobufString(1, string(gauge))
+}
+
+func gzipped(t *testing.T, b []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	gz.Close()
+	return buf.Bytes()
+}
+
+func TestDecode(t *testing.T) {
+	eu := map[string]string{"region": "eu"}
+	count := &CountMetric{UserName: "koding", Metric: "kite_call", Count: 300}
+	tests := []struct {
+		name            string
+		contentType     string
+		contentEncoding string
+		body            []byte
+		want            []TypedMetric
+		wantErr         bool
+	}{
+		{
+			"JSON without content type",
+			"",
+			"",
+			[]byte(`[{"username": "koding", "metric": "kite_call", "count": 300}]`),
+			[]TypedMetric{count},
+			false,
+		},
+		{
+			"JSON with charset",
+			"application/json; charset=utf-8",
+			"",
+			[]byte(`{"username": "koding", "metric": "kite_call", "count": 300}`),
+			[]TypedMetric{count},
+			false,
+		},
+		{
+			"MessagePack",
+			ContentTypeMessagePack,
+			"",
+			messagePackGauge(),
+			[]TypedMetric{&GaugeMetric{UserName: "kodingbot", Metric: "connections", Value: 1.5, Tags: eu}},
+			false,
+		},
+		{
+			"MessagePack array",
+			"application/x-msgpack",
+			"",
+			append(append([]byte{0x92}, messagePackGauge()...), messagePackCount()...),
+			[]TypedMetric{&GaugeMetric{UserName: "kodingbot", Metric: "connections", Value: 1.5, Tags: eu}, count},
+			false,
+		},
+		{
+			"MessagePack stream",
+			ContentTypeMessagePack,
+			"",
+			append(messagePackCount(), messagePackCount()...),
+			[]TypedMetric{count, count},
+			false,
+		},
+		{
+			"Gzip MessagePack",
+			ContentTypeMessagePack,
+			"gzip",
+			gzipped(t, messagePackCount()),
+			[]TypedMetric{count},
+			false,
+		},
+		{
+			"Truncated MessagePack",
+			ContentTypeMessagePack,
+			"",
+			messagePackGauge()[:20],
+			nil,
+			true,
+		},
+		{
+			"MessagePack string",
+			ContentTypeMessagePack,
+			"",
+			[]byte{0xa2, 'e', 'u'},
+			nil,
+			true,
+		},
+		{
+			"Protobuf",
+			ContentTypeProtobuf,
+			"",
+			protobufBatch(),
+			[]TypedMetric{&HistogramMetric{UserName: "kodingbot", Metric: "kite_call_ms", Value: 230, Tags: eu}, count},
+			false,
+		},
+		{
+			"Protobuf gauge delta",
+			ContentTypeProtobuf,
+			"",
+			protobufGaugeDelta(),
+			[]TypedMetric{&GaugeMetric{UserName: "kodingbot", Metric: "connections", Value: -2, Delta: true}},
+			false,
+		},
+		{
+			"Truncated protobuf",
+			ContentTypeProtobuf,
+			"",
+			protobufBatch()[:10],
+			nil,
+			true,
+		},
+		{
+			"StatsD",
+			ContentTypeStatsD,
+			"",
+			[]byte("kite_call:150|c|@0.5|#username:koding\n\nvisitors:v1|s|#username:kodingbot,region:eu\n"),
+			[]TypedMetric{count, &SetMetric{UserName: "kodingbot", Metric: "visitors", Member: "v1", Tags: eu}},
+			false,
+		},
+		{
+			"Gzip JSON detected by magic number",
+			ContentTypeJSON,
+			"",
+			gzipped(t, []byte(`{"username": "koding", "metric": "kite_call", "count": 300}`)),
+			[]TypedMetric{count},
+			false,
+		},
+		{"Unknown content type", "text/csv", "", []byte("koding,kite_call,300"), nil, true},
+		{"Unknown content encoding", ContentTypeJSON, "br", []byte(`{}`), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Decode(tt.contentType, tt.contentEncoding, tt.body)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode() = %+v want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecode_MaxDecompressedSize(t *testing.T) {
+	SetMaxDecompressedSize(16)
+	defer SetMaxDecompressedSize(0)
+	body := []byte(`{"username": "koding", "metric": "kite_call", "count": 300}`)
+	if _, err := Decode(ContentTypeJSON, "gzip", gzipped(t, body)); err == nil {
+		t.Errorf("Decode() decompressed %d bytes larger than the maximum", len(body))
+	}
+	SetMaxDecompressedSize(int64(len(body)))
+	if _, err := Decode(ContentTypeJSON, "gzip", gzipped(t, body)); err != nil {
+		t.Errorf("Decode() error = %v", err)
+	}
+}
+
+func TestParseStatsD(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    TypedMetric
+		wantErr bool
+	}{
+		{"Count", "kite_call:2|c|#username:kodingbot", &CountMetric{UserName: "kodingbot", Metric: "kite_call", Count: 2}, false},
+		{"Sampled count", "kite_call:1|c|@0.1|#username:kodingbot", &CountMetric{UserName: "kodingbot", Metric: "kite_call", Count: 10}, false},
+		{"Negative count", "kite_call:-2|c|#username:kodingbot", &CountMetric{UserName: "kodingbot", Metric: "kite_call", Count: -2}, false},
+		{"Gauge", "connections:3.5|g|#username:kodingbot,prod", &GaugeMetric{UserName: "kodingbot", Metric: "connections", Value: 3.5, Tags: map[string]string{"prod": ""}}, false},
+		{"Gauge decrement", "connections:-3.5|g|#username:kodingbot", &GaugeMetric{UserName: "kodingbot", Metric: "connections", Value: -3.5, Delta: true}, false},
+		{"Gauge increment", "connections:+2|g|#username:kodingbot", &GaugeMetric{UserName: "kodingbot", Metric: "connections", Value: 2, Delta: true}, false},
+		{"Timing", "kite_call_ms:230|ms", &HistogramMetric{Metric: "kite_call_ms", Value: 230}, false},
+		{"Distribution", "kite_call_ms:230|d", &HistogramMetric{Metric: "kite_call_ms", Value: 230}, false},
+		{"Name with colon", "kite:call:1|c", &CountMetric{Metric: "kite:call", Count: 1}, false},
+		{"Missing type", "kite_call:1", nil, true},
+		{"Missing value", "kite_call|c", nil, true},
+		{"Invalid count", "kite_call:one|c", nil, true},
+		{"Invalid sample rate", "kite_call:1|c|@2", nil, true},
+		{"Unknown type", "kite_call:1|x", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseStatsD(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseStatsD() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseStatsD() = %+v want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatsDParser_Parse(t *testing.T) {
+	parser := StatsDParser{DefaultUserName: "koding"}
+	tests := []struct {
+		name string
+		line string
+		want TypedMetric
+	}{
+		{"Default username", "kite_call:1|c|#region:eu", &CountMetric{UserName: "koding", Metric: "kite_call", Count: 1, Tags: map[string]string{"region": "eu"}}},
+		{"Username tag", "kite_call:1|c|#username:kodingbot", &CountMetric{UserName: "kodingbot", Metric: "kite_call", Count: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parser.Parse(tt.line)
+			if err != nil {
+				t.Fatalf("StatsDParser.Parse() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("StatsDParser.Parse() = %+v want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarshallMetric(t *testing.T) {
+	tests := []struct {
+		name   string
+		metric TypedMetric
+		want   string
+	}{
+		{"Count without type", &CountMetric{UserName: "kodingbot", Metric: "kite_call", Count: 1}, `{"username":"kodingbot","count":1,"metric":"kite_call"}`},
+		{"Gauge with type", &GaugeMetric{UserName: "kodingbot", Metric: "connections", Value: 2}, `{"type":"gauge","username":"kodingbot","metric":"connections","value":2}`},
+		{"Set with type", &SetMetric{UserName: "kodingbot", Metric: "visitors", Member: "v1"}, `{"type":"set","username":"kodingbot","metric":"visitors","member":"v1"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := MarshallMetric(tt.metric)
+			if err != nil {
+				t.Fatalf("MarshallMetric() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("MarshallMetric() = %s want %s", got, tt.want)
+			}
+			decoded, err := UnmarshallMetric(got)
+			if err != nil || !reflect.DeepEqual(decoded, tt.metric) {
+				t.Errorf("UnmarshallMetric(MarshallMetric()) = %+v, %v want %+v", decoded, err, tt.metric)
+			}
+		})
+	}
+}
@@ -1,8 +1,27 @@
 package worker
 
-//CountMetric represents a count metric of different types of events
+//CountMetric represents a count metric of different types of events. Metrics without type are counts
 type CountMetric struct {
 	UserName string `json:"username"`
 	Count    int64  `json:"count"`
 	Metric   string `json:"metric"`
+	//Tags dimensions of the metric e.g. region, client version or plan
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+//MetricType returns MetricTypeCount
+func (m *CountMetric) MetricType() MetricType { return MetricTypeCount }
+
+//Identity returns the username, name and tags of the metric
+func (m *CountMetric) Identity() Identity { return Identity{m.UserName, m.Metric, m.Tags} }
+
+//Validate checks the metric has the required fields. Negative counts decrement the count e.g. StatsD decrements
+func (m *CountMetric) Validate() error {
+	return m.Identity().validate()
+}
+
+//SeriesKey returns the metric name along with its tags ordered by name e.g. kite_call,plan=free,region=eu.
+//Metrics with the same key belong to the same series
+func (m *CountMetric) SeriesKey() string {
+	return m.Identity().SeriesKey()
 }
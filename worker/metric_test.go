@@ -0,0 +1,43 @@
+package worker
+
+import "testing"
+
+func TestCountMetric_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		metric  CountMetric
+		wantErr bool
+	}{
+		{"Valid", CountMetric{UserName: "kodingbot", Count: 1, Metric: "kite_call"}, false},
+		{"Valid with tags", CountMetric{UserName: "kodingbot", Count: 1, Metric: "kite_call", Tags: map[string]string{"region": "eu"}}, false},
+		{"Missing username", CountMetric{Count: 1, Metric: "kite_call"}, true},
+		{"Missing metric", CountMetric{UserName: "kodingbot", Count: 1}, true},
+		{"Negative count", CountMetric{UserName: "kodingbot", Count: -1, Metric: "kite_call"}, false},
+		{"Empty tag name", CountMetric{UserName: "kodingbot", Count: 1, Metric: "kite_call", Tags: map[string]string{"": "eu"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.metric.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("CountMetric.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCountMetric_SeriesKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		metric CountMetric
+		want   string
+	}{
+		{"Without tags", CountMetric{Metric: "kite_call"}, "kite_call"},
+		{"Tags ordered by name", CountMetric{Metric: "kite_call", Tags: map[string]string{"region": "eu", "plan": "free"}}, "kite_call,plan=free,region=eu"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.metric.SeriesKey(); got != tt.want {
+				t.Errorf("CountMetric.SeriesKey() = %s want %s", got, tt.want)
+			}
+		})
+	}
+}
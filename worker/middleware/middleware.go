@@ -0,0 +1,143 @@
+//Package middleware provides basic worker middlewares which can be composed with worker.Chain or registered in the processor
+package middleware
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ottogiron/metricsworker/logging"
+	"github.com/ottogiron/metricsworker/worker"
+	"github.com/streadway/amqp"
+)
+
+//permanentError an error which should not be retried
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+//Permanent marks an error as permanent so Retry does not retry it
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err}
+}
+
+//IsPermanent returns whether an error was marked as permanent
+func IsPermanent(err error) bool {
+	_, ok := err.(*permanentError)
+	return ok
+}
+
+//Logging logs every execution of a worker with its duration and result. Successful executions are logged at debug level
+//and failed ones at warn level
+func Logging(logger logging.Logger) worker.Middleware {
+	return func(next worker.Worker) worker.Worker {
+		name := fmt.Sprintf("%T", next)
+		return worker.Func(func(task interface{}) error {
+			start := time.Now()
+			err := next.Execute(task)
+			fields := logging.Fields(worker.TaskFields(task))
+			fields["worker"] = name
+			fields["duration"] = time.Since(start)
+			if err != nil {
+				fields["error"] = err
+				logger.Warn("Worker failed", fields)
+				return err
+			}
+			logger.Debug("Worker succeeded", fields)
+			return nil
+		})
+	}
+}
+
+//Timing reports the duration and result of every execution of a worker to observe
+func Timing(observe func(duration time.Duration, err error)) worker.Middleware {
+	return func(next worker.Worker) worker.Worker {
+		return worker.Func(func(task interface{}) error {
+			start := time.Now()
+			err := next.Execute(task)
+			observe(time.Since(start), err)
+			return err
+		})
+	}
+}
+
+//Retry executes a worker up to attempts times until it succeeds. The wait between attempts starts at backoff and doubles
+//after every attempt. Permanent errors are not retried
+func Retry(attempts int, backoff time.Duration) worker.Middleware {
+	return RetryNotify(attempts, backoff, nil)
+}
+
+//RetryWithLogger works like Retry and logs every failed attempt which is retried
+func RetryWithLogger(attempts int, backoff time.Duration, logger logging.Logger) worker.Middleware {
+	return RetryNotify(attempts, backoff, func(task interface{}, attempt int, wait time.Duration, err error) {
+		fields := logging.Fields(worker.TaskFields(task))
+		fields["attempt"] = attempt
+		fields["backoff"] = wait
+		fields["error"] = err
+		logger.Info("Retrying task", fields)
+	})
+}
+
+//RetryNotify works like Retry and calls notify, if not nil, with the failed attempt and the wait until the next one
+//before every retry
+func RetryNotify(attempts int, backoff time.Duration, notify func(task interface{}, attempt int, wait time.Duration, err error)) worker.Middleware {
+	return func(next worker.Worker) worker.Worker {
+		return worker.Func(func(task interface{}) error {
+			var err error
+			wait := backoff
+			for attempt := 1; ; attempt++ {
+				err = next.Execute(task)
+				if err == nil || IsPermanent(err) || attempt >= attempts {
+					return err
+				}
+				if notify != nil {
+					notify(task, attempt, wait, err)
+				}
+				time.Sleep(wait)
+				wait *= 2
+			}
+		})
+	}
+}
+
+//Recover turns a panic of a worker into a permanent error
+func Recover() worker.Middleware {
+	return func(next worker.Worker) worker.Worker {
+		return worker.Func(func(task interface{}) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = Permanent(fmt.Errorf("Worker panicked %v", r))
+				}
+			}()
+			return next.Execute(task)
+		})
+	}
+}
+
+//Validate fails with a permanent error without executing the worker if the task is not a rabbit delivery
+//with a valid metric body of a known type
+func Validate() worker.Middleware {
+	return func(next worker.Worker) worker.Worker {
+		return worker.Func(func(task interface{}) error {
+			delivery, ok := task.(amqp.Delivery)
+			if !ok {
+				return Permanent(fmt.Errorf("Task should be a rabbit delivery %v", task))
+			}
+			metric, err := worker.UnmarshallMetric(delivery.Body)
+			if err != nil {
+				return Permanent(err)
+			}
+			err = metric.Validate()
+			if err != nil {
+				return Permanent(fmt.Errorf("Invalid metric %s", err))
+			}
+			return next.Execute(task)
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ottogiron/metricsworker/logging"
+	"github.com/ottogiron/metricsworker/worker"
+	"github.com/streadway/amqp"
+)
+
+var validPayload = []byte(`{"username": "kodingbot", "count": 1, "metric": "kite_call"}`)
+
+//failingWorker fails the first failures executions
+type failingWorker struct {
+	failures int
+	err      error
+	executed int
+}
+
+func (w *failingWorker) Execute(task interface{}) error {
+	w.executed++
+	if w.executed <= w.failures {
+		return w.err
+	}
+	return nil
+}
+
+func TestChain(t *testing.T) {
+	var calls []string
+	tracing := func(name string) worker.Middleware {
+		return func(next worker.Worker) worker.Worker {
+			return worker.Func(func(task interface{}) error {
+				calls = append(calls, name)
+				return next.Execute(task)
+			})
+		}
+	}
+	w := worker.Chain(worker.Func(func(task interface{}) error {
+		calls = append(calls, "worker")
+		return nil
+	}), tracing("first"), tracing("second"))
+	w.Execute(nil)
+	if want := []string{"first", "second", "worker"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("Chain() calls = %v want %v", calls, want)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	failure := errors.New("failure")
+	tests := []struct {
+		name         string
+		failures     int
+		err          error
+		attempts     int
+		wantErr      bool
+		wantExecuted int
+	}{
+		{"Succeeds first attempt", 0, failure, 3, false, 1},
+		{"Succeeds after retrying", 2, failure, 3, false, 3},
+		{"Fails after all attempts", 5, failure, 3, true, 3},
+		{"Permanent errors are not retried", 5, Permanent(failure), 3, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &failingWorker{failures: tt.failures, err: tt.err}
+			err := Retry(tt.attempts, time.Millisecond)(w).Execute(nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Retry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if w.executed != tt.wantExecuted {
+				t.Errorf("Retry() executed = %d want %d", w.executed, tt.wantExecuted)
+			}
+		})
+	}
+}
+
+func TestRecover(t *testing.T) {
+	w := Recover()(worker.Func(func(task interface{}) error {
+		panic("boom")
+	}))
+	err := w.Execute(nil)
+	if err == nil || !IsPermanent(err) {
+		t.Errorf("Recover() error = %v want a permanent error", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		task    interface{}
+		wantErr bool
+	}{
+		{"Valid metric", amqp.Delivery{Body: validPayload}, false},
+		{"Missing username", amqp.Delivery{Body: []byte(`{"count": 1, "metric": "kite_call"}`)}, true},
+		{"Invalid payload", amqp.Delivery{Body: []byte(`{"count": `)}, true},
+		{"Not a rabbit delivery", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &failingWorker{}
+			err := Validate()(w).Execute(tt.task)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && (w.executed != 0 || !IsPermanent(err)) {
+				t.Errorf("Validate() executed = %d, permanent = %v want a permanent error without executing", w.executed, IsPermanent(err))
+			}
+		})
+	}
+}
+
+func TestLogging(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.New(&buf, logging.LevelDebug, logging.FormatLogfmt)
+	w := Logging(logger)(&failingWorker{failures: 1, err: errors.New("failure")})
+	w.Execute(amqp.Delivery{MessageId: "1", Body: validPayload})
+	w.Execute(amqp.Delivery{MessageId: "2", Body: validPayload})
+	out := buf.String()
+	for _, want := range []string{
+		`level=warn msg="Worker failed"`,
+		`error=failure`,
+		`message_id=1 metric=kite_call username=kodingbot worker=*middleware.failingWorker`,
+		`level=debug msg="Worker succeeded"`,
+		`message_id=2`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Logging() output = %s want %s", out, want)
+		}
+	}
+}
+
+func TestRetryWithLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.New(&buf, logging.LevelInfo, logging.FormatLogfmt)
+	w := &failingWorker{failures: 2, err: errors.New("failure")}
+	err := RetryWithLogger(3, time.Millisecond, logger)(w).Execute(nil)
+	if err != nil {
+		t.Fatalf("RetryWithLogger() error = %v", err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "attempt=1 backoff=1ms error=failure") || !strings.Contains(out, "attempt=2 backoff=2ms error=failure") {
+		t.Errorf("RetryWithLogger() output = %s", out)
+	}
+}
+
+func TestTiming(t *testing.T) {
+	var observed []error
+	failure := errors.New("failure")
+	w := Timing(func(duration time.Duration, err error) {
+		observed = append(observed, err)
+	})(&failingWorker{failures: 1, err: failure})
+	w.Execute(nil)
+	w.Execute(nil)
+	if want := []error{failure, nil}; !reflect.DeepEqual(observed, want) {
+		t.Errorf("Timing() observed = %v want %v", observed, want)
+	}
+}
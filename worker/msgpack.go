@@ -0,0 +1,234 @@
+package worker
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+//maxMessagePackDepth maximum nesting of MessagePack maps and arrays
+const maxMessagePackDepth = 16
+
+var errMessagePackTruncated = errors.New("truncated MessagePack body")
+
+//UnmarshallMessagePackMetrics unmarshalls a MessagePack body to metrics. The body contains one or more values which
+//are either a metric map with the fields of the JSON metrics or an array of them
+func UnmarshallMessagePackMetrics(body []byte) ([]TypedMetric, error) {
+	d := &messagePackDecoder{data: body}
+	var metrics []TypedMetric
+	for d.pos < len(d.data) {
+		value, err := d.decode(0)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse MessagePack body %s", err)
+		}
+		values, ok := value.([]interface{})
+		if !ok {
+			values = []interface{}{value}
+		}
+		for _, value := range values {
+			fields, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("Metric at index %d should be a map", len(metrics))
+			}
+			metric, err := metricFromFields(fields)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid metric at index %d %s", len(metrics), err)
+			}
+			metrics = append(metrics, metric)
+		}
+	}
+	if len(metrics) == 0 {
+		return nil, errors.New("Failed to parse MessagePack body without metrics")
+	}
+	return metrics, nil
+}
+
+//messagePackDecoder decodes the MessagePack types metrics are made of: nil, booleans, integers, floats, strings,
+//binaries (as strings), arrays and maps with string keys. Extension types are not supported
+type messagePackDecoder struct {
+	data []byte
+	pos  int
+}
+
+//next returns the next n bytes
+func (d *messagePackDecoder) next(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.pos < n {
+		return nil, errMessagePackTruncated
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+//length returns the next big endian unsigned integer of size bytes
+func (d *messagePackDecoder) length(size int) (int, error) {
+	b, err := d.next(size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return int(b[0]), nil
+	case 2:
+		return int(binary.BigEndian.Uint16(b)), nil
+	default:
+		n := binary.BigEndian.Uint32(b)
+		if uint64(n) > uint64(len(d.data)) {
+			return 0, errMessagePackTruncated
+		}
+		return int(n), nil
+	}
+}
+
+func (d *messagePackDecoder) decode(depth int) (interface{}, error) {
+	if depth > maxMessagePackDepth {
+		return nil, errors.New("MessagePack body is nested too deeply")
+	}
+	b, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	c := b[0]
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c >= 0x80 && c <= 0x8f:
+		return d.decodeMap(int(c&0x0f), depth)
+	case c >= 0x90 && c <= 0x9f:
+		return d.decodeArray(int(c&0x0f), depth)
+	case c >= 0xa0 && c <= 0xbf:
+		return d.decodeString(int(c & 0x1f))
+	}
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xd9:
+		return d.decodeSizedString(1)
+	case 0xc5, 0xda:
+		return d.decodeSizedString(2)
+	case 0xc6, 0xdb:
+		return d.decodeSizedString(4)
+	case 0xca:
+		b, err := d.next(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case 0xcb:
+		b, err := d.next(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		b, err := d.next(1 << (c - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		var n uint64
+		for _, x := range b {
+			n = n<<8 | uint64(x)
+		}
+		return n, nil
+	case 0xd0:
+		b, err := d.next(1)
+		if err != nil {
+			return nil, err
+		}
+		return int64(int8(b[0])), nil
+	case 0xd1:
+		b, err := d.next(2)
+		if err != nil {
+			return nil, err
+		}
+		return int64(int16(binary.BigEndian.Uint16(b))), nil
+	case 0xd2:
+		b, err := d.next(4)
+		if err != nil {
+			return nil, err
+		}
+		return int64(int32(binary.BigEndian.Uint32(b))), nil
+	case 0xd3:
+		b, err := d.next(8)
+		if err != nil {
+			return nil, err
+		}
+		return int64(binary.BigEndian.Uint64(b)), nil
+	case 0xdc, 0xdd:
+		n, err := d.length(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeArray(n, depth)
+	case 0xde, 0xdf:
+		n, err := d.length(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.decodeMap(n, depth)
+	}
+	return nil, fmt.Errorf("unsupported MessagePack type 0x%x", c)
+}
+
+func (d *messagePackDecoder) decodeSizedString(size int) (interface{}, error) {
+	n, err := d.length(size)
+	if err != nil {
+		return nil, err
+	}
+	return d.decodeString(n)
+}
+
+func (d *messagePackDecoder) decodeString(n int) (interface{}, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (d *messagePackDecoder) decodeArray(n int, depth int) (interface{}, error) {
+	//every element takes at least a byte
+	if n > len(d.data)-d.pos {
+		return nil, errMessagePackTruncated
+	}
+	values := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		value, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func (d *messagePackDecoder) decodeMap(n int, depth int) (interface{}, error) {
+	//every key and value take at least a byte
+	if n > (len(d.data)-d.pos)/2 {
+		return nil, errMessagePackTruncated
+	}
+	fields := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		key, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		name, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("MessagePack map keys should be strings %v", key)
+		}
+		value, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		fields[name] = value
+	}
+	return fields, nil
+}
@@ -0,0 +1,142 @@
+package worker
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+//Protobuf wire types
+const (
+	protobufVarint  = 0
+	protobufFixed64 = 1
+	protobufBytes   = 2
+	protobufFixed32 = 5
+)
+
+var errProtobufTruncated = errors.New("truncated protobuf body")
+
+//UnmarshallProtobufMetrics unmarshalls a protobuf MetricBatch body to metrics, see metric.proto.
+//Unknown fields are skipped so producers can use newer versions of the schema
+func UnmarshallProtobufMetrics(body []byte) ([]TypedMetric, error) {
+	var metrics []TypedMetric
+	err := readProtobuf(body, func(field int, wireType int, value uint64, data []byte) error {
+		if field != 1 || wireType != protobufBytes {
+			return nil
+		}
+		metric, err := unmarshallProtobufMetric(data)
+		if err != nil {
+			return fmt.Errorf("Invalid metric at index %d %s", len(metrics), err)
+		}
+		metrics = append(metrics, metric)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse protobuf body %s", err)
+	}
+	if len(metrics) == 0 {
+		return nil, errors.New("Failed to parse protobuf body without metrics")
+	}
+	return metrics, nil
+}
+
+//unmarshallProtobufMetric unmarshalls a protobuf Metric message
+func unmarshallProtobufMetric(body []byte) (TypedMetric, error) {
+	fields := make(map[string]interface{})
+	var tags map[string]interface{}
+	err := readProtobuf(body, func(field int, wireType int, value uint64, data []byte) error {
+		switch {
+		case field == 1 && wireType == protobufBytes:
+			fields["type"] = string(data)
+		case field == 2 && wireType == protobufBytes:
+			fields["username"] = string(data)
+		case field == 3 && wireType == protobufBytes:
+			fields["metric"] = string(data)
+		case field == 4 && wireType == protobufVarint:
+			fields["count"] = int64(value)
+		case field == 5 && wireType == protobufFixed64:
+			fields["value"] = math.Float64frombits(value)
+		case field == 6 && wireType == protobufBytes:
+			fields["member"] = string(data)
+		case field == 7 && wireType == protobufBytes:
+			var name, tag string
+			err := readProtobuf(data, func(field int, wireType int, value uint64, data []byte) error {
+				if wireType == protobufBytes && field == 1 {
+					name = string(data)
+				}
+				if wireType == protobufBytes && field == 2 {
+					tag = string(data)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if tags == nil {
+				tags = make(map[string]interface{})
+				fields["tags"] = tags
+			}
+			tags[name] = tag
+		case field == 8 && wireType == protobufVarint:
+			fields["delta"] = value != 0
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return metricFromFields(fields)
+}
+
+//readProtobuf reads the fields of a protobuf message in order. Varint and fixed fields are passed as value,
+//length delimited fields as data
+func readProtobuf(body []byte, field func(field int, wireType int, value uint64, data []byte) error) error {
+	for len(body) > 0 {
+		key, n := binary.Uvarint(body)
+		if n <= 0 {
+			return errProtobufTruncated
+		}
+		body = body[n:]
+		number, wireType := int(key>>3), int(key&7)
+		if number == 0 {
+			return errors.New("invalid protobuf field number 0")
+		}
+		var value uint64
+		var data []byte
+		switch wireType {
+		case protobufVarint:
+			value, n = binary.Uvarint(body)
+			if n <= 0 {
+				return errProtobufTruncated
+			}
+			body = body[n:]
+		case protobufFixed64:
+			if len(body) < 8 {
+				return errProtobufTruncated
+			}
+			value = binary.LittleEndian.Uint64(body)
+			body = body[8:]
+		case protobufFixed32:
+			if len(body) < 4 {
+				return errProtobufTruncated
+			}
+			value = uint64(binary.LittleEndian.Uint32(body))
+			body = body[4:]
+		case protobufBytes:
+			length, n := binary.Uvarint(body)
+			if n <= 0 || length > uint64(len(body)-n) {
+				return errProtobufTruncated
+			}
+			data = body[n : n+int(length)]
+			body = body[n+int(length):]
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d", wireType)
+		}
+		err := field(number, wireType, value, data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,30 +1,46 @@
 package rabbit
 
 import (
-	"database/sql"
+	"context"
 	"fmt"
 
 	"time"
 
+	"github.com/ottogiron/metricsworker/tracing"
 	"github.com/ottogiron/metricsworker/worker"
 	"github.com/streadway/amqp"
 )
 
 var _ worker.Worker = (*AccountNameWorker)(nil)
+var _ worker.Lifecycle = (*AccountNameWorker)(nil)
 
 //AccountNameWorker implementation of distinctname worker
 type AccountNameWorker struct {
-	db *sql.DB
+	store worker.AccountStore
 }
 
 //NewAccountNameWorker returns a new instance of a distinctName worker
-func NewAccountNameWorker(db *sql.DB) *AccountNameWorker {
+func NewAccountNameWorker(store worker.AccountStore) *AccountNameWorker {
 
 	return &AccountNameWorker{
-		db: db,
+		store: store,
 	}
 }
 
+//Init initializes the account store and checks its connection
+func (w *AccountNameWorker) Init(ctx context.Context) error {
+	err := worker.Init(ctx, w.store)
+	if err != nil {
+		return fmt.Errorf("Failed to initialize the account store %s", err)
+	}
+	return nil
+}
+
+//Close closes the account store
+func (w *AccountNameWorker) Close(ctx context.Context) error {
+	return worker.Close(ctx, w.store)
+}
+
 //Execute executes a  AccountNameWorker  task
 func (w *AccountNameWorker) Execute(task interface{}) error {
 	delivery, ok := task.(amqp.Delivery)
@@ -39,15 +55,7 @@ func (w *AccountNameWorker) Execute(task interface{}) error {
 		return fmt.Errorf("Failed to unmarshall rabbit delivery body (%s) %s ", string(delivery.Body), err)
 	}
 
-	_, err = w.db.Exec(`
-		INSERT INTO accounts ("username", "timestamp")
-		Select CAST($1 AS VARCHAR), $2
-		Where not exists (
-		SELECT "username", "timestamp"
-		FROM accounts
-		WHERE username = $1
-)
-`, countMetric.UserName, time.Now().UTC().Unix())
+	err = w.store.InsertAccount(tracing.ContextFromTask(delivery), countMetric.UserName, countMetric.Tags, time.Now().UTC().Unix())
 
 	if err != nil {
 		return fmt.Errorf("Failed to insert account username into database %s %s", countMetric.UserName, err)
@@ -0,0 +1,100 @@
+package rabbit
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/ottogiron/metricsworker/worker/storetest"
+	"github.com/streadway/amqp"
+)
+
+func TestAccountNameWorker_Execute(t *testing.T) {
+	type args struct {
+		task interface{}
+	}
+	tests := []struct {
+		name     string
+		storeErr error
+		args     args
+		wantErr  bool
+	}{
+		{
+			"Store account",
+			nil,
+			args{
+				amqp.Delivery{
+					Body: validPayload,
+				},
+			},
+			false,
+		},
+		{
+			"Invalid payload",
+			nil,
+			args{
+				amqp.Delivery{
+					Body: invalidPayload,
+				},
+			},
+			true,
+		},
+		{
+			"Not a rabbit delivery",
+			nil,
+			args{
+				nil,
+			},
+			true,
+		},
+		{
+			"Store failure",
+			errors.New("store failure"),
+			args{
+				amqp.Delivery{
+					Body: validPayload,
+				},
+			},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := storetest.NewAccountStore()
+			store.Err = tt.storeErr
+			w := NewAccountNameWorker(store)
+			err := w.Execute(tt.args.task)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("AccountNameWorker.Execute() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if err != nil {
+				return
+			}
+
+			if _, ok := store.Account("kodingbot"); !ok {
+				t.Errorf("AccountNameWorker.Execute() expected account kodingbot to be stored")
+			}
+		})
+	}
+}
+
+func TestAccountNameWorker_Execute_Tags(t *testing.T) {
+	store := storetest.NewAccountStore()
+	w := NewAccountNameWorker(store)
+	for _, body := range [][]byte{
+		taggedPayload,
+		[]byte(`{"username": "kodingbot", "count": 1, "metric": "kite_call", "tags": {"plan": "pro", "version": "2"}}`),
+		validPayload,
+	} {
+		err := w.Execute(amqp.Delivery{Body: body})
+		if err != nil {
+			t.Fatalf("AccountNameWorker.Execute() error = %v", err)
+		}
+	}
+	want := map[string]string{"region": "eu", "plan": "pro", "version": "2"}
+	if tags := store.Tags("kodingbot"); !reflect.DeepEqual(tags, want) {
+		t.Errorf("AccountNameWorker.Execute() account tags = %v want %v", tags, want)
+	}
+}
@@ -0,0 +1,251 @@
+package rabbit
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ottogiron/metricsworker/worker"
+	"github.com/streadway/amqp"
+)
+
+var _ worker.Worker = (*ArchiveWorker)(nil)
+var _ worker.Lifecycle = (*ArchiveWorker)(nil)
+
+const (
+	archiveExtension     = ".ndjson"
+	archiveTimeFormat    = "20060102T150405.000000000Z"
+	defaultArchivePrefix = "metrics"
+)
+
+//ArchiveOption a functional option for the archive worker
+type ArchiveOption func(*ArchiveWorker)
+
+//SetArchivePrefix sets the archive files name prefix
+func SetArchivePrefix(prefix string) ArchiveOption {
+	return func(w *ArchiveWorker) {
+		w.prefix = prefix
+	}
+}
+
+//SetArchiveMaxSize sets the size in bytes an archive file is rotated at. 0 disables rotation by size
+func SetArchiveMaxSize(maxSize int64) ArchiveOption {
+	return func(w *ArchiveWorker) {
+		w.maxSize = maxSize
+	}
+}
+
+//SetArchiveMaxAge sets the age an archive file is rotated at. 0 disables rotation by time
+func SetArchiveMaxAge(maxAge time.Duration) ArchiveOption {
+	return func(w *ArchiveWorker) {
+		w.maxAge = maxAge
+	}
+}
+
+//SetArchiveCompress sets whether rotated archive files are gzip compressed
+func SetArchiveCompress(compress bool) ArchiveOption {
+	return func(w *ArchiveWorker) {
+		w.compress = compress
+	}
+}
+
+//ArchiveWorker appends every delivery and its metadata to newline delimited JSON files which can be replayed.
+//It should be registered with processor.ReceiveOriginal so it archives the deliveries as they were received
+type ArchiveWorker struct {
+	dir      string
+	prefix   string
+	maxSize  int64
+	maxAge   time.Duration
+	compress bool
+	now      func() time.Time
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+
+	//rotated files being compressed and the first compression error, returned by Close
+	compressing sync.WaitGroup
+	compressMu  sync.Mutex
+	compressErr error
+}
+
+//NewArchiveWorker returns a new instance of an archive worker writing files to dir
+func NewArchiveWorker(dir string, options ...ArchiveOption) *ArchiveWorker {
+	w := &ArchiveWorker{
+		dir:    dir,
+		prefix: defaultArchivePrefix,
+		now:    time.Now,
+	}
+	for _, option := range options {
+		option(w)
+	}
+	return w
+}
+
+//Execute executes an ArchiveWorker task. Bodies which are not plain JSON are archived as they are, see worker.ArchiveRecord
+func (w *ArchiveWorker) Execute(task interface{}) error {
+	delivery, ok := task.(amqp.Delivery)
+
+	if !ok {
+		return fmt.Errorf("Task should be a rabbit delivery %v", task)
+	}
+
+	line, err := json.Marshal(worker.NewArchiveRecord(delivery, w.now().UTC()))
+	if err != nil {
+		return fmt.Errorf("Failed to marshall archive record %s", err)
+	}
+	line = append(line, '\n')
+
+	rotated, err := w.write(line)
+	if rotated != "" && w.compress {
+		w.compressRotated(rotated)
+	}
+	return err
+}
+
+//compressRotated gzips a rotated file in the background, so the records being archived are not blocked and don't
+//fail with its error once they are written. The first compression error is returned by Close
+func (w *ArchiveWorker) compressRotated(name string) {
+	w.compressing.Add(1)
+	go func() {
+		defer w.compressing.Done()
+		err := compressFile(name)
+		if err == nil {
+			return
+		}
+		w.compressMu.Lock()
+		defer w.compressMu.Unlock()
+		if w.compressErr == nil {
+			w.compressErr = err
+		}
+	}()
+}
+
+//write appends a line to the current archive file, rotated is the name of the file closed before it if any
+func (w *ArchiveWorker) write(line []byte) (rotated string, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	rotated, err = w.rotate(int64(len(line)))
+	if err != nil {
+		return rotated, err
+	}
+	n, err := w.file.Write(line)
+	w.size += int64(n)
+	if err != nil {
+		return rotated, fmt.Errorf("Failed to write archive record %s", err)
+	}
+	return rotated, nil
+}
+
+//rotate opens a new archive file if there is no current one or the current one should be rotated.
+//rotated is the name of the file closed if any
+func (w *ArchiveWorker) rotate(next int64) (rotated string, err error) {
+	if w.file != nil {
+		bySize := w.maxSize > 0 && w.size > 0 && w.size+next > w.maxSize
+		byAge := w.maxAge > 0 && w.now().Sub(w.openedAt) >= w.maxAge
+		if !bySize && !byAge {
+			return "", nil
+		}
+		rotated, err = w.closeFile()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	err = os.MkdirAll(w.dir, 0755)
+	if err != nil {
+		return rotated, fmt.Errorf("Failed to create archive directory %s %s", w.dir, err)
+	}
+	w.openedAt = w.now()
+	name := filepath.Join(w.dir, w.prefix+"-"+w.openedAt.UTC().Format(archiveTimeFormat)+archiveExtension)
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return rotated, fmt.Errorf("Failed to open archive file %s %s", name, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return rotated, fmt.Errorf("Failed to stat archive file %s %s", name, err)
+	}
+	w.file = file
+	w.size = info.Size()
+	return rotated, nil
+}
+
+//closeFile closes the current archive file and returns its name
+func (w *ArchiveWorker) closeFile() (string, error) {
+	name := w.file.Name()
+	err := w.file.Close()
+	w.file = nil
+	w.size = 0
+	if err != nil {
+		return "", fmt.Errorf("Failed to close archive file %s %s", name, err)
+	}
+	return name, nil
+}
+
+//Init creates the archive directory
+func (w *ArchiveWorker) Init(ctx context.Context) error {
+	err := os.MkdirAll(w.dir, 0755)
+	if err != nil {
+		return fmt.Errorf("Failed to create archive directory %s %s", w.dir, err)
+	}
+	return nil
+}
+
+//Close closes the current archive file, compresses it if compression is enabled and waits for the rotated files
+//being compressed. It returns the first error closing or compressing a file
+func (w *ArchiveWorker) Close(ctx context.Context) error {
+	w.mu.Lock()
+	var name string
+	var err error
+	if w.file != nil {
+		name, err = w.closeFile()
+	}
+	w.mu.Unlock()
+	if name != "" && w.compress {
+		w.compressRotated(name)
+	}
+	w.compressing.Wait()
+	w.compressMu.Lock()
+	defer w.compressMu.Unlock()
+	if err == nil {
+		err = w.compressErr
+	}
+	w.compressErr = nil
+	return err
+}
+
+//compressFile gzips a file and removes the original one
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return fmt.Errorf("Failed to open archive file %s %s", name, err)
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("Failed to create compressed archive file %s %s", name, err)
+	}
+	gz := gzip.NewWriter(dst)
+	_, err = io.Copy(gz, src)
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(name + ".gz")
+		return fmt.Errorf("Failed to compress archive file %s %s", name, err)
+	}
+	return os.Remove(name)
+}
@@ -0,0 +1,202 @@
+package rabbit
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ottogiron/metricsworker/worker"
+	"github.com/streadway/amqp"
+)
+
+func newArchiveTestDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+//readArchive returns the records of every archive file in dir
+func readArchive(t *testing.T, dir string) (files []string, records []worker.ArchiveRecord) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range matches {
+		files = append(files, filepath.Base(name))
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var r io.Reader = f
+		if strings.HasSuffix(name, ".gz") {
+			r, err = gzip.NewReader(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			record, ok, err := worker.UnmarshallArchiveRecord(scanner.Bytes())
+			if err != nil || !ok {
+				t.Fatalf("Invalid archive record %s %v", scanner.Text(), err)
+			}
+			records = append(records, *record)
+		}
+		f.Close()
+	}
+	return files, records
+}
+
+func TestArchiveWorker_Execute(t *testing.T) {
+	statsd := []byte("kite_call:1|c|#username:kodingbot")
+	tests := []struct {
+		name     string
+		task     interface{}
+		wantErr  bool
+		wantBody bool
+		wantData []byte
+	}{
+		{"Archive delivery", amqp.Delivery{Body: validPayload, RoutingKey: "test-key", MessageId: "1"}, false, true, nil},
+		{"Archive StatsD delivery", amqp.Delivery{Body: statsd, ContentType: worker.ContentTypeStatsD, RoutingKey: "test-key", MessageId: "1"}, false, false, statsd},
+		{"Archive invalid payload", amqp.Delivery{Body: invalidPayload, RoutingKey: "test-key", MessageId: "1"}, false, false, invalidPayload},
+		{"Not a rabbit delivery", nil, true, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, clean := newArchiveTestDir(t)
+			defer clean()
+			w := NewArchiveWorker(dir)
+			err := w.Execute(tt.task)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ArchiveWorker.Execute() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			w.Close(context.Background())
+			if err != nil {
+				return
+			}
+			_, records := readArchive(t, dir)
+			if len(records) != 1 {
+				t.Fatalf("ArchiveWorker.Execute() records = %d want 1", len(records))
+			}
+			record := records[0]
+			if record.RoutingKey != "test-key" || record.MessageID != "1" || record.ReceivedAt.IsZero() {
+				t.Errorf("ArchiveWorker.Execute() record metadata = %+v", record)
+			}
+			if tt.wantBody {
+				metric, err := worker.UnmarshallCountMetric(record.Body)
+				if err != nil || metric.Metric != "kite_call" {
+					t.Errorf("ArchiveWorker.Execute() record body = %s", record.Body)
+				}
+			}
+			if string(record.Data) != string(tt.wantData) {
+				t.Errorf("ArchiveWorker.Execute() record data = %s want %s", record.Data, tt.wantData)
+			}
+			if delivery := record.Delivery(); delivery.ContentType != tt.task.(amqp.Delivery).ContentType {
+				t.Errorf("ArchiveWorker.Execute() record content type = %s", delivery.ContentType)
+			}
+		})
+	}
+}
+
+func TestArchiveWorker_Rotate(t *testing.T) {
+	tests := []struct {
+		name      string
+		options   []ArchiveOption
+		step      time.Duration
+		wantFiles int
+		wantExt   string
+	}{
+		{"No rotation", nil, time.Second, 1, ".ndjson"},
+		//Every record is a bit over 100 bytes so two records fit in a file
+		{"Rotate by size", []ArchiveOption{SetArchiveMaxSize(300)}, time.Second, 2, ".ndjson"},
+		{"Rotate by age", []ArchiveOption{SetArchiveMaxAge(time.Minute)}, time.Minute, 4, ".ndjson"},
+		{"Compress rotated files", []ArchiveOption{SetArchiveMaxAge(time.Minute), SetArchiveCompress(true)}, time.Minute, 4, ".ndjson.gz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, clean := newArchiveTestDir(t)
+			defer clean()
+			w := NewArchiveWorker(dir, tt.options...)
+			now := time.Date(2017, 4, 12, 0, 0, 0, 0, time.UTC)
+			w.now = func() time.Time {
+				return now
+			}
+			for i := 0; i < 4; i++ {
+				if err := w.Execute(amqp.Delivery{Body: validPayload}); err != nil {
+					t.Fatalf("ArchiveWorker.Execute() error = %v", err)
+				}
+				now = now.Add(tt.step)
+			}
+			if err := w.Close(context.Background()); err != nil {
+				t.Fatalf("ArchiveWorker.Close() error = %v", err)
+			}
+			files, records := readArchive(t, dir)
+			if len(files) != tt.wantFiles {
+				t.Errorf("ArchiveWorker.Execute() files = %v want %d files", files, tt.wantFiles)
+			}
+			for _, file := range files {
+				if !strings.HasSuffix(file, tt.wantExt) {
+					t.Errorf("ArchiveWorker.Execute() file = %s want extension %s", file, tt.wantExt)
+				}
+			}
+			if len(records) != 4 {
+				t.Errorf("ArchiveWorker.Execute() records = %d want 4", len(records))
+			}
+		})
+	}
+}
+
+func TestArchiveWorker_Execute_CompressionFailure(t *testing.T) {
+	dir, clean := newArchiveTestDir(t)
+	defer clean()
+	w := NewArchiveWorker(dir, SetArchiveMaxAge(time.Minute), SetArchiveCompress(true))
+	now := time.Date(2017, 4, 12, 0, 0, 0, 0, time.UTC)
+	w.now = func() time.Time {
+		return now
+	}
+	//the compressed file of the first archive file can't be created
+	rotated := filepath.Join(dir, "metrics-20170412T000000.000000000Z.ndjson")
+	if err := os.Mkdir(rotated+".gz", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := w.Execute(amqp.Delivery{Body: validPayload}); err != nil {
+			t.Fatalf("ArchiveWorker.Execute() error = %v want the record archived despite the compression failure", err)
+		}
+		now = now.Add(time.Minute)
+	}
+	if err := w.Close(context.Background()); err == nil {
+		t.Errorf("ArchiveWorker.Close() error = nil want the compression error")
+	}
+	//the rotated file is kept uncompressed
+	if _, err := os.Stat(rotated); err != nil {
+		t.Errorf("ArchiveWorker.Execute() rotated file %s", err)
+	}
+}
+
+func TestArchiveWorker_Init(t *testing.T) {
+	dir, clean := newArchiveTestDir(t)
+	defer clean()
+	archiveDir := filepath.Join(dir, "nested", "archive")
+	w := NewArchiveWorker(archiveDir)
+	if err := w.Init(context.Background()); err != nil {
+		t.Fatalf("ArchiveWorker.Init() error = %v", err)
+	}
+	if info, err := os.Stat(archiveDir); err != nil || !info.IsDir() {
+		t.Errorf("ArchiveWorker.Init() archive directory = %v %v", info, err)
+	}
+	if err := w.Close(context.Background()); err != nil {
+		t.Errorf("ArchiveWorker.Close() error = %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package rabbit
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ottogiron/metricsworker/processor"
+	"github.com/ottogiron/metricsworker/worker"
+	"github.com/streadway/amqp"
+)
+
+var _ processor.DeadLetterer = (*DeadLetterPublisher)(nil)
+
+//Dead letter headers, they identify the worker which gave up on the task and the error it failed with
+const (
+	DeadLetterWorkerHeader = "x-dead-letter-worker"
+	DeadLetterErrorHeader  = "x-dead-letter-error"
+)
+
+//DeadLetterPublisher publishes the tasks a worker gave up on to a durable queue per worker named <prefix>.<worker id>.
+//Consuming a queue with only its worker enabled processes the tasks again, they keep the time they were received
+//in the worker.EventTimeHeader
+type DeadLetterPublisher struct {
+	uri    string
+	prefix string
+	now    func() time.Time
+
+	mu       sync.Mutex
+	conn     *amqp.Connection
+	channel  *amqp.Channel
+	declared map[string]bool
+}
+
+//NewDeadLetterPublisher returns a new instance of a dead letter publisher connecting to the broker at uri lazily
+func NewDeadLetterPublisher(uri, prefix string) *DeadLetterPublisher {
+	return &DeadLetterPublisher{
+		uri:    uri,
+		prefix: prefix,
+		now:    time.Now,
+	}
+}
+
+//Queue returns the dead letter queue of a worker
+func (p *DeadLetterPublisher) Queue(workerID string) string {
+	return p.prefix + "." + workerID
+}
+
+//DeadLetter publishes a rabbit delivery to the dead letter queue of the worker which gave up on it
+func (p *DeadLetterPublisher) DeadLetter(workerID string, task interface{}, err error) error {
+	delivery, ok := task.(amqp.Delivery)
+	if !ok {
+		return fmt.Errorf("Task should be a rabbit delivery %v", task)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	queue := p.Queue(workerID)
+	channel, publishErr := p.connect(queue)
+	if publishErr == nil {
+		publishErr = channel.Publish("", queue, false, false, deadLetterPublishing(workerID, delivery, err, p.now()))
+	}
+	if publishErr != nil {
+		//the connection is opened again for the next task
+		p.close()
+		return fmt.Errorf("Failed to publish to dead letter queue %s %s", queue, publishErr)
+	}
+	return nil
+}
+
+//connect opens the connection and declares the queue if they were not yet, p.mu should be held
+func (p *DeadLetterPublisher) connect(queue string) (*amqp.Channel, error) {
+	if p.channel == nil {
+		conn, err := amqp.Dial(p.uri)
+		if err != nil {
+			return nil, err
+		}
+		channel, err := conn.Channel()
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		p.conn, p.channel, p.declared = conn, channel, make(map[string]bool)
+	}
+	if !p.declared[queue] {
+		_, err := p.channel.QueueDeclare(queue, true, false, false, false, nil)
+		if err != nil {
+			return nil, err
+		}
+		p.declared[queue] = true
+	}
+	return p.channel, nil
+}
+
+//close closes the connection, p.mu should be held
+func (p *DeadLetterPublisher) close() error {
+	if p.conn == nil {
+		return nil
+	}
+	err := p.conn.Close()
+	p.conn, p.channel = nil, nil
+	return err
+}
+
+//Close closes the connection to the broker
+func (p *DeadLetterPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.close()
+}
+
+//deadLetterPublishing returns the persistent message a delivery is dead-lettered as. It keeps the delivery body and
+//metadata, and the time the metric was received so workers process it into its original time
+func deadLetterPublishing(workerID string, delivery amqp.Delivery, err error, now time.Time) amqp.Publishing {
+	headers := amqp.Table{}
+	for key, value := range delivery.Headers {
+		headers[key] = value
+	}
+	if _, replayed := worker.EventTime(delivery); !replayed {
+		receivedAt := delivery.Timestamp
+		if receivedAt.IsZero() {
+			receivedAt = now
+		}
+		headers[worker.EventTimeHeader] = receivedAt.UTC().Format(time.RFC3339Nano)
+	}
+	headers[DeadLetterWorkerHeader] = workerID
+	if err != nil {
+		headers[DeadLetterErrorHeader] = err.Error()
+	}
+	return amqp.Publishing{
+		Headers:         headers,
+		ContentType:     delivery.ContentType,
+		ContentEncoding: delivery.ContentEncoding,
+		DeliveryMode:    amqp.Persistent,
+		MessageId:       delivery.MessageId,
+		Timestamp:       now,
+		Body:            delivery.Body,
+	}
+}
@@ -0,0 +1,62 @@
+package rabbit
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ottogiron/metricsworker/worker"
+	"github.com/streadway/amqp"
+)
+
+func Test_deadLetterPublishing(t *testing.T) {
+	now := time.Date(2017, 4, 12, 3, 0, 0, 0, time.UTC)
+	received := time.Date(2017, 4, 12, 2, 12, 13, 0, time.UTC)
+	tests := []struct {
+		name        string
+		delivery    amqp.Delivery
+		err         error
+		wantHeaders amqp.Table
+	}{
+		{
+			"Received delivery",
+			amqp.Delivery{Body: validPayload, MessageId: "1", ContentType: worker.ContentTypeJSON, Timestamp: received, Headers: amqp.Table{"traceparent": "00-1-2-01"}},
+			errors.New("store unavailable"),
+			amqp.Table{"traceparent": "00-1-2-01", worker.EventTimeHeader: "2017-04-12T02:12:13Z", DeadLetterWorkerHeader: "hourlyLog", DeadLetterErrorHeader: "store unavailable"},
+		},
+		{
+			"Replayed delivery keeps its event time",
+			amqp.Delivery{Body: validPayload, MessageId: "1", Timestamp: now, Headers: amqp.Table{worker.EventTimeHeader: "2017-04-11T10:00:00Z"}},
+			errors.New("store unavailable"),
+			amqp.Table{worker.EventTimeHeader: "2017-04-11T10:00:00Z", DeadLetterWorkerHeader: "hourlyLog", DeadLetterErrorHeader: "store unavailable"},
+		},
+		{
+			"Delivery without timestamp",
+			amqp.Delivery{Body: validPayload},
+			nil,
+			amqp.Table{worker.EventTimeHeader: "2017-04-12T03:00:00Z", DeadLetterWorkerHeader: "hourlyLog"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := deadLetterPublishing("hourlyLog", tt.delivery, tt.err, now)
+			if !reflect.DeepEqual(got.Headers, tt.wantHeaders) {
+				t.Errorf("deadLetterPublishing() headers = %v want %v", got.Headers, tt.wantHeaders)
+			}
+			if string(got.Body) != string(tt.delivery.Body) || got.MessageId != tt.delivery.MessageId || got.ContentType != tt.delivery.ContentType {
+				t.Errorf("deadLetterPublishing() = %+v want the delivery body and metadata", got)
+			}
+			if got.DeliveryMode != amqp.Persistent {
+				t.Errorf("deadLetterPublishing() delivery mode = %d want persistent", got.DeliveryMode)
+			}
+		})
+	}
+}
+
+func TestDeadLetterPublisher_DeadLetter_NotADelivery(t *testing.T) {
+	p := NewDeadLetterPublisher("amqp://localhost:1/", "dead-letters")
+	if err := p.DeadLetter("hourlyLog", "kite_call", nil); err == nil {
+		t.Error("DeadLetterPublisher.DeadLetter() error = nil want an error")
+	}
+}
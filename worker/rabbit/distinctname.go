@@ -1,30 +1,48 @@
 package rabbit
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 	"time"
 
-	"github.com/go-redis/redis"
+	"github.com/ottogiron/metricsworker/tracing"
 	"github.com/ottogiron/metricsworker/worker"
 	"github.com/streadway/amqp"
 )
 
 var _ worker.Worker = (*DistinctNameWorker)(nil)
+var _ worker.Lifecycle = (*DistinctNameWorker)(nil)
 
 const (
 	collectionName = "counters"
 	idCounter      = "distinctName:id"
+	//tagFieldPrefix prefix of the event hash fields holding the metric tags e.g. tag:region
+	tagFieldPrefix = worker.EventTagPrefix
 )
 
 //DistinctNameWorker implementation of distinctname worker
 type DistinctNameWorker struct {
-	rclient *redis.Client
+	store worker.EventStore
 }
 
 //NewDistincNameWorker returns a new instance of a distinctName worker
-func NewDistincNameWorker(client *redis.Client) *DistinctNameWorker {
-	return &DistinctNameWorker{client}
+func NewDistincNameWorker(store worker.EventStore) *DistinctNameWorker {
+	return &DistinctNameWorker{store}
+}
+
+//Init initializes the event store and checks its connection
+func (w *DistinctNameWorker) Init(ctx context.Context) error {
+	err := worker.Init(ctx, w.store)
+	if err != nil {
+		return fmt.Errorf("Failed to initialize the event store %s", err)
+	}
+	return nil
+}
+
+//Close closes the event store
+func (w *DistinctNameWorker) Close(ctx context.Context) error {
+	return worker.Close(ctx, w.store)
 }
 
 //Execute executes a  DistinctNameWorker  task
@@ -40,29 +58,48 @@ func (w *DistinctNameWorker) Execute(task interface{}) error {
 	if err != nil {
 		return fmt.Errorf("Failed to unmarshall rabbit delivery body (%s) %s ", string(delivery.Body), err)
 	}
+	err = flattenTags(countMetric)
+	if err != nil {
+		return err
+	}
 	eventName := countMetric["metric"].(string)
-	id := w.rclient.Incr(idCounter + ":" + eventName)
+	ctx := tracing.ContextFromTask(delivery)
+	id, err := w.store.NextID(ctx, eventName)
 
-	if id.Err() != nil {
-		return fmt.Errorf("Failed to create metric id %s", id.Err())
+	if err != nil {
+		return fmt.Errorf("Failed to create metric id %s", err)
 	}
 
-	eventID := eventName + ":" + strconv.FormatInt(id.Val(), 10)
-
-	p := w.rclient.Pipeline()
-
-	p.HMSet(eventID, countMetric)
+	eventID := eventName + ":" + strconv.FormatInt(id, 10)
 
 	nowTimestamp := time.Now().UTC().Unix()
-	p.ZAdd("events", redis.Z{
-		Score:  float64(nowTimestamp),
-		Member: eventID,
-	})
-
-	_, err = p.Exec()
+	err = w.store.SaveEvent(ctx, eventID, countMetric, nowTimestamp)
 
 	if err != nil {
-		return fmt.Errorf("Failed to store event in redis %s %v", err, countMetric)
+		return fmt.Errorf("Failed to store event %s %v", err, countMetric)
+	}
+	return nil
+}
+
+//flattenTags replaces the tags of a metric with a field for every tag, so they are stored as event hash fields
+func flattenTags(metric map[string]interface{}) error {
+	value, ok := metric["tags"]
+	if !ok {
+		return nil
+	}
+	delete(metric, "tags")
+	if value == nil {
+		return nil
+	}
+	tags, ok := value.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("Metric tags should be an object %v", value)
+	}
+	for name, tag := range tags {
+		if _, ok := tag.(string); !ok {
+			return fmt.Errorf("Metric tag %s should be a string %v", name, tag)
+		}
+		metric[tagFieldPrefix+name] = tag
 	}
 	return nil
 }
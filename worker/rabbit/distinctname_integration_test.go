@@ -0,0 +1,165 @@
+//go:build integration
+// +build integration
+
+package rabbit
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/ottogiron/metricsworker/worker"
+	"github.com/streadway/amqp"
+)
+
+func testRedisClient(t *testing.T) (*redis.Client, func()) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "", // no password set
+		DB:       2,  // use default DB
+	})
+
+	_, err := client.Ping().Result()
+	if err != nil {
+		t.Fatalf("Failed to connect to redis %s", err)
+	}
+	return client, func() {
+		client.FlushDb()
+	}
+}
+
+func TestDistinctNameWorker_ExecuteIntegration(t *testing.T) {
+	client, clean := testRedisClient(t)
+
+	type fields struct {
+		rclient *redis.Client
+	}
+	type args struct {
+		task interface{}
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		wantErr bool
+	}{
+		{
+			"Store EVent Succesfuly",
+			fields{client},
+			args{
+				amqp.Delivery{
+					Body: validPayload,
+				},
+			},
+			false,
+		},
+		{
+			"Fail to unmarshall",
+			fields{client},
+			args{
+				amqp.Delivery{
+					Body: invalidPayload,
+				},
+			},
+			true,
+		},
+		{
+			"Not a rabbit amqp delivery",
+			fields{client},
+			args{
+				nil,
+			},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer clean()
+			w := NewDistincNameWorker(NewRedisEventStore(tt.fields.rclient))
+			err := w.Execute(tt.args.task)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DistinctNameWorker.Execute() error = %v, wantErr %v", err, tt.wantErr)
+				return
+
+			}
+
+			if err != nil {
+				return
+			}
+
+			unixTimeNow := time.Now().UTC().Unix()
+			zSlice := client.ZRangeWithScores("events", 0, unixTimeNow+500)
+
+			delivery, ok := tt.args.task.(amqp.Delivery)
+
+			if !ok {
+				t.Errorf("DistinctNameWorker.Execute() could not unmarshall delivery")
+				return
+			}
+
+			metric, err := worker.UnmarshallCountMetric(delivery.Body)
+			if err != nil {
+				t.Errorf("DistinctNameWorker.Execute() could not unmarshall count metric")
+			}
+			for _, z := range zSlice.Val() {
+				val := client.HGet(z.Member.(string), "metric").Val()
+				if val != metric.Metric {
+					t.Errorf("DistincNameWorker.Execute() stored metric = %s want %v", val, metric.Metric)
+				}
+			}
+		})
+	}
+}
+
+func TestRedisEventStore_FindEventsIntegration(t *testing.T) {
+	client, clean := testRedisClient(t)
+	defer clean()
+	store := NewRedisEventStore(client)
+	w := NewDistincNameWorker(store)
+	err := w.Execute(amqp.Delivery{Body: taggedPayload})
+	if err != nil {
+		t.Fatalf("DistinctNameWorker.Execute() error = %v", err)
+	}
+	now := time.Now()
+	events, err := store.FindEvents(context.Background(), now.Add(-time.Minute), now.Add(time.Minute), 10)
+	if err != nil {
+		t.Fatalf("RedisEventStore.FindEvents() error = %v", err)
+	}
+	want := &worker.CountMetric{UserName: "kodingbot", Metric: "kite_call", Count: 1, Tags: map[string]string{"region": "eu", "plan": "free"}}
+	if len(events) != 1 || events[0].ID != "kite_call:1" || !reflect.DeepEqual(events[0].Metric, want) {
+		t.Errorf("RedisEventStore.FindEvents() = %+v want kite_call:1 %+v", events, want)
+	}
+	events, err = store.FindEvents(context.Background(), now.Add(time.Minute), time.Time{}, 10)
+	if err != nil || len(events) != 0 {
+		t.Errorf("RedisEventStore.FindEvents() = %+v, %v want no events", events, err)
+	}
+}
+
+func TestRedisEventStore_FindEventsLatestIntegration(t *testing.T) {
+	client, clean := testRedisClient(t)
+	defer clean()
+	store := NewRedisEventStore(client)
+	ctx := context.Background()
+	for i := 1; i <= 3; i++ {
+		fields := map[string]interface{}{"username": "kodingbot", "metric": "kite_call", "count": i}
+		err := store.SaveEvent(ctx, fmt.Sprintf("kite_call:%d", i), fields, int64(1496311200+i))
+		if err != nil {
+			t.Fatalf("RedisEventStore.SaveEvent() error = %v", err)
+		}
+	}
+	events, err := store.FindEvents(ctx, time.Time{}, time.Time{}, 2)
+	if err != nil {
+		t.Fatalf("RedisEventStore.FindEvents() error = %v", err)
+	}
+	var got []string
+	for _, event := range events {
+		got = append(got, event.ID)
+	}
+	if want := []string{"kite_call:3", "kite_call:2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("RedisEventStore.FindEvents() = %v want %v", got, want)
+	}
+}
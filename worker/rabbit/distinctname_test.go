@@ -1,49 +1,28 @@
 package rabbit
 
 import (
+	"errors"
+	"reflect"
 	"testing"
 
-	"time"
-
-	"github.com/go-redis/redis"
 	"github.com/ottogiron/metricsworker/worker"
+	"github.com/ottogiron/metricsworker/worker/storetest"
 	"github.com/streadway/amqp"
 )
 
-func testRedisClient(t *testing.T) (*redis.Client, func()) {
-	client := redis.NewClient(&redis.Options{
-		Addr:     "localhost:6379",
-		Password: "", // no password set
-		DB:       2,  // use default DB
-	})
-
-	_, err := client.Ping().Result()
-	if err != nil {
-		t.Fatalf("Failed to connect to redis %s", err)
-	}
-	return client, func() {
-		client.FlushDb()
-	}
-}
-
 func TestDistinctNameWorker_Execute(t *testing.T) {
-	client, clean := testRedisClient(t)
-
-	type fields struct {
-		rclient *redis.Client
-	}
 	type args struct {
 		task interface{}
 	}
 	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		wantErr bool
+		name     string
+		storeErr error
+		args     args
+		wantErr  bool
 	}{
 		{
-			"Store EVent Succesfuly",
-			fields{client},
+			"Store event succesfuly",
+			nil,
 			args{
 				amqp.Delivery{
 					Body: validPayload,
@@ -53,7 +32,7 @@ func TestDistinctNameWorker_Execute(t *testing.T) {
 		},
 		{
 			"Fail to unmarshall",
-			fields{client},
+			nil,
 			args{
 				amqp.Delivery{
 					Body: invalidPayload,
@@ -63,47 +42,97 @@ func TestDistinctNameWorker_Execute(t *testing.T) {
 		},
 		{
 			"Not a rabbit amqp delivery",
-			fields{client},
+			nil,
 			args{
 				nil,
 			},
 			true,
 		},
+		{
+			"Store failure",
+			errors.New("store failure"),
+			args{
+				amqp.Delivery{
+					Body: validPayload,
+				},
+			},
+			true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			defer clean()
-			w := NewDistincNameWorker(tt.fields.rclient)
+			store := storetest.NewEventStore()
+			store.Err = tt.storeErr
+			w := NewDistincNameWorker(store)
 			err := w.Execute(tt.args.task)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("DistinctNameWorker.Execute() error = %v, wantErr %v", err, tt.wantErr)
 				return
-
 			}
 
 			if err != nil {
 				return
 			}
 
-			unixTimeNow := time.Now().UTC().Unix()
-			zSlice := client.ZRangeWithScores("events", 0, unixTimeNow+500)
-
-			delivery, ok := tt.args.task.(amqp.Delivery)
-
-			if !ok {
-				t.Errorf("DistinctNameWorker.Execute() could not unmarshall delivery")
-				return
-			}
-
+			delivery := tt.args.task.(amqp.Delivery)
 			metric, err := worker.UnmarshallCountMetric(delivery.Body)
 			if err != nil {
 				t.Errorf("DistinctNameWorker.Execute() could not unmarshall count metric")
 			}
-			for _, z := range zSlice.Val() {
-				val := client.HGet(z.Member.(string), "metric").Val()
-				if val != metric.Metric {
-					t.Errorf("DistincNameWorker.Execute() stored metric = %s want %v", val, metric.Metric)
-				}
+
+			events := store.Events()
+			if len(events) != 1 {
+				t.Fatalf("DistinctNameWorker.Execute() stored events = %d want 1", len(events))
+			}
+			if want := metric.Metric + ":1"; events[0].ID != want {
+				t.Errorf("DistinctNameWorker.Execute() event id = %s want %s", events[0].ID, want)
+			}
+			if val := events[0].Fields["metric"]; val != metric.Metric {
+				t.Errorf("DistinctNameWorker.Execute() stored metric = %v want %v", val, metric.Metric)
+			}
+		})
+	}
+}
+
+func TestDistinctNameWorker_Execute_Tags(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       []byte
+		wantFields map[string]interface{}
+		wantErr    bool
+	}{
+		{
+			"Tags stored as fields",
+			taggedPayload,
+			map[string]interface{}{"username": "kodingbot", "count": float64(1), "metric": "kite_call", "tag:region": "eu", "tag:plan": "free"},
+			false,
+		},
+		{
+			"Tags not an object",
+			[]byte(`{"username": "kodingbot", "count": 1, "metric": "kite_call", "tags": "eu"}`),
+			nil,
+			true,
+		},
+		{
+			"Tag not a string",
+			[]byte(`{"username": "kodingbot", "count": 1, "metric": "kite_call", "tags": {"version": 2}}`),
+			nil,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := storetest.NewEventStore()
+			err := NewDistincNameWorker(store).Execute(amqp.Delivery{Body: tt.body})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DistinctNameWorker.Execute() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			events := store.Events()
+			if len(events) != 1 || !reflect.DeepEqual(events[0].Fields, tt.wantFields) {
+				t.Errorf("DistinctNameWorker.Execute() stored events = %v want fields %v", events, tt.wantFields)
 			}
 		})
 	}
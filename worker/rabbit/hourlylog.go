@@ -1,32 +1,44 @@
 package rabbit
 
 import (
+	"context"
 	"fmt"
 
-	mgo "gopkg.in/mgo.v2"
-
 	"time"
 
+	"github.com/ottogiron/metricsworker/tracing"
 	"github.com/ottogiron/metricsworker/worker"
 	"github.com/streadway/amqp"
 )
 
 var _ worker.Worker = (*HourlyLogWorker)(nil)
-
-const eventsCollectionName = "hourly_events"
+var _ worker.Lifecycle = (*HourlyLogWorker)(nil)
 
 //HourlyLogWorker implementation of distinctname worker
 type HourlyLogWorker struct {
-	mongoHosts string
-	dbName     string
+	store worker.HourlyLogStore
 }
 
 //NewHourlyLogWorker returns a new instance of a distinctName worker
-func NewHourlyLogWorker(eventsDB, mongoHosts string) *HourlyLogWorker {
-	return &HourlyLogWorker{mongoHosts: mongoHosts, dbName: eventsDB}
+func NewHourlyLogWorker(store worker.HourlyLogStore) *HourlyLogWorker {
+	return &HourlyLogWorker{store: store}
+}
+
+//Init initializes the hourly log store and checks its connection
+func (w *HourlyLogWorker) Init(ctx context.Context) error {
+	err := worker.Init(ctx, w.store)
+	if err != nil {
+		return fmt.Errorf("Failed to initialize the hourly log store %s", err)
+	}
+	return nil
 }
 
-//Execute executes a  DistinctNameWorker  task
+//Close closes the hourly log store
+func (w *HourlyLogWorker) Close(ctx context.Context) error {
+	return worker.Close(ctx, w.store)
+}
+
+//Execute executes a  HourlyLogWorker  task. Counts are logged, every metric is aggregated by hour
 func (w *HourlyLogWorker) Execute(task interface{}) error {
 	delivery, ok := task.(amqp.Delivery)
 
@@ -34,25 +46,29 @@ func (w *HourlyLogWorker) Execute(task interface{}) error {
 		return fmt.Errorf("Task should be a rabbit delivery %v", task)
 	}
 
-	countMetric, err := worker.UnmarshallCountMetric(delivery.Body)
+	metric, err := worker.UnmarshallMetric(delivery.Body)
 
 	if err != nil {
 		return fmt.Errorf("Failed to unmarshall rabbit delivery body (%s) %s ", string(delivery.Body), err)
 	}
 	now := time.Now().UTC()
-	//I'm assuming the time in which the event happened is the rabbit delivery timestamp
-	elapsed := now.Sub(delivery.Timestamp).Minutes()
+	//I'm assuming the time in which the event happened is the rabbit delivery timestamp. Replayed deliveries carry
+	//the time they were originally received, they are backfilled however old they are
+	eventTime, replayed := worker.EventTime(delivery)
+	elapsed := now.Sub(eventTime).Minutes()
 
-	if elapsed <= 60 {
-		session, err := mgo.Dial(w.mongoHosts)
-		if err != nil {
-			return fmt.Errorf("Dial to mongo servers failed %s %s", w.mongoHosts, err)
+	if elapsed <= 60 || replayed {
+		ctx := tracing.ContextFromTask(delivery)
+		//counts are logged one by one as well, every type is aggregated by hour
+		if countMetric, ok := metric.(*worker.CountMetric); ok {
+			err = w.store.InsertMetric(ctx, countMetric)
+			if err != nil {
+				return fmt.Errorf("Failed to insert metric %s %v", err, metric)
+			}
 		}
-		defer session.Close()
-		c := session.DB(w.dbName).C(eventsCollectionName)
-		err = c.Insert(countMetric)
+		err = w.store.AggregateMetric(ctx, metric, eventTime)
 		if err != nil {
-			return fmt.Errorf("Failed to insert metric %s %v", err, countMetric)
+			return fmt.Errorf("Failed to aggregate metric %s %v", err, metric)
 		}
 	}
 	return nil
@@ -0,0 +1,186 @@
+//go:build integration
+// +build integration
+
+package rabbit
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/ottogiron/metricsworker/worker"
+	"github.com/streadway/amqp"
+
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func newMongoTestSession(t *testing.T) (*HourlyLogWorker, *mgo.Collection, func()) {
+	host := "localhost"
+	session, err := mgo.Dial(host)
+	if err != nil {
+		t.Fatalf("Dial to mongo servers failed %s %s", host, err)
+	}
+	db := "testDB"
+	collection := session.DB(db).C(eventsCollectionName)
+	w := NewHourlyLogWorker(NewMongoHourlyLogStore(db, host))
+	return w, collection, func() {
+		defer session.Close()
+		session.DB(db).DropDatabase()
+	}
+}
+
+func TestHourlyLogWorker_ExecuteIntegration(t *testing.T) {
+
+	type args struct {
+		task interface{}
+	}
+	tests := []struct {
+		name string
+
+		args    args
+		wantErr bool
+	}{
+		{
+			"Store hourly log in database",
+			args{
+				amqp.Delivery{
+					Body:      validPayload,
+					Timestamp: time.Now(),
+				},
+			},
+			false,
+		},
+		{
+			"Invalid payload",
+			args{
+				amqp.Delivery{
+					Body:      invalidPayload,
+					Timestamp: time.Now(),
+				},
+			},
+			true,
+		},
+		{
+			"Not a rabbit delivery",
+			args{
+				amqp.Delivery{
+					Body:      invalidPayload,
+					Timestamp: time.Now(),
+				},
+			},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, collection, clean := newMongoTestSession(t)
+			defer clean()
+			err := w.Execute(tt.args.task)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("HourlyLogWorker.Execute() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if err != nil {
+				return
+			}
+
+			delivery, ok := tt.args.task.(amqp.Delivery)
+
+			if !ok {
+				t.Errorf("HourlyLogWorker.Execute() could not unmarshall delivery")
+				return
+			}
+
+			metric, err := worker.UnmarshallCountMetric(delivery.Body)
+			result := worker.CountMetric{}
+			err = collection.Find(bson.M{"metric": metric.Metric}).One(&result)
+			if err != nil {
+				t.Errorf("HourlyLogWorker.Execute() could not find the stored metric")
+			}
+		})
+	}
+}
+
+func TestHourlyLogWorker_ExecuteIntegration_Tags(t *testing.T) {
+	w, collection, clean := newMongoTestSession(t)
+	defer clean()
+	err := w.Execute(amqp.Delivery{Body: taggedPayload, Timestamp: time.Now()})
+	if err != nil {
+		t.Fatalf("HourlyLogWorker.Execute() error = %v", err)
+	}
+	var result hourlyLogDocument
+	err = collection.Find(bson.M{"key": "kite_call,plan=free,region=eu", "tags.region": "eu"}).One(&result)
+	if err != nil {
+		t.Fatalf("HourlyLogWorker.Execute() could not find the stored metric by key and tag %s", err)
+	}
+}
+
+func TestHourlyLogWorker_ExecuteIntegration_Types(t *testing.T) {
+	w, collection, clean := newMongoTestSession(t)
+	defer clean()
+	aggregates := collection.Database.C(aggregatesCollectionName)
+	for _, body := range []string{
+		`{"type": "histogram", "username": "kodingbot", "metric": "kite_call_ms", "value": 20}`,
+		`{"type": "histogram", "username": "kodingbot", "metric": "kite_call_ms", "value": 10}`,
+	} {
+		err := w.Execute(amqp.Delivery{Body: []byte(body), Timestamp: time.Now()})
+		if err != nil {
+			t.Fatalf("HourlyLogWorker.Execute() error = %v", err)
+		}
+	}
+	var result struct {
+		Count int64   `bson:"count"`
+		Sum   float64 `bson:"sum"`
+		Min   float64 `bson:"min"`
+		Max   float64 `bson:"max"`
+	}
+	err := aggregates.Find(bson.M{"type": worker.MetricTypeHistogram, "key": "kite_call_ms"}).One(&result)
+	if err != nil {
+		t.Fatalf("HourlyLogWorker.Execute() could not find the histogram aggregate %s", err)
+	}
+	if result.Count != 2 || result.Sum != 30 || result.Min != 10 || result.Max != 20 {
+		t.Errorf("HourlyLogWorker.Execute() histogram aggregate = %+v want count 2, sum 30, min 10 and max 20", result)
+	}
+}
+
+func TestMongoHourlyLogStore_FindAggregatesIntegration(t *testing.T) {
+	w, _, clean := newMongoTestSession(t)
+	defer clean()
+	now := time.Now()
+	for _, body := range []string{
+		`{"type": "gauge", "username": "kodingbot", "metric": "connections", "value": 2, "tags": {"region": "eu"}}`,
+		`{"type": "set", "username": "kodingbot", "metric": "visitors", "member": "v1"}`,
+		`{"username": "kodingbot", "metric": "kite_call", "count": 2}`,
+		`{"username": "kodingbot", "metric": "kite_call", "count": 3}`,
+	} {
+		err := w.Execute(amqp.Delivery{Body: []byte(body), Timestamp: now})
+		if err != nil {
+			t.Fatalf("HourlyLogWorker.Execute() error = %v", err)
+		}
+	}
+	store := w.store.(*MongoHourlyLogStore)
+	aggregates, err := store.FindAggregates(context.Background(), worker.AggregateQuery{UserName: "kodingbot", Metric: "connections", From: now, To: now})
+	if err != nil {
+		t.Fatalf("MongoHourlyLogStore.FindAggregates() error = %v", err)
+	}
+	want := []worker.Aggregate{{Type: worker.MetricTypeGauge, UserName: "kodingbot", Key: "connections,region=eu", Hour: now.UTC().Truncate(time.Hour), Value: 2}}
+	if !reflect.DeepEqual(aggregates, want) {
+		t.Errorf("MongoHourlyLogStore.FindAggregates() = %+v want %+v", aggregates, want)
+	}
+	aggregates, err = store.FindAggregates(context.Background(), worker.AggregateQuery{Type: worker.MetricTypeCount, Metric: "kite_call"})
+	if err != nil {
+		t.Fatalf("MongoHourlyLogStore.FindAggregates() error = %v", err)
+	}
+	want = []worker.Aggregate{{Type: worker.MetricTypeCount, UserName: "kodingbot", Key: "kite_call", Hour: now.UTC().Truncate(time.Hour), Count: 5}}
+	if !reflect.DeepEqual(aggregates, want) {
+		t.Errorf("MongoHourlyLogStore.FindAggregates() = %+v want %+v", aggregates, want)
+	}
+	aggregates, err = store.FindAggregates(context.Background(), worker.AggregateQuery{From: now.Add(time.Hour)})
+	if err != nil || len(aggregates) != 0 {
+		t.Errorf("MongoHourlyLogStore.FindAggregates() = %+v, %v want no aggregates", aggregates, err)
+	}
+}
@@ -1,45 +1,33 @@
 package rabbit
 
 import (
+	"errors"
+	"reflect"
 	"testing"
 
-	"github.com/ottogiron/metricsworker/worker"
-	"github.com/streadway/amqp"
-
 	"time"
 
-	"gopkg.in/mgo.v2"
+	"github.com/ottogiron/metricsworker/worker"
+	"github.com/ottogiron/metricsworker/worker/storetest"
+	"github.com/streadway/amqp"
+	mgo "gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
-func newMongoTestSession(t *testing.T) (*HourlyLogWorker, *mgo.Collection, func()) {
-	host := "localhost"
-	session, err := mgo.Dial(host)
-	if err != nil {
-		t.Fatalf("Dial to mongo servers failed %s %s", host, err)
-	}
-	db := "testDB"
-	collection := session.DB(db).C(eventsCollectionName)
-	w := NewHourlyLogWorker(db, host)
-	return w, collection, func() {
-		defer session.Close()
-		session.DB(db).DropDatabase()
-	}
-}
-
 func TestHourlyLogWorker_Execute(t *testing.T) {
-
 	type args struct {
 		task interface{}
 	}
 	tests := []struct {
-		name string
-
-		args    args
-		wantErr bool
+		name        string
+		storeErr    error
+		args        args
+		wantErr     bool
+		wantMetrics int
 	}{
 		{
-			"Store hourly log in database",
+			"Store hourly log",
+			nil,
 			args{
 				amqp.Delivery{
 					Body:      validPayload,
@@ -47,9 +35,36 @@ func TestHourlyLogWorker_Execute(t *testing.T) {
 				},
 			},
 			false,
+			1,
+		},
+		{
+			"Skip deliveries older than an hour",
+			nil,
+			args{
+				amqp.Delivery{
+					Body:      validPayload,
+					Timestamp: time.Now().Add(-2 * time.Hour),
+				},
+			},
+			false,
+			0,
+		},
+		{
+			"Backfill replayed deliveries older than an hour",
+			nil,
+			args{
+				amqp.Delivery{
+					Body:      validPayload,
+					Timestamp: time.Now(),
+					Headers:   amqp.Table{worker.EventTimeHeader: time.Now().Add(-2 * time.Hour)},
+				},
+			},
+			false,
+			1,
 		},
 		{
 			"Invalid payload",
+			nil,
 			args{
 				amqp.Delivery{
 					Body:      invalidPayload,
@@ -57,44 +72,197 @@ func TestHourlyLogWorker_Execute(t *testing.T) {
 				},
 			},
 			true,
+			0,
 		},
 		{
 			"Not a rabbit delivery",
+			nil,
+			args{
+				nil,
+			},
+			true,
+			0,
+		},
+		{
+			"Store failure",
+			errors.New("store failure"),
 			args{
 				amqp.Delivery{
-					Body:      invalidPayload,
+					Body:      validPayload,
 					Timestamp: time.Now(),
 				},
 			},
 			true,
+			0,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			w, collection, clean := newMongoTestSession(t)
-			defer clean()
+			store := storetest.NewHourlyLogStore()
+			store.Err = tt.storeErr
+			w := NewHourlyLogWorker(store)
 			err := w.Execute(tt.args.task)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("HourlyLogWorker.Execute() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 
-			if err != nil {
-				return
+			metrics := store.Metrics()
+			if len(metrics) != tt.wantMetrics {
+				t.Fatalf("HourlyLogWorker.Execute() stored metrics = %d want %d", len(metrics), tt.wantMetrics)
+			}
+			for _, metric := range metrics {
+				if metric.Metric != "kite_call" {
+					t.Errorf("HourlyLogWorker.Execute() stored metric = %s want %s", metric.Metric, "kite_call")
+				}
 			}
+		})
+	}
+}
 
-			delivery, ok := tt.args.task.(amqp.Delivery)
+func TestHourlyLogWorker_Execute_Tags(t *testing.T) {
+	store := storetest.NewHourlyLogStore()
+	err := NewHourlyLogWorker(store).Execute(amqp.Delivery{Body: taggedPayload, Timestamp: time.Now()})
+	if err != nil {
+		t.Fatalf("HourlyLogWorker.Execute() error = %v", err)
+	}
+	metrics := store.Metrics()
+	want := map[string]string{"region": "eu", "plan": "free"}
+	if len(metrics) != 1 || !reflect.DeepEqual(metrics[0].Tags, want) {
+		t.Fatalf("HourlyLogWorker.Execute() stored metrics = %v want tags %v", metrics, want)
+	}
+	document := newHourlyLogDocument(&metrics[0])
+	if want := "kite_call,plan=free,region=eu"; document.Key != want || !reflect.DeepEqual(document.Tags, metrics[0].Tags) {
+		t.Errorf("newHourlyLogDocument() = %+v want key %s", document, want)
+	}
+}
 
-			if !ok {
-				t.Errorf("HourlyLogWorker.Execute() could not unmarshall delivery")
-				return
+func TestHourlyLogWorker_Execute_Types(t *testing.T) {
+	store := storetest.NewHourlyLogStore()
+	w := NewHourlyLogWorker(store)
+	now := time.Now().UTC()
+	bodies := []string{
+		`{"username": "kodingbot", "metric": "kite_call", "count": 2}`,
+		`{"username": "kodingbot", "metric": "kite_call", "count": 3}`,
+		`{"type": "gauge", "username": "kodingbot", "metric": "connections", "value": 3}`,
+		`{"type": "gauge", "username": "kodingbot", "metric": "connections", "value": 7}`,
+		`{"type": "histogram", "username": "kodingbot", "metric": "kite_call_ms", "value": 20}`,
+		`{"type": "histogram", "username": "kodingbot", "metric": "kite_call_ms", "value": 10}`,
+		`{"type": "set", "username": "kodingbot", "metric": "visitors", "member": "v1"}`,
+		`{"type": "set", "username": "kodingbot", "metric": "visitors", "member": "v1"}`,
+	}
+	for _, body := range bodies {
+		err := w.Execute(amqp.Delivery{Body: []byte(body), Timestamp: now})
+		if err != nil {
+			t.Fatalf("HourlyLogWorker.Execute() error = %v", err)
+		}
+	}
+	//counts are logged as well
+	if metrics := store.Metrics(); len(metrics) != 2 {
+		t.Errorf("HourlyLogWorker.Execute() stored metrics = %v want the counts", metrics)
+	}
+	hour := now.Truncate(time.Hour)
+	want := []worker.Aggregate{
+		{Type: worker.MetricTypeCount, UserName: "kodingbot", Key: "kite_call", Hour: hour, Count: 5},
+		{Type: worker.MetricTypeGauge, UserName: "kodingbot", Key: "connections", Hour: hour, Value: 7},
+		{Type: worker.MetricTypeHistogram, UserName: "kodingbot", Key: "kite_call_ms", Hour: hour, Count: 2, Sum: 30, Min: 10, Max: 20},
+		{Type: worker.MetricTypeSet, UserName: "kodingbot", Key: "visitors", Hour: hour, Members: []string{"v1"}},
+	}
+	if got := store.Aggregates(); !reflect.DeepEqual(got, want) {
+		t.Errorf("HourlyLogWorker.Execute() aggregates = %+v want %+v", got, want)
+	}
+
+	err := w.Execute(amqp.Delivery{Body: []byte(`{"type": "summary", "username": "kodingbot", "metric": "kite_call"}`), Timestamp: now})
+	if err == nil {
+		t.Error("HourlyLogWorker.Execute() error = nil want an unknown type error")
+	}
+}
+
+func Test_aggregateUpsert(t *testing.T) {
+	timestamp := time.Date(2017, 3, 1, 10, 42, 0, 0, time.UTC)
+	hour := time.Date(2017, 3, 1, 10, 0, 0, 0, time.UTC)
+	tags := map[string]string{"region": "eu"}
+	tests := []struct {
+		name         string
+		metric       worker.TypedMetric
+		wantSelector bson.M
+		wantUpdate   bson.M
+	}{
+		{
+			"Count",
+			&worker.CountMetric{UserName: "kodingbot", Metric: "kite_call", Count: 2},
+			bson.M{"type": worker.MetricTypeCount, "username": "kodingbot", "key": "kite_call", "hour": hour},
+			bson.M{"$setOnInsert": bson.M{"metric": "kite_call"}, "$inc": bson.M{"count": int64(2)}},
+		},
+		{
+			"Gauge",
+			&worker.GaugeMetric{UserName: "kodingbot", Metric: "connections", Tags: tags, Value: 3},
+			bson.M{"type": worker.MetricTypeGauge, "username": "kodingbot", "key": "connections,region=eu", "hour": hour},
+			bson.M{"$setOnInsert": bson.M{"metric": "connections", "tags": tags}, "$set": bson.M{"value": float64(3)}},
+		},
+		{
+			"Gauge delta",
+			&worker.GaugeMetric{UserName: "kodingbot", Metric: "connections", Value: -2, Delta: true},
+			bson.M{"type": worker.MetricTypeGauge, "username": "kodingbot", "key": "connections", "hour": hour},
+			bson.M{"$setOnInsert": bson.M{"metric": "connections"}, "$inc": bson.M{"value": float64(-2)}},
+		},
+		{
+			"Histogram",
+			&worker.HistogramMetric{UserName: "kodingbot", Metric: "kite_call_ms", Value: 20},
+			bson.M{"type": worker.MetricTypeHistogram, "username": "kodingbot", "key": "kite_call_ms", "hour": hour},
+			bson.M{
+				"$setOnInsert": bson.M{"metric": "kite_call_ms"},
+				"$inc":         bson.M{"count": 1, "sum": float64(20)},
+				"$min":         bson.M{"min": float64(20)},
+				"$max":         bson.M{"max": float64(20)},
+			},
+		},
+		{
+			"Set",
+			&worker.SetMetric{UserName: "kodingbot", Metric: "visitors", Member: "v1"},
+			bson.M{"type": worker.MetricTypeSet, "username": "kodingbot", "key": "visitors", "hour": hour},
+			bson.M{"$setOnInsert": bson.M{"metric": "visitors"}, "$addToSet": bson.M{"members": "v1"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector, update := aggregateUpsert(tt.metric, timestamp)
+			if !reflect.DeepEqual(selector, tt.wantSelector) {
+				t.Errorf("aggregateUpsert() selector = %v want %v", selector, tt.wantSelector)
 			}
+			if !reflect.DeepEqual(update, tt.wantUpdate) {
+				t.Errorf("aggregateUpsert() update = %v want %v", update, tt.wantUpdate)
+			}
+		})
+	}
+}
 
-			metric, err := worker.UnmarshallCountMetric(delivery.Body)
-			result := worker.CountMetric{}
-			err = collection.Find(bson.M{"metric": metric.Metric}).One(&result)
-			if err != nil {
-				t.Errorf("HourlyLogWorker.Execute() could not find the stored metric")
+func Test_retryDuplicate(t *testing.T) {
+	duplicate := &mgo.LastError{Code: 11000, Err: "E11000 duplicate key error"}
+	failure := errors.New("connection refused")
+	tests := []struct {
+		name      string
+		errs      []error
+		wantErr   error
+		wantCalls int
+	}{
+		{"Upserted", []error{nil}, nil, 1},
+		{"Duplicate key retried", []error{duplicate, nil}, nil, 2},
+		{"Duplicate key retried once", []error{duplicate, duplicate}, duplicate, 2},
+		{"Other errors not retried", []error{failure}, failure, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := retryDuplicate(func() error {
+				calls++
+				return tt.errs[calls-1]
+			})
+			if err != tt.wantErr {
+				t.Errorf("retryDuplicate() error = %v want %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("retryDuplicate() upserts = %d want %d", calls, tt.wantCalls)
 			}
 		})
 	}
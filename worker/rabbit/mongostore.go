@@ -0,0 +1,275 @@
+package rabbit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ottogiron/metricsworker/tracing"
+	"github.com/ottogiron/metricsworker/worker"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var _ worker.HourlyLogStore = (*MongoHourlyLogStore)(nil)
+var _ worker.AggregateReader = (*MongoHourlyLogStore)(nil)
+var _ worker.Lifecycle = (*MongoHourlyLogStore)(nil)
+
+const (
+	eventsCollectionName     = "hourly_events"
+	aggregatesCollectionName = "hourly_aggregates"
+)
+
+//defaultMongoDialTimeout timeout dialing mongo when the init context has no deadline
+const defaultMongoDialTimeout = 10 * time.Second
+
+//hourlyLogDocument document of a metric in the hourly events collection
+type hourlyLogDocument struct {
+	UserName string            `bson:"username"`
+	Count    int64             `bson:"count"`
+	Metric   string            `bson:"metric"`
+	Tags     map[string]string `bson:"tags,omitempty"`
+	//Key groups the metrics by name and tags, see worker.CountMetric.SeriesKey
+	Key string `bson:"key"`
+}
+
+func newHourlyLogDocument(metric *worker.CountMetric) hourlyLogDocument {
+	return hourlyLogDocument{
+		UserName: metric.UserName,
+		Count:    metric.Count,
+		Metric:   metric.Metric,
+		Tags:     metric.Tags,
+		Key:      metric.SeriesKey(),
+	}
+}
+
+//MongoHourlyLogStore mongo implementation of an hourly log store
+type MongoHourlyLogStore struct {
+	mongoHosts string
+	dbName     string
+	//session opened by Init, metrics are inserted in copies of it
+	session *mgo.Session
+}
+
+//NewMongoHourlyLogStore returns a new instance of a mongo hourly log store
+func NewMongoHourlyLogStore(eventsDB, mongoHosts string) *MongoHourlyLogStore {
+	return &MongoHourlyLogStore{mongoHosts: mongoHosts, dbName: eventsDB}
+}
+
+//Init dials the mongo servers and checks the connection
+func (s *MongoHourlyLogStore) Init(ctx context.Context) error {
+	timeout := defaultMongoDialTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	session, err := mgo.DialWithTimeout(s.mongoHosts, timeout)
+	if err != nil {
+		return fmt.Errorf("Dial to mongo servers failed %s %s", s.mongoHosts, err)
+	}
+	err = session.Ping()
+	if err != nil {
+		session.Close()
+		return fmt.Errorf("Failed to connect to mongo %s %s", s.mongoHosts, err)
+	}
+	//metrics are grouped by key
+	err = session.DB(s.dbName).C(eventsCollectionName).EnsureIndexKey("key")
+	if err != nil {
+		session.Close()
+		return fmt.Errorf("Failed to index the hourly events by key %s", err)
+	}
+	//an aggregate is upserted by type, user, key and hour, the unique index keeps concurrent upserts from duplicating it
+	err = session.DB(s.dbName).C(aggregatesCollectionName).EnsureIndex(mgo.Index{
+		Key:    []string{"type", "username", "key", "hour"},
+		Unique: true,
+	})
+	if err != nil {
+		session.Close()
+		return fmt.Errorf("Failed to index the hourly aggregates %s", err)
+	}
+	s.session = session
+	return nil
+}
+
+//Close closes the session opened by Init
+func (s *MongoHourlyLogStore) Close(ctx context.Context) error {
+	if s.session != nil {
+		s.session.Close()
+		s.session = nil
+	}
+	return nil
+}
+
+//copySession returns a copy of the session opened by Init, the mongo servers are dialed if the store was not initialized
+func (s *MongoHourlyLogStore) copySession() (*mgo.Session, error) {
+	if s.session != nil {
+		return s.session.Copy(), nil
+	}
+	session, err := mgo.Dial(s.mongoHosts)
+	if err != nil {
+		return nil, fmt.Errorf("Dial to mongo servers failed %s %s", s.mongoHosts, err)
+	}
+	return session, nil
+}
+
+//InsertMetric inserts a metric in the hourly events collection. Tags are stored as a document and the metric key
+//grouping the metrics by name and tags along with them. The mongo servers are dialed on every insert if the store was not initialized
+func (s *MongoHourlyLogStore) InsertMetric(ctx context.Context, metric *worker.CountMetric) (err error) {
+	_, span := tracing.Start(ctx, "mongo.insert")
+	span.SetAttribute("db.system", "mongodb")
+	span.SetAttribute("db.collection", eventsCollectionName)
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+	session, err := s.copySession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	c := session.DB(s.dbName).C(eventsCollectionName)
+	return c.Insert(newHourlyLogDocument(metric))
+}
+
+//AggregateMetric upserts the hourly aggregate of a metric in the hourly aggregates collection, see worker.Aggregate.Add.
+//Upserts failing with a duplicate key error are retried once, see retryDuplicate.
+//The mongo servers are dialed on every call if the store was not initialized
+func (s *MongoHourlyLogStore) AggregateMetric(ctx context.Context, metric worker.TypedMetric, timestamp time.Time) (err error) {
+	_, span := tracing.Start(ctx, "mongo.upsert")
+	span.SetAttribute("db.system", "mongodb")
+	span.SetAttribute("db.collection", aggregatesCollectionName)
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+	session, err := s.copySession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	selector, update := aggregateUpsert(metric, timestamp)
+	c := session.DB(s.dbName).C(aggregatesCollectionName)
+	return retryDuplicate(func() error {
+		_, err := c.Upsert(selector, update)
+		return err
+	})
+}
+
+//retryDuplicate retries an upsert once if it fails with a duplicate key error. Concurrent upserts of a missing
+//aggregate may both try to insert it, the unique index rejects the second one which then matches the inserted aggregate
+func retryDuplicate(upsert func() error) error {
+	err := upsert()
+	if mgo.IsDup(err) {
+		err = upsert()
+	}
+	return err
+}
+
+//aggregateDocument document of an hourly aggregate in the hourly aggregates collection, see aggregateUpsert
+type aggregateDocument struct {
+	Type     worker.MetricType `bson:"type"`
+	UserName string            `bson:"username"`
+	Key      string            `bson:"key"`
+	Hour     time.Time         `bson:"hour"`
+	Value    float64           `bson:"value"`
+	Count    int64             `bson:"count"`
+	Sum      float64           `bson:"sum"`
+	Min      float64           `bson:"min"`
+	Max      float64           `bson:"max"`
+	Members  []string          `bson:"members"`
+}
+
+//FindAggregates finds the hourly aggregates matching a query in the hourly aggregates collection.
+//The mongo servers are dialed on every call if the store was not initialized
+func (s *MongoHourlyLogStore) FindAggregates(ctx context.Context, query worker.AggregateQuery) (aggregates []worker.Aggregate, err error) {
+	_, span := tracing.Start(ctx, "mongo.find")
+	span.SetAttribute("db.system", "mongodb")
+	span.SetAttribute("db.collection", aggregatesCollectionName)
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+	session, err := s.copySession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+	var documents []aggregateDocument
+	q := session.DB(s.dbName).C(aggregatesCollectionName).Find(aggregateSelector(query)).Sort("hour", "type", "key", "username")
+	if query.Limit > 0 {
+		q = q.Limit(query.Limit)
+	}
+	err = q.All(&documents)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to find the hourly aggregates %s", err)
+	}
+	aggregates = make([]worker.Aggregate, 0, len(documents))
+	for _, d := range documents {
+		aggregates = append(aggregates, worker.Aggregate{
+			Type:     d.Type,
+			UserName: d.UserName,
+			Key:      d.Key,
+			Hour:     d.Hour.UTC(),
+			Value:    d.Value,
+			Count:    d.Count,
+			Sum:      d.Sum,
+			Min:      d.Min,
+			Max:      d.Max,
+			Members:  d.Members,
+		})
+	}
+	return aggregates, nil
+}
+
+//aggregateSelector returns the selector of the hourly aggregates matching a query
+func aggregateSelector(query worker.AggregateQuery) bson.M {
+	selector := bson.M{}
+	if query.Type != "" {
+		selector["type"] = query.Type
+	}
+	if query.UserName != "" {
+		selector["username"] = query.UserName
+	}
+	if query.Metric != "" {
+		selector["metric"] = query.Metric
+	}
+	hour := bson.M{}
+	if !query.From.IsZero() {
+		hour["$gte"] = query.From.UTC().Truncate(time.Hour)
+	}
+	if !query.To.IsZero() {
+		hour["$lte"] = query.To.UTC()
+	}
+	if len(hour) > 0 {
+		selector["hour"] = hour
+	}
+	return selector
+}
+
+//aggregateUpsert returns the selector of the hourly aggregate of a metric and the update aggregating it
+func aggregateUpsert(metric worker.TypedMetric, timestamp time.Time) (bson.M, bson.M) {
+	aggregate := worker.NewAggregate(metric, timestamp)
+	id := metric.Identity()
+	selector := bson.M{"type": aggregate.Type, "username": aggregate.UserName, "key": aggregate.Key, "hour": aggregate.Hour}
+	onInsert := bson.M{"metric": id.Metric}
+	if len(id.Tags) > 0 {
+		onInsert["tags"] = id.Tags
+	}
+	update := bson.M{"$setOnInsert": onInsert}
+	switch m := metric.(type) {
+	case *worker.CountMetric:
+		update["$inc"] = bson.M{"count": m.Count}
+	case *worker.GaugeMetric:
+		if m.Delta {
+			update["$inc"] = bson.M{"value": m.Value}
+			break
+		}
+		update["$set"] = bson.M{"value": m.Value}
+	case *worker.HistogramMetric:
+		update["$inc"] = bson.M{"count": 1, "sum": m.Value}
+		update["$min"] = bson.M{"min": m.Value}
+		update["$max"] = bson.M{"max": m.Value}
+	case *worker.SetMetric:
+		update["$addToSet"] = bson.M{"members": m.Member}
+	}
+	return selector, update
+}
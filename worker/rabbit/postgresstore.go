@@ -0,0 +1,160 @@
+package rabbit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ottogiron/metricsworker/tracing"
+	"github.com/ottogiron/metricsworker/worker"
+)
+
+var _ worker.AccountStore = (*PostgresAccountStore)(nil)
+var _ worker.AccountReader = (*PostgresAccountStore)(nil)
+var _ worker.Lifecycle = (*PostgresAccountStore)(nil)
+
+//PostgresAccountStore postgres implementation of an account store
+type PostgresAccountStore struct {
+	db *sql.DB
+}
+
+//NewPostgresAccountStore returns a new instance of a postgres account store
+func NewPostgresAccountStore(db *sql.DB) *PostgresAccountStore {
+	return &PostgresAccountStore{db: db}
+}
+
+//Init checks the postgres connection
+func (s *PostgresAccountStore) Init(ctx context.Context) error {
+	err := s.db.PingContext(ctx)
+	if err != nil {
+		return fmt.Errorf("Failed to connect to postgres %s", err)
+	}
+	return nil
+}
+
+//Close closes the database
+func (s *PostgresAccountStore) Close(ctx context.Context) error {
+	return s.db.Close()
+}
+
+//InsertAccount inserts an account username if it does not exist already. The tags are merged into the account
+//tags JSONB column, merging requires the tags column and a unique index on the username column
+func (s *PostgresAccountStore) InsertAccount(ctx context.Context, username string, tags map[string]string, timestamp int64) (err error) {
+	ctx, span := tracing.Start(ctx, "postgres.exec")
+	span.SetAttribute("db.system", "postgresql")
+	span.SetAttribute("db.table", "accounts")
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+	//untagged accounts are inserted without the tags column, so tables which were not migrated keep working
+	if len(tags) == 0 {
+		_, err = s.db.ExecContext(ctx, `
+		INSERT INTO accounts ("username", "timestamp")
+		Select CAST($1 AS VARCHAR), $2
+		Where not exists (
+		SELECT "username", "timestamp"
+		FROM accounts
+		WHERE username = $1
+)
+		ON CONFLICT DO NOTHING
+`, username, timestamp)
+		return err
+	}
+	tagsJSON, err := json.Marshal(tags)
+	if err != nil {
+		return fmt.Errorf("Failed to encode account tags %s", err)
+	}
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO accounts ("username", "timestamp", "tags")
+		VALUES (CAST($1 AS VARCHAR), $2, CAST($3 AS JSONB))
+		ON CONFLICT ("username") DO UPDATE
+		SET "tags" = COALESCE(accounts."tags", '{}'::JSONB) || EXCLUDED."tags"
+`, username, timestamp, string(tagsJSON))
+	return err
+}
+
+//FindAccounts selects the accounts ordered by username. A NULL limit selects every account
+func (s *PostgresAccountStore) FindAccounts(ctx context.Context, offset, limit int) (accounts []worker.Account, err error) {
+	_, span := tracing.Start(ctx, "postgres.query")
+	span.SetAttribute("db.system", "postgresql")
+	span.SetAttribute("db.table", "accounts")
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT "username", "timestamp", "tags" FROM accounts
+		ORDER BY "username"
+		LIMIT NULLIF($1, 0) OFFSET $2
+`, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to select accounts %s", err)
+	}
+	defer rows.Close()
+	accounts = []worker.Account{}
+	for rows.Next() {
+		account, err := scanAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, *account)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to select accounts %s", err)
+	}
+	return accounts, nil
+}
+
+//FindAccount selects an account by username
+func (s *PostgresAccountStore) FindAccount(ctx context.Context, username string) (account *worker.Account, err error) {
+	_, span := tracing.Start(ctx, "postgres.query")
+	span.SetAttribute("db.system", "postgresql")
+	span.SetAttribute("db.table", "accounts")
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+	row := s.db.QueryRowContext(ctx, `
+		SELECT "username", "timestamp", "tags" FROM accounts
+		WHERE "username" = CAST($1 AS VARCHAR)
+`, username)
+	account, err = scanAccount(row)
+	if err == sql.ErrNoRows {
+		return nil, worker.ErrNotFound
+	}
+	return account, err
+}
+
+//scanner is implemented by sql.Row and sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+//scanAccount scans the username, timestamp and tags of an account. Accounts stored before tags were added have NULL tags
+func scanAccount(row scanner) (*worker.Account, error) {
+	var account worker.Account
+	var timestamp int64
+	var tagsJSON []byte
+	err := row.Scan(&account.UserName, &timestamp, &tagsJSON)
+	if err == sql.ErrNoRows {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read account %s", err)
+	}
+	account.Timestamp = time.Unix(timestamp, 0).UTC()
+	if len(tagsJSON) > 0 {
+		err = json.Unmarshal(tagsJSON, &account.Tags)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decode account tags %s %s", account.UserName, err)
+		}
+	}
+	if len(account.Tags) == 0 {
+		account.Tags = nil
+	}
+	return &account, nil
+}
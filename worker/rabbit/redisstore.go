@@ -0,0 +1,189 @@
+package rabbit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/ottogiron/metricsworker/tracing"
+	"github.com/ottogiron/metricsworker/worker"
+)
+
+var _ worker.EventStore = (*RedisEventStore)(nil)
+var _ worker.EventReader = (*RedisEventStore)(nil)
+var _ worker.Lifecycle = (*RedisEventStore)(nil)
+var _ worker.SeenStore = (*RedisSeenStore)(nil)
+var _ worker.Lifecycle = (*RedisSeenStore)(nil)
+
+const seenPrefix = "seen:"
+
+//Values of the seen keys, a claimed key is being processed
+const (
+	seenClaimed   = "claimed"
+	seenProcessed = "processed"
+)
+
+//eventsKey sorted set indexing the event ids by timestamp
+const eventsKey = "events"
+
+//RedisEventStore redis implementation of an event store
+type RedisEventStore struct {
+	rclient *redis.Client
+}
+
+//NewRedisEventStore returns a new instance of a redis event store
+func NewRedisEventStore(client *redis.Client) *RedisEventStore {
+	return &RedisEventStore{client}
+}
+
+//Init checks the redis connection
+func (s *RedisEventStore) Init(ctx context.Context) error {
+	return pingRedis(s.rclient)
+}
+
+//Close closes the redis client
+func (s *RedisEventStore) Close(ctx context.Context) error {
+	return s.rclient.Close()
+}
+
+//NextID returns the next id for a metric
+func (s *RedisEventStore) NextID(ctx context.Context, metric string) (int64, error) {
+	_, span := tracing.Start(ctx, "redis.incr")
+	span.SetAttribute("db.system", "redis")
+	id, err := s.rclient.Incr(idCounter + ":" + metric).Result()
+	span.SetError(err)
+	span.End()
+	return id, err
+}
+
+//SaveEvent stores the event as a hash and adds its id to the events sorted set
+func (s *RedisEventStore) SaveEvent(ctx context.Context, eventID string, fields map[string]interface{}, timestamp int64) error {
+	_, span := tracing.Start(ctx, "redis.pipeline")
+	span.SetAttribute("db.system", "redis")
+	defer span.End()
+	p := s.rclient.Pipeline()
+
+	p.HMSet(eventID, fields)
+	p.ZAdd(eventsKey, redis.Z{
+		Score:  float64(timestamp),
+		Member: eventID,
+	})
+
+	_, err := p.Exec()
+	span.SetError(err)
+	return err
+}
+
+//FindEvents reads the ids of the latest events between from and to from the events sorted set, and their fields
+//from the event hashes. Events whose hash is missing are skipped
+func (s *RedisEventStore) FindEvents(ctx context.Context, from, to time.Time, limit int) (events []worker.Event, err error) {
+	_, span := tracing.Start(ctx, "redis.zrevrangebyscore")
+	span.SetAttribute("db.system", "redis")
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+	scores := redis.ZRangeBy{Min: "-inf", Max: "+inf", Count: int64(limit)}
+	if !from.IsZero() {
+		scores.Min = strconv.FormatInt(from.Unix(), 10)
+	}
+	if !to.IsZero() {
+		scores.Max = strconv.FormatInt(to.Unix(), 10)
+	}
+	ids, err := s.rclient.ZRevRangeByScoreWithScores(eventsKey, scores).Result()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the events index %s", err)
+	}
+	if len(ids) == 0 {
+		return []worker.Event{}, nil
+	}
+	p := s.rclient.Pipeline()
+	hashes := make([]*redis.StringStringMapCmd, len(ids))
+	for i, id := range ids {
+		hashes[i] = p.HGetAll(fmt.Sprint(id.Member))
+	}
+	_, err = p.Exec()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read the events %s", err)
+	}
+	events = make([]worker.Event, 0, len(ids))
+	for i, id := range ids {
+		hash := hashes[i].Val()
+		if len(hash) == 0 {
+			continue
+		}
+		fields := make(map[string]interface{}, len(hash))
+		for name, value := range hash {
+			fields[name] = value
+		}
+		metric, err := worker.EventMetric(fields)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid event %v %s", id.Member, err)
+		}
+		events = append(events, worker.Event{ID: fmt.Sprint(id.Member), Timestamp: time.Unix(int64(id.Score), 0).UTC(), Metric: metric})
+	}
+	return events, nil
+}
+
+//RedisSeenStore redis implementation of a seen store shared by every consumer
+type RedisSeenStore struct {
+	rclient  *redis.Client
+	ttl      time.Duration
+	claimTTL time.Duration
+}
+
+//NewRedisSeenStore returns a new instance of a redis seen store keeping processed keys for ttl. Claimed keys expire
+//after claimTTL, so the messages of a consumer which crashed while processing them are processed when redelivered
+func NewRedisSeenStore(client *redis.Client, ttl, claimTTL time.Duration) *RedisSeenStore {
+	return &RedisSeenStore{rclient: client, ttl: ttl, claimTTL: claimTTL}
+}
+
+//Init checks the redis connection
+func (s *RedisSeenStore) Init(ctx context.Context) error {
+	return pingRedis(s.rclient)
+}
+
+//Close closes the redis client
+func (s *RedisSeenStore) Close(ctx context.Context) error {
+	return s.rclient.Close()
+}
+
+//Claim claims a key with SETNX, or returns its state if it is already set
+func (s *RedisSeenStore) Claim(key string) (worker.SeenState, error) {
+	claimed, err := s.rclient.SetNX(seenPrefix+key, seenClaimed, s.claimTTL).Result()
+	if err != nil {
+		return worker.SeenClaimed, err
+	}
+	if claimed {
+		return worker.SeenClaimed, nil
+	}
+	value, err := s.rclient.Get(seenPrefix + key).Result()
+	if err != nil && err != redis.Nil {
+		return worker.SeenClaimed, err
+	}
+	if value == seenProcessed {
+		return worker.SeenProcessed, nil
+	}
+	//a key released or expired meanwhile is reported as being processed, so its message is requeued and claimed again
+	return worker.SeenProcessing, nil
+}
+
+//MarkSeen marks a key as processed for the ttl
+func (s *RedisSeenStore) MarkSeen(key string) error {
+	return s.rclient.Set(seenPrefix+key, seenProcessed, s.ttl).Err()
+}
+
+//Release deletes a claimed key
+func (s *RedisSeenStore) Release(key string) error {
+	return s.rclient.Del(seenPrefix + key).Err()
+}
+
+func pingRedis(client *redis.Client) error {
+	err := client.Ping().Err()
+	if err != nil {
+		return fmt.Errorf("Failed to connect to redis %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+//go:build integration
+// +build integration
+
+package rabbit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ottogiron/metricsworker/worker"
+)
+
+func TestRedisSeenStoreIntegration(t *testing.T) {
+	client, clean := testRedisClient(t)
+	defer clean()
+
+	s := NewRedisSeenStore(client, time.Minute, time.Minute)
+	claim := func(key string, want worker.SeenState) {
+		t.Helper()
+		got, err := s.Claim(key)
+		if err != nil {
+			t.Fatalf("RedisSeenStore.Claim() error = %v", err)
+		}
+		if got != want {
+			t.Errorf("RedisSeenStore.Claim(%s) = %v want %v", key, got, want)
+		}
+	}
+	claim("id:1", worker.SeenClaimed)
+	claim("id:1", worker.SeenProcessing)
+	if err := s.MarkSeen("id:1"); err != nil {
+		t.Fatalf("RedisSeenStore.MarkSeen() error = %v", err)
+	}
+	claim("id:1", worker.SeenProcessed)
+
+	claim("id:2", worker.SeenClaimed)
+	if err := s.Release("id:2"); err != nil {
+		t.Fatalf("RedisSeenStore.Release() error = %v", err)
+	}
+	claim("id:2", worker.SeenClaimed)
+}
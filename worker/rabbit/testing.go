@@ -13,3 +13,11 @@ var invalidPayload = []byte(`{
   "metric": "kite_call" 
 }
 `)
+
+var taggedPayload = []byte(`{
+  "username": "kodingbot",
+  "count": 1,
+  "metric": "kite_call",
+  "tags": {"region": "eu", "plan": "free"}
+}
+`)
@@ -0,0 +1,124 @@
+package worker
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+//StatsDUserTag DogStatsD tag the username of StatsD metrics is read from, it is removed from the metric tags
+const StatsDUserTag = "username"
+
+var _ Codec = StatsDParser{}
+
+//StatsDParser parses StatsD lines, see ParseStatsD
+type StatsDParser struct {
+	//DefaultUserName username of the metrics without username tag. Metrics without username are invalid if it is empty
+	DefaultUserName string
+}
+
+//UnmarshallStatsDMetrics unmarshalls newline delimited StatsD lines to metrics, see ParseStatsD. Empty lines are skipped
+func UnmarshallStatsDMetrics(body []byte) ([]TypedMetric, error) {
+	return StatsDParser{}.Decode(body)
+}
+
+//ParseStatsD parses a StatsD line with optional DogStatsD sample rate and tags e.g. kite_call:1|c|@0.5|#username:kodingbot,region:eu.
+//Types are c for counts, g for gauges, ms, h and d for histograms and s for sets. Counts are divided by the sample rate,
+//negative counts decrement the count. Gauge values with a sign e.g. +3 or -3 are deltas of the gauge value, see
+//GaugeMetric.Delta, values without sign are absolute. The username is read from the username tag
+func ParseStatsD(line string) (TypedMetric, error) {
+	return StatsDParser{}.Parse(line)
+}
+
+//Decode unmarshalls newline delimited StatsD lines to metrics. Empty lines are skipped
+func (p StatsDParser) Decode(body []byte) ([]TypedMetric, error) {
+	var metrics []TypedMetric
+	for i, line := range bytes.Split(body, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		metric, err := p.Parse(string(line))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid StatsD line %d %s", i+1, err)
+		}
+		metrics = append(metrics, metric)
+	}
+	if len(metrics) == 0 {
+		return nil, errors.New("Failed to parse StatsD body without metrics")
+	}
+	return metrics, nil
+}
+
+//Parse parses a StatsD line, see ParseStatsD. Metrics without username tag get the default username
+func (p StatsDParser) Parse(line string) (TypedMetric, error) {
+	sections := strings.Split(line, "|")
+	if len(sections) < 2 {
+		return nil, fmt.Errorf("StatsD line should be metric:value|type %q", line)
+	}
+	separator := strings.LastIndex(sections[0], ":")
+	if separator <= 0 {
+		return nil, fmt.Errorf("StatsD line should be metric:value|type %q", line)
+	}
+	name, value := sections[0][:separator], sections[0][separator+1:]
+	rate := 1.0
+	var tags map[string]string
+	for _, section := range sections[2:] {
+		switch {
+		case strings.HasPrefix(section, "@"):
+			r, err := strconv.ParseFloat(section[1:], 64)
+			if err != nil || r <= 0 || r > 1 {
+				return nil, fmt.Errorf("StatsD sample rate should be a number in (0, 1] %q", section)
+			}
+			rate = r
+		case strings.HasPrefix(section, "#"):
+			tags = make(map[string]string)
+			for _, tag := range strings.Split(section[1:], ",") {
+				if tag == "" {
+					continue
+				}
+				parts := strings.SplitN(tag, ":", 2)
+				if len(parts) == 1 {
+					tags[parts[0]] = ""
+					continue
+				}
+				tags[parts[0]] = parts[1]
+			}
+		}
+	}
+	username, ok := tags[StatsDUserTag]
+	if !ok {
+		username = p.DefaultUserName
+	}
+	delete(tags, StatsDUserTag)
+	if len(tags) == 0 {
+		tags = nil
+	}
+	switch sections[1] {
+	case "c":
+		count, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("StatsD count should be a number %q", value)
+		}
+		return &CountMetric{UserName: username, Metric: name, Tags: tags, Count: int64(math.Floor(count/rate + 0.5))}, nil
+	case "g":
+		gauge, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("StatsD gauge should be a number %q", value)
+		}
+		delta := strings.HasPrefix(value, "+") || strings.HasPrefix(value, "-")
+		return &GaugeMetric{UserName: username, Metric: name, Tags: tags, Value: gauge, Delta: delta}, nil
+	case "ms", "h", "d":
+		observed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("StatsD %s value should be a number %q", sections[1], value)
+		}
+		return &HistogramMetric{UserName: username, Metric: name, Tags: tags, Value: observed}, nil
+	case "s":
+		return &SetMetric{UserName: username, Metric: name, Tags: tags, Member: value}, nil
+	}
+	return nil, fmt.Errorf("Unknown StatsD type %s", sections[1])
+}
@@ -0,0 +1,164 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//ErrNotFound is returned by the readers when the requested record does not exist
+var ErrNotFound = errors.New("not found")
+
+//EventTagPrefix prefix of the event fields holding the metric tags e.g. tag:region
+const EventTagPrefix = "tag:"
+
+//EventStore defines the storage used to keep distinct metric events. The context carries the span context of the calling worker
+type EventStore interface {
+	//NextID returns the next sequential id for a metric
+	NextID(ctx context.Context, metric string) (int64, error)
+	//SaveEvent stores the event fields and indexes the event id by timestamp
+	SaveEvent(ctx context.Context, eventID string, fields map[string]interface{}, timestamp int64) error
+}
+
+//HourlyLogStore defines the storage used to keep hourly metric logs
+type HourlyLogStore interface {
+	//InsertMetric stores a metric in the hourly log
+	InsertMetric(ctx context.Context, metric *CountMetric) error
+	//AggregateMetric adds a metric observed at timestamp to its hourly aggregate, see Aggregate.Add
+	AggregateMetric(ctx context.Context, metric TypedMetric, timestamp time.Time) error
+}
+
+//AccountStore defines the storage used to keep accounts
+type AccountStore interface {
+	//InsertAccount stores an account if it does not exist already and merges the tags into the account tags
+	InsertAccount(ctx context.Context, username string, tags map[string]string, timestamp int64) error
+}
+
+//SeenState state of a message key in a seen store
+type SeenState int
+
+//Seen states
+const (
+	//SeenClaimed the key was claimed by the caller, which should process its message
+	SeenClaimed SeenState = iota
+	//SeenProcessing the key is claimed by a consumer still processing its message
+	SeenProcessing
+	//SeenProcessed the message of the key was processed
+	SeenProcessed
+)
+
+//SeenStore defines the storage used to keep the keys of the messages being processed and already processed, so
+//duplicated messages are skipped
+type SeenStore interface {
+	//Claim claims a key before its message is processed, atomically across the consumers sharing the store.
+	//It returns SeenClaimed if the key was claimed, or its current state otherwise
+	Claim(key string) (SeenState, error)
+	//MarkSeen marks a claimed key as processed, so the duplicates of its message are skipped
+	MarkSeen(key string) error
+	//Release releases a claimed key whose message failed, so it is processed again when redelivered
+	Release(key string) error
+}
+
+//Event a distinct metric event read from an event store
+type Event struct {
+	ID        string      `json:"id"`
+	Timestamp time.Time   `json:"timestamp"`
+	Metric    TypedMetric `json:"metric"`
+}
+
+//MarshalJSON marshals the event metric along with its type, see MarshallMetric
+func (e Event) MarshalJSON() ([]byte, error) {
+	metric, err := MarshallMetric(e.Metric)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		ID        string          `json:"id"`
+		Timestamp time.Time       `json:"timestamp"`
+		Metric    json.RawMessage `json:"metric"`
+	}{e.ID, e.Timestamp, metric})
+}
+
+//EventReader defines the queries of the events kept by an event store
+type EventReader interface {
+	//FindEvents returns the latest events stored between from and to inclusive ordered by timestamp, newest first.
+	//Zero times are unbounded and at most limit events are returned, 0 is unlimited
+	FindEvents(ctx context.Context, from, to time.Time, limit int) ([]Event, error)
+}
+
+//AggregateQuery selects hourly aggregates, empty fields match every aggregate
+type AggregateQuery struct {
+	Type     MetricType
+	UserName string
+	//Metric name of the metrics, the aggregates of every series of the metric match
+	Metric string
+	//From and To hours the aggregates are in, inclusive
+	From time.Time
+	To   time.Time
+	//Limit is the maximum number of aggregates returned, unbounded if 0
+	Limit int
+}
+
+//AggregateReader defines the queries of the aggregates kept by an hourly log store
+type AggregateReader interface {
+	//FindAggregates returns the hourly aggregates matching a query ordered by hour, type, key and username
+	FindAggregates(ctx context.Context, query AggregateQuery) ([]Aggregate, error)
+}
+
+//Account an account read from an account store
+type Account struct {
+	UserName  string            `json:"username"`
+	Tags      map[string]string `json:"tags,omitempty"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+//AccountReader defines the queries of the accounts kept by an account store
+type AccountReader interface {
+	//FindAccounts returns the accounts ordered by username skipping offset accounts. At most limit accounts are
+	//returned, 0 is unlimited
+	FindAccounts(ctx context.Context, offset, limit int) ([]Account, error)
+	//FindAccount returns an account by username, or ErrNotFound
+	FindAccount(ctx context.Context, username string) (*Account, error)
+}
+
+//EventMetric returns the metric of the fields of a stored event, see EventStore.SaveEvent. Values are either the
+//decoded JSON values or strings as they are read from redis hashes
+func EventMetric(fields map[string]interface{}) (TypedMetric, error) {
+	decoded := make(map[string]interface{}, len(fields))
+	var tags map[string]interface{}
+	for field, value := range fields {
+		if strings.HasPrefix(field, EventTagPrefix) {
+			if tags == nil {
+				tags = make(map[string]interface{})
+			}
+			tags[strings.TrimPrefix(field, EventTagPrefix)] = value
+			continue
+		}
+		decoded[field] = value
+	}
+	if tags != nil {
+		decoded["tags"] = tags
+	}
+	//redis hashes hold the numbers and booleans as strings
+	for _, field := range []string{"count", "value"} {
+		if s, ok := decoded[field].(string); ok {
+			number, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s should be a number %q", field, s)
+			}
+			decoded[field] = number
+		}
+	}
+	if s, ok := decoded["delta"].(string); ok {
+		delta, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("delta should be a boolean %q", s)
+		}
+		decoded["delta"] = delta
+	}
+	return metricFromFields(decoded)
+}
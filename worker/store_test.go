@@ -0,0 +1,94 @@
+package worker
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEventMetric(t *testing.T) {
+	tests := []struct {
+		name    string
+		fields  map[string]interface{}
+		want    TypedMetric
+		wantErr bool
+	}{
+		{
+			"JSON values",
+			map[string]interface{}{"username": "kodingbot", "metric": "kite_call", "count": float64(2), "tag:region": "eu"},
+			&CountMetric{UserName: "kodingbot", Metric: "kite_call", Count: 2, Tags: map[string]string{"region": "eu"}},
+			false,
+		},
+		{
+			"Redis hash values",
+			map[string]interface{}{"username": "kodingbot", "metric": "kite_call", "count": "12412414"},
+			&CountMetric{UserName: "kodingbot", Metric: "kite_call", Count: 12412414},
+			false,
+		},
+		{
+			"Gauge",
+			map[string]interface{}{"type": "gauge", "username": "kodingbot", "metric": "connections", "value": "2.5", "delta": "1"},
+			&GaugeMetric{UserName: "kodingbot", Metric: "connections", Value: 2.5, Delta: true},
+			false,
+		},
+		{
+			"Histogram",
+			map[string]interface{}{"type": "histogram", "username": "kodingbot", "metric": "kite_call_ms", "value": float64(230)},
+			&HistogramMetric{UserName: "kodingbot", Metric: "kite_call_ms", Value: 230},
+			false,
+		},
+		{
+			"Set",
+			map[string]interface{}{"type": "set", "username": "kodingbot", "metric": "visitors", "member": "v1", "tag:region": "eu"},
+			&SetMetric{UserName: "kodingbot", Metric: "visitors", Member: "v1", Tags: map[string]string{"region": "eu"}},
+			false,
+		},
+		{"Unknown type", map[string]interface{}{"type": "meter", "username": "kodingbot", "metric": "kite_call"}, nil, true},
+		{"Invalid count", map[string]interface{}{"username": "kodingbot", "metric": "kite_call", "count": "one"}, nil, true},
+		{"Fractional count", map[string]interface{}{"username": "kodingbot", "metric": "kite_call", "count": "1.5"}, nil, true},
+		{"Invalid tag", map[string]interface{}{"username": "kodingbot", "metric": "kite_call", "tag:region": 1.0}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := EventMetric(tt.fields)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("EventMetric() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("EventMetric() = %+v want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvent_MarshalJSON(t *testing.T) {
+	timestamp := time.Date(2017, 6, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		event Event
+		want  string
+	}{
+		{
+			"Count",
+			Event{ID: "kite_call:1", Timestamp: timestamp, Metric: &CountMetric{UserName: "kodingbot", Metric: "kite_call", Count: 1}},
+			`{"id":"kite_call:1","timestamp":"2017-06-01T10:00:00Z","metric":{"username":"kodingbot","count":1,"metric":"kite_call"}}`,
+		},
+		{
+			"Set",
+			Event{ID: "visitors:1", Timestamp: timestamp, Metric: &SetMetric{UserName: "kodingbot", Metric: "visitors", Member: "v1"}},
+			`{"id":"visitors:1","timestamp":"2017-06-01T10:00:00Z","metric":{"type":"set","username":"kodingbot","metric":"visitors","member":"v1"}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(tt.event)
+			if err != nil {
+				t.Fatalf("Event.MarshalJSON() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Event.MarshalJSON() = %s want %s", got, tt.want)
+			}
+		})
+	}
+}
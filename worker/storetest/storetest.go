@@ -0,0 +1,322 @@
+//Package storetest provides in-memory implementations of the worker stores for testing purposes
+package storetest
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ottogiron/metricsworker/worker"
+)
+
+var _ worker.EventStore = (*EventStore)(nil)
+var _ worker.HourlyLogStore = (*HourlyLogStore)(nil)
+var _ worker.AccountStore = (*AccountStore)(nil)
+var _ worker.EventReader = (*EventStore)(nil)
+var _ worker.AggregateReader = (*HourlyLogStore)(nil)
+var _ worker.AccountReader = (*AccountStore)(nil)
+
+//Event represents a stored event
+type Event struct {
+	ID        string
+	Fields    map[string]interface{}
+	Timestamp int64
+}
+
+//EventStore in-memory implementation of worker.EventStore
+type EventStore struct {
+	mu     sync.Mutex
+	ids    map[string]int64
+	events map[string]Event
+	//Err if set is returned by every store operation
+	Err error
+}
+
+//NewEventStore returns a new instance of an in-memory event store
+func NewEventStore() *EventStore {
+	return &EventStore{
+		ids:    make(map[string]int64),
+		events: make(map[string]Event),
+	}
+}
+
+//NextID returns the next id for a metric
+func (s *EventStore) NextID(ctx context.Context, metric string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Err != nil {
+		return 0, s.Err
+	}
+	s.ids[metric]++
+	return s.ids[metric], nil
+}
+
+//SaveEvent stores an event
+func (s *EventStore) SaveEvent(ctx context.Context, eventID string, fields map[string]interface{}, timestamp int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Err != nil {
+		return s.Err
+	}
+	s.events[eventID] = Event{ID: eventID, Fields: fields, Timestamp: timestamp}
+	return nil
+}
+
+//Events returns the stored events ordered by timestamp
+func (s *EventStore) Events() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	events := make([]Event, 0, len(s.events))
+	for _, e := range s.events {
+		events = append(events, e)
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Timestamp == events[j].Timestamp {
+			return events[i].ID < events[j].ID
+		}
+		return events[i].Timestamp < events[j].Timestamp
+	})
+	return events
+}
+
+//FindEvents returns the latest events stored between from and to ordered by timestamp, newest first
+func (s *EventStore) FindEvents(ctx context.Context, from, to time.Time, limit int) ([]worker.Event, error) {
+	s.mu.Lock()
+	err := s.Err
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	events := []worker.Event{}
+	stored := s.Events()
+	for i := len(stored) - 1; i >= 0; i-- {
+		e := stored[i]
+		if (!from.IsZero() && e.Timestamp < from.Unix()) || (!to.IsZero() && e.Timestamp > to.Unix()) {
+			continue
+		}
+		if limit > 0 && len(events) == limit {
+			break
+		}
+		metric, err := worker.EventMetric(e.Fields)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, worker.Event{ID: e.ID, Timestamp: time.Unix(e.Timestamp, 0).UTC(), Metric: metric})
+	}
+	return events, nil
+}
+
+//HourlyLogStore in-memory implementation of worker.HourlyLogStore
+type HourlyLogStore struct {
+	mu         sync.Mutex
+	metrics    []worker.CountMetric
+	aggregates map[aggregateKey]*worker.Aggregate
+	//Err if set is returned by every store operation
+	Err error
+}
+
+//aggregateKey identifies an hourly aggregate
+type aggregateKey struct {
+	metricType worker.MetricType
+	userName   string
+	key        string
+	hour       time.Time
+}
+
+//NewHourlyLogStore returns a new instance of an in-memory hourly log store
+func NewHourlyLogStore() *HourlyLogStore {
+	return &HourlyLogStore{aggregates: make(map[aggregateKey]*worker.Aggregate)}
+}
+
+//InsertMetric stores a metric
+func (s *HourlyLogStore) InsertMetric(ctx context.Context, metric *worker.CountMetric) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Err != nil {
+		return s.Err
+	}
+	s.metrics = append(s.metrics, *metric)
+	return nil
+}
+
+//AggregateMetric adds a metric to its hourly aggregate
+func (s *HourlyLogStore) AggregateMetric(ctx context.Context, metric worker.TypedMetric, timestamp time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Err != nil {
+		return s.Err
+	}
+	aggregate := worker.NewAggregate(metric, timestamp)
+	key := aggregateKey{aggregate.Type, aggregate.UserName, aggregate.Key, aggregate.Hour}
+	if current, ok := s.aggregates[key]; ok {
+		aggregate = current
+	}
+	aggregate.Add(metric)
+	s.aggregates[key] = aggregate
+	return nil
+}
+
+//Aggregates returns the hourly aggregates ordered by hour, type, key and username
+func (s *HourlyLogStore) Aggregates() []worker.Aggregate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	aggregates := make([]worker.Aggregate, 0, len(s.aggregates))
+	for _, a := range s.aggregates {
+		aggregate := *a
+		aggregate.Members = append([]string(nil), a.Members...)
+		aggregates = append(aggregates, aggregate)
+	}
+	sort.Slice(aggregates, func(i, j int) bool {
+		a, b := aggregates[i], aggregates[j]
+		if !a.Hour.Equal(b.Hour) {
+			return a.Hour.Before(b.Hour)
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		}
+		return a.UserName < b.UserName
+	})
+	return aggregates
+}
+
+//FindAggregates returns the hourly aggregates matching a query ordered by hour, type, key and username
+func (s *HourlyLogStore) FindAggregates(ctx context.Context, query worker.AggregateQuery) ([]worker.Aggregate, error) {
+	s.mu.Lock()
+	err := s.Err
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	aggregates := []worker.Aggregate{}
+	for _, a := range s.Aggregates() {
+		switch {
+		case query.Type != "" && a.Type != query.Type,
+			query.UserName != "" && a.UserName != query.UserName,
+			query.Metric != "" && a.Key != query.Metric && !strings.HasPrefix(a.Key, query.Metric+","),
+			!query.From.IsZero() && a.Hour.Before(query.From.UTC().Truncate(time.Hour)),
+			!query.To.IsZero() && a.Hour.After(query.To):
+			continue
+		}
+		if query.Limit > 0 && len(aggregates) == query.Limit {
+			break
+		}
+		aggregates = append(aggregates, a)
+	}
+	return aggregates, nil
+}
+
+//Metrics returns the stored metrics in insertion order
+func (s *HourlyLogStore) Metrics() []worker.CountMetric {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	metrics := make([]worker.CountMetric, len(s.metrics))
+	copy(metrics, s.metrics)
+	return metrics
+}
+
+//AccountStore in-memory implementation of worker.AccountStore
+type AccountStore struct {
+	mu       sync.Mutex
+	accounts map[string]int64
+	tags     map[string]map[string]string
+	//Err if set is returned by every store operation
+	Err error
+}
+
+//NewAccountStore returns a new instance of an in-memory account store
+func NewAccountStore() *AccountStore {
+	return &AccountStore{accounts: make(map[string]int64), tags: make(map[string]map[string]string)}
+}
+
+//InsertAccount stores an account if it does not exist already and merges the tags into the account tags
+func (s *AccountStore) InsertAccount(ctx context.Context, username string, tags map[string]string, timestamp int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Err != nil {
+		return s.Err
+	}
+	if _, ok := s.accounts[username]; !ok {
+		s.accounts[username] = timestamp
+		s.tags[username] = make(map[string]string)
+	}
+	for name, value := range tags {
+		s.tags[username][name] = value
+	}
+	return nil
+}
+
+//Account returns the timestamp an account was first stored with
+func (s *AccountStore) Account(username string) (int64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	timestamp, ok := s.accounts[username]
+	return timestamp, ok
+}
+
+//Tags returns the tags of an account
+func (s *AccountStore) Tags(username string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tags := make(map[string]string, len(s.tags[username]))
+	for name, value := range s.tags[username] {
+		tags[name] = value
+	}
+	return tags
+}
+
+//FindAccounts returns the accounts ordered by username
+func (s *AccountStore) FindAccounts(ctx context.Context, offset, limit int) ([]worker.Account, error) {
+	s.mu.Lock()
+	if s.Err != nil {
+		s.mu.Unlock()
+		return nil, s.Err
+	}
+	usernames := make([]string, 0, len(s.accounts))
+	for username := range s.accounts {
+		usernames = append(usernames, username)
+	}
+	s.mu.Unlock()
+	sort.Strings(usernames)
+	if offset > len(usernames) {
+		offset = len(usernames)
+	}
+	usernames = usernames[offset:]
+	if limit > 0 && limit < len(usernames) {
+		usernames = usernames[:limit]
+	}
+	accounts := make([]worker.Account, 0, len(usernames))
+	for _, username := range usernames {
+		account, err := s.FindAccount(ctx, username)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, *account)
+	}
+	return accounts, nil
+}
+
+//FindAccount returns an account by username, or worker.ErrNotFound
+func (s *AccountStore) FindAccount(ctx context.Context, username string) (*worker.Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	timestamp, ok := s.accounts[username]
+	if !ok {
+		return nil, worker.ErrNotFound
+	}
+	account := &worker.Account{UserName: username, Timestamp: time.Unix(timestamp, 0).UTC()}
+	for name, value := range s.tags[username] {
+		if account.Tags == nil {
+			account.Tags = make(map[string]string)
+		}
+		account.Tags[name] = value
+	}
+	return account, nil
+}
@@ -0,0 +1,258 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+//MetricType type of a metric, it determines how the metrics are aggregated
+type MetricType string
+
+//Available metric types
+const (
+	//MetricTypeCount counts of events, they are added up. Metrics without type are counts
+	MetricTypeCount MetricType = "count"
+	//MetricTypeGauge measures, the last value is kept
+	MetricTypeGauge MetricType = "gauge"
+	//MetricTypeHistogram observed values e.g. timings, their count, sum, min and max are kept
+	MetricTypeHistogram MetricType = "histogram"
+	//MetricTypeSet members e.g. visitor ids, the distinct members are kept
+	MetricTypeSet MetricType = "set"
+)
+
+//Identity fields every metric has
+type Identity struct {
+	UserName string
+	Metric   string
+	Tags     map[string]string
+}
+
+//SeriesKey returns the metric name along with its tags ordered by name e.g. kite_call,plan=free,region=eu.
+//Metrics with the same key belong to the same series
+func (id Identity) SeriesKey() string {
+	if len(id.Tags) == 0 {
+		return id.Metric
+	}
+	names := make([]string, 0, len(id.Tags))
+	for name := range id.Tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	key := make([]string, 0, len(names)+1)
+	key = append(key, id.Metric)
+	for _, name := range names {
+		key = append(key, name+"="+id.Tags[name])
+	}
+	return strings.Join(key, ",")
+}
+
+func (id Identity) validate() error {
+	if id.UserName == "" {
+		return errors.New("username is required")
+	}
+	if id.Metric == "" {
+		return errors.New("metric is required")
+	}
+	for name := range id.Tags {
+		if name == "" {
+			return errors.New("tag names should not be empty")
+		}
+	}
+	return nil
+}
+
+//TypedMetric metric of any type
+type TypedMetric interface {
+	//MetricType returns the type of the metric
+	MetricType() MetricType
+	//Identity returns the username, name and tags of the metric
+	Identity() Identity
+	//Validate checks the metric has the required fields
+	Validate() error
+}
+
+var _ TypedMetric = (*CountMetric)(nil)
+var _ TypedMetric = (*GaugeMetric)(nil)
+var _ TypedMetric = (*HistogramMetric)(nil)
+var _ TypedMetric = (*SetMetric)(nil)
+
+//GaugeMetric represents the value of a measure e.g. the number of open connections
+type GaugeMetric struct {
+	UserName string            `json:"username"`
+	Metric   string            `json:"metric"`
+	Tags     map[string]string `json:"tags,omitempty"`
+	Value    float64           `json:"value"`
+	//Delta whether the value is added to the current gauge value instead of replacing it
+	Delta bool `json:"delta,omitempty"`
+}
+
+//MetricType returns MetricTypeGauge
+func (m *GaugeMetric) MetricType() MetricType { return MetricTypeGauge }
+
+//Identity returns the username, name and tags of the metric
+func (m *GaugeMetric) Identity() Identity { return Identity{m.UserName, m.Metric, m.Tags} }
+
+//Validate checks the metric has the required fields
+func (m *GaugeMetric) Validate() error { return m.Identity().validate() }
+
+//HistogramMetric represents an observed value e.g. the duration of a call in milliseconds
+type HistogramMetric struct {
+	UserName string            `json:"username"`
+	Metric   string            `json:"metric"`
+	Tags     map[string]string `json:"tags,omitempty"`
+	Value    float64           `json:"value"`
+}
+
+//MetricType returns MetricTypeHistogram
+func (m *HistogramMetric) MetricType() MetricType { return MetricTypeHistogram }
+
+//Identity returns the username, name and tags of the metric
+func (m *HistogramMetric) Identity() Identity { return Identity{m.UserName, m.Metric, m.Tags} }
+
+//Validate checks the metric has the required fields
+func (m *HistogramMetric) Validate() error { return m.Identity().validate() }
+
+//SetMetric represents a member of a set e.g. a visitor id
+type SetMetric struct {
+	UserName string            `json:"username"`
+	Metric   string            `json:"metric"`
+	Tags     map[string]string `json:"tags,omitempty"`
+	Member   string            `json:"member"`
+}
+
+//MetricType returns MetricTypeSet
+func (m *SetMetric) MetricType() MetricType { return MetricTypeSet }
+
+//Identity returns the username, name and tags of the metric
+func (m *SetMetric) Identity() Identity { return Identity{m.UserName, m.Metric, m.Tags} }
+
+//Validate checks the metric has the required fields
+func (m *SetMetric) Validate() error {
+	err := m.Identity().validate()
+	if err != nil {
+		return err
+	}
+	if m.Member == "" {
+		return errors.New("member is required")
+	}
+	return nil
+}
+
+//MetricDecoder decodes the body of a metric of a type
+type MetricDecoder func(body []byte) (TypedMetric, error)
+
+//metricDecoders decoders by metric type
+var metricDecoders = map[MetricType]MetricDecoder{
+	MetricTypeCount: func(body []byte) (TypedMetric, error) {
+		return UnmarshallCountMetric(body)
+	},
+	MetricTypeGauge: func(body []byte) (TypedMetric, error) {
+		var metric GaugeMetric
+		return &metric, json.Unmarshal(body, &metric)
+	},
+	MetricTypeHistogram: func(body []byte) (TypedMetric, error) {
+		var metric HistogramMetric
+		return &metric, json.Unmarshal(body, &metric)
+	},
+	MetricTypeSet: func(body []byte) (TypedMetric, error) {
+		var metric SetMetric
+		return &metric, json.Unmarshal(body, &metric)
+	},
+}
+
+//UnmarshallMetricType returns the type of a metric body. Bodies without type are counts
+func UnmarshallMetricType(body []byte) (MetricType, error) {
+	var discriminator struct {
+		Type MetricType `json:"type"`
+	}
+	err := json.Unmarshal(body, &discriminator)
+	if err != nil {
+		return "", fmt.Errorf("Failed to parse rabbit delivery body %s", err)
+	}
+	if discriminator.Type == "" {
+		return MetricTypeCount, nil
+	}
+	return discriminator.Type, nil
+}
+
+//UnmarshallMetric unmarshalls an array of bytes to a metric of the type in its type field. Bodies without type
+//are unmarshalled to a CountMetric
+func UnmarshallMetric(body []byte) (TypedMetric, error) {
+	metricType, err := UnmarshallMetricType(body)
+	if err != nil {
+		return nil, err
+	}
+	decode, ok := metricDecoders[metricType]
+	if !ok {
+		return nil, fmt.Errorf("Unknown metric type %s", metricType)
+	}
+	metric, err := decode(body)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse %s metric %s", metricType, err)
+	}
+	return metric, nil
+}
+
+//Aggregate aggregation of the metrics of a type, user and series in an hour
+type Aggregate struct {
+	Type     MetricType `json:"type"`
+	UserName string     `json:"username"`
+	//Key series key of the metrics, see Identity.SeriesKey
+	Key  string    `json:"key"`
+	Hour time.Time `json:"hour"`
+	//Value last value of a gauge
+	Value float64 `json:"value"`
+	//Count, Sum, Min and Max of the values of a histogram
+	Count int64   `json:"count"`
+	Sum   float64 `json:"sum"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	//Members distinct members of a set
+	Members []string `json:"members,omitempty"`
+}
+
+//NewAggregate returns a new instance of the aggregate a metric observed at timestamp belongs to
+func NewAggregate(metric TypedMetric, timestamp time.Time) *Aggregate {
+	id := metric.Identity()
+	return &Aggregate{
+		Type:     metric.MetricType(),
+		UserName: id.UserName,
+		Key:      id.SeriesKey(),
+		Hour:     timestamp.UTC().Truncate(time.Hour),
+	}
+}
+
+//Add aggregates a metric of the aggregate type: gauges replace the value or add their delta to it, histogram values are counted, added up and
+//tracked as min and max and set members are added if they are not members already. Counts are added up
+func (a *Aggregate) Add(metric TypedMetric) {
+	switch m := metric.(type) {
+	case *CountMetric:
+		a.Count += m.Count
+	case *GaugeMetric:
+		if m.Delta {
+			a.Value += m.Value
+			return
+		}
+		a.Value = m.Value
+	case *HistogramMetric:
+		if a.Count == 0 || m.Value < a.Min {
+			a.Min = m.Value
+		}
+		if a.Count == 0 || m.Value > a.Max {
+			a.Max = m.Value
+		}
+		a.Count++
+		a.Sum += m.Value
+	case *SetMetric:
+		for _, member := range a.Members {
+			if member == m.Member {
+				return
+			}
+		}
+		a.Members = append(a.Members, m.Member)
+	}
+}
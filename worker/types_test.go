@@ -0,0 +1,150 @@
+package worker
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestUnmarshallMetric(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    TypedMetric
+		wantErr bool
+	}{
+		{
+			"Count without type",
+			`{"username": "kodingbot", "count": 2, "metric": "kite_call"}`,
+			&CountMetric{UserName: "kodingbot", Count: 2, Metric: "kite_call"},
+			false,
+		},
+		{
+			"Count",
+			`{"type": "count", "username": "kodingbot", "count": 2, "metric": "kite_call"}`,
+			&CountMetric{UserName: "kodingbot", Count: 2, Metric: "kite_call"},
+			false,
+		},
+		{
+			"Gauge",
+			`{"type": "gauge", "username": "kodingbot", "metric": "connections", "value": 12.5, "tags": {"region": "eu"}}`,
+			&GaugeMetric{UserName: "kodingbot", Metric: "connections", Value: 12.5, Tags: map[string]string{"region": "eu"}},
+			false,
+		},
+		{
+			"Histogram",
+			`{"type": "histogram", "username": "kodingbot", "metric": "kite_call_ms", "value": 230}`,
+			&HistogramMetric{UserName: "kodingbot", Metric: "kite_call_ms", Value: 230},
+			false,
+		},
+		{
+			"Set",
+			`{"type": "set", "username": "kodingbot", "metric": "visitors", "member": "v1"}`,
+			&SetMetric{UserName: "kodingbot", Metric: "visitors", Member: "v1"},
+			false,
+		},
+		{"Unknown type", `{"type": "summary", "username": "kodingbot", "metric": "kite_call"}`, nil, true},
+		{"Invalid type", `{"type": 1, "username": "kodingbot", "metric": "kite_call"}`, nil, true},
+		{"Invalid value", `{"type": "gauge", "username": "kodingbot", "metric": "connections", "value": "high"}`, nil, true},
+		{"Invalid body", `{"type": "gauge"`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := UnmarshallMetric([]byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UnmarshallMetric() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("UnmarshallMetric() = %+v want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTypedMetric_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		metric  TypedMetric
+		wantErr bool
+	}{
+		{"Valid gauge", &GaugeMetric{UserName: "kodingbot", Metric: "connections", Value: -1}, false},
+		{"Gauge missing metric", &GaugeMetric{UserName: "kodingbot"}, true},
+		{"Valid histogram", &HistogramMetric{UserName: "kodingbot", Metric: "kite_call_ms"}, false},
+		{"Histogram missing username", &HistogramMetric{Metric: "kite_call_ms"}, true},
+		{"Histogram empty tag name", &HistogramMetric{UserName: "kodingbot", Metric: "kite_call_ms", Tags: map[string]string{"": "eu"}}, true},
+		{"Valid set", &SetMetric{UserName: "kodingbot", Metric: "visitors", Member: "v1"}, false},
+		{"Set missing member", &SetMetric{UserName: "kodingbot", Metric: "visitors"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.metric.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("%T.Validate() error = %v, wantErr %v", tt.metric, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAggregate_Add(t *testing.T) {
+	timestamp := time.Date(2017, 3, 1, 10, 42, 0, 0, time.UTC)
+	tags := map[string]string{"region": "eu"}
+	tests := []struct {
+		name    string
+		metrics []TypedMetric
+		want    Aggregate
+	}{
+		{
+			"Counts are added up",
+			[]TypedMetric{
+				&CountMetric{UserName: "kodingbot", Metric: "kite_call", Count: 2},
+				&CountMetric{UserName: "kodingbot", Metric: "kite_call", Count: 3},
+			},
+			Aggregate{Type: MetricTypeCount, UserName: "kodingbot", Key: "kite_call", Count: 5},
+		},
+		{
+			"Gauges keep the last value",
+			[]TypedMetric{
+				&GaugeMetric{UserName: "kodingbot", Metric: "connections", Tags: tags, Value: 3},
+				&GaugeMetric{UserName: "kodingbot", Metric: "connections", Tags: tags, Value: 1},
+			},
+			Aggregate{Type: MetricTypeGauge, UserName: "kodingbot", Key: "connections,region=eu", Value: 1},
+		},
+		{
+			"Gauge deltas are added to the value",
+			[]TypedMetric{
+				&GaugeMetric{UserName: "kodingbot", Metric: "connections", Value: 3},
+				&GaugeMetric{UserName: "kodingbot", Metric: "connections", Value: -1, Delta: true},
+			},
+			Aggregate{Type: MetricTypeGauge, UserName: "kodingbot", Key: "connections", Value: 2},
+		},
+		{
+			"Histograms keep count, sum, min and max",
+			[]TypedMetric{
+				&HistogramMetric{UserName: "kodingbot", Metric: "kite_call_ms", Value: 20},
+				&HistogramMetric{UserName: "kodingbot", Metric: "kite_call_ms", Value: 5},
+				&HistogramMetric{UserName: "kodingbot", Metric: "kite_call_ms", Value: 50},
+			},
+			Aggregate{Type: MetricTypeHistogram, UserName: "kodingbot", Key: "kite_call_ms", Count: 3, Sum: 75, Min: 5, Max: 50},
+		},
+		{
+			"Sets keep distinct members",
+			[]TypedMetric{
+				&SetMetric{UserName: "kodingbot", Metric: "visitors", Member: "v1"},
+				&SetMetric{UserName: "kodingbot", Metric: "visitors", Member: "v2"},
+				&SetMetric{UserName: "kodingbot", Metric: "visitors", Member: "v1"},
+			},
+			Aggregate{Type: MetricTypeSet, UserName: "kodingbot", Key: "visitors", Members: []string{"v1", "v2"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewAggregate(tt.metrics[0], timestamp)
+			for _, metric := range tt.metrics {
+				got.Add(metric)
+			}
+			tt.want.Hour = time.Date(2017, 3, 1, 10, 0, 0, 0, time.UTC)
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("Aggregate.Add() = %+v want %+v", *got, tt.want)
+			}
+		})
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 
 	"fmt"
+
+	"github.com/streadway/amqp"
 )
 
 //UnmarshallCountMetric unmarshalls an array of bytes to a CountMetric
@@ -25,3 +27,27 @@ func UnmarshallCountMetricToMapInterface(body []byte) (map[string]interface{}, e
 	}
 	return metric, nil
 }
+
+//TaskFields returns the fields identifying a rabbit delivery task for logging: message_id, metric and username.
+//Fields which are not available are omitted
+func TaskFields(task interface{}) map[string]interface{} {
+	fields := make(map[string]interface{})
+	delivery, ok := task.(amqp.Delivery)
+	if !ok {
+		return fields
+	}
+	if delivery.MessageId != "" {
+		fields["message_id"] = delivery.MessageId
+	}
+	var metric CountMetric
+	if json.Unmarshal(delivery.Body, &metric) != nil {
+		return fields
+	}
+	if metric.Metric != "" {
+		fields["metric"] = metric.Metric
+	}
+	if metric.UserName != "" {
+		fields["username"] = metric.UserName
+	}
+	return fields
+}
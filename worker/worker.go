@@ -1,6 +1,50 @@
 package worker
 
+import "context"
+
 //Worker defines  a worker task processor
 type Worker interface {
 	Execute(task interface{}) error
 }
+
+//Lifecycle is implemented by workers and stores holding resources such as connections. Init acquires and verifies
+//the resources before the first execution and Close releases them after the last one
+type Lifecycle interface {
+	Init(ctx context.Context) error
+	Close(ctx context.Context) error
+}
+
+//Init initializes v if it implements Lifecycle
+func Init(ctx context.Context, v interface{}) error {
+	if l, ok := v.(Lifecycle); ok {
+		return l.Init(ctx)
+	}
+	return nil
+}
+
+//Close closes v if it implements Lifecycle
+func Close(ctx context.Context, v interface{}) error {
+	if l, ok := v.(Lifecycle); ok {
+		return l.Close(ctx)
+	}
+	return nil
+}
+
+//Func adapts a function to a Worker
+type Func func(task interface{}) error
+
+//Execute calls f(task)
+func (f Func) Execute(task interface{}) error {
+	return f(task)
+}
+
+//Middleware wraps a worker to add behaviour around its executions
+type Middleware func(Worker) Worker
+
+//Chain wraps a worker with middlewares. The first middleware is the outermost one
+func Chain(w Worker, middlewares ...Middleware) Worker {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		w = middlewares[i](w)
+	}
+	return w
+}